@@ -0,0 +1,113 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/auth"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader is the header carrying the ID of the request.  The ID sent by the client is
+// reused when valid, otherwise a new one is generated.  Either way, it's returned in the response.
+const RequestIDHeader = "X-Request-Id"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type contextKey struct{}
+
+// RequestIDFromContext returns the ID of the request, or an empty string if there's none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// responseRecorder captures the status code and the size of the response written by the handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// Unwrap returns the underlying ResponseWriter, giving http.ResponseController access to it
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Middleware implements mux.MiddlewareFunc.  It assigns an ID to every request, returned in the
+// X-Request-Id header and available to the handlers through RequestIDFromContext, and logs the
+// request once served according to the access_log settings of the current configuration.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, requestID))
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		logConf := config.Current().AccessLog
+		if !logConf.Enabled || logConf.Excluded(r.URL.Path) {
+			return
+		}
+		if rec.status < http.StatusBadRequest && !sampled(logConf.SampleRatio) {
+			return
+		}
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		identity := ""
+		if id := auth.RequestIdentity(r); id != nil {
+			identity = id.Name
+		}
+
+		logger.Logger.Info("Request served",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("route", route),
+			zap.Int("status", rec.status),
+			zap.Int("bytes", rec.bytes),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("identity", identity),
+			zap.String("request_id", requestID),
+		)
+	})
+}
+
+// sampled returns true for the given fraction of the calls
+func sampled(ratio float64) bool {
+	return ratio >= 1 || mathrand.Float64() < ratio
+}
@@ -0,0 +1,307 @@
+package audit
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/accesslog"
+	"github.com/hartfordfive/prom-http-sd-server/auth"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// Entry describes a request which attempted to modify the target groups
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Identity  string    `json:"identity,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route,omitempty"`
+	Status    int       `json:"status"`
+	// Groups are the target groups the request modifies, and Changes the targets, labels and
+	// templates it adds or removes.  The requests described by their whole body, the
+	// transactions and the imports, record it as Body instead.
+	Groups        []string           `json:"groups,omitempty"`
+	Changes       []*store.GroupDiff `json:"changes,omitempty"`
+	Body          string             `json:"body,omitempty"`
+	BodyTruncated bool               `json:"body_truncated,omitempty"`
+}
+
+// maxBodySize is the size of the request bodies kept in the entries, the rest is left out
+const maxBodySize = 64 << 10
+
+// details are the target groups and changes recorded by the handler of a request, added to its
+// entry once it's served
+type details struct {
+	groups  []string
+	changes []*store.GroupDiff
+	body    []byte
+}
+
+type detailsKey struct{}
+
+// Record adds the changes made by the request, and the target groups they modify, to its entry.
+// The handlers record them before the changes are applied, so that the entry describes the
+// attempted changes when they fail.  It does nothing for the requests which aren't recorded.
+func Record(ctx context.Context, changes ...*store.GroupDiff) {
+	d, _ := ctx.Value(detailsKey{}).(*details)
+	if d == nil {
+		return
+	}
+	d.changes = append(d.changes, changes...)
+	for _, c := range changes {
+		d.addGroup(c.Name)
+	}
+}
+
+// RecordGroups adds the target groups modified by the request to its entry
+func RecordGroups(ctx context.Context, groups ...string) {
+	d, _ := ctx.Value(detailsKey{}).(*details)
+	if d == nil {
+		return
+	}
+	for _, g := range groups {
+		d.addGroup(g)
+	}
+}
+
+// RecordBody adds the body of the request to its entry, for the requests whose changes can only
+// be described by their body
+func RecordBody(ctx context.Context, body []byte) {
+	if d, _ := ctx.Value(detailsKey{}).(*details); d != nil {
+		d.body = body
+	}
+}
+
+func (d *details) addGroup(name string) {
+	for _, g := range d.groups {
+		if g == name {
+			return
+		}
+	}
+	d.groups = append(d.groups, name)
+}
+
+// fill sets the recorded details on the entry
+func (d *details) fill(e *Entry) {
+	e.Groups, e.Changes = d.groups, d.changes
+	body := d.body
+	if len(body) > maxBodySize {
+		body, e.BodyTruncated = body[:maxBodySize], true
+	}
+	e.Body = string(body)
+}
+
+var auditBucket = []byte("audit")
+
+// Log keeps the most recent entries, in memory or in a BoltDB file when it's opened with OpenLog
+type Log struct {
+	mu      sync.Mutex
+	db      *bolt.DB
+	size    int
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewLog creates a log keeping the last size entries in memory
+func NewLog(size int) *Log {
+	return &Log{size: size, entries: make([]Entry, size)}
+}
+
+// OpenLog opens the log stored in the BoltDB file at path, keeping the last size entries.  The
+// oldest entries are removed when the file holds more of them.
+func OpenLog(path string, size int) (*Log, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Could not open audit log: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(auditBucket)
+		if err != nil {
+			return err
+		}
+		extra := b.Stats().KeyN - size
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && extra > 0; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			extra--
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not create audit log: %s", err)
+	}
+	return &Log{db: db, size: size}, nil
+}
+
+// Close closes the file of the log, if any
+func (l *Log) Close() error {
+	if l.db == nil {
+		return nil
+	}
+	return l.db.Close()
+}
+
+// Add records the entry, replacing the oldest one when the log is full
+func (l *Log) Add(e Entry) {
+	if l.db != nil {
+		if err := l.store(e); err != nil {
+			logger.Logger.Error("Could not write audit log entry", zap.String("request_id", e.RequestID), zap.Error(err))
+		}
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// store writes the entry to the file under the next sequence number, and removes the entry
+// which is now one too many
+func (l *Log) store(e Entry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(sequenceKey(seq), v); err != nil {
+			return err
+		}
+		if seq > uint64(l.size) {
+			return b.Delete(sequenceKey(seq - uint64(l.size)))
+		}
+		return nil
+	})
+}
+
+// sequenceKey encodes the sequence number so that the keys are sorted in the order of the entries
+func sequenceKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// Recent returns up to limit entries, the most recent first
+func (l *Log) Recent(limit int) ([]Entry, error) {
+	if l.db != nil {
+		return l.recentStored(limit)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	if limit <= 0 || limit > count {
+		limit = count
+	}
+	res := make([]Entry, 0, limit)
+	for i := 1; i <= limit; i++ {
+		res = append(res, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return res, nil
+}
+
+func (l *Log) recentStored(limit int) ([]Entry, error) {
+	if limit <= 0 || limit > l.size {
+		limit = l.size
+	}
+	res := []Entry{}
+	err := l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(res) < limit; k, v = c.Prev() {
+			e := Entry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			res = append(res, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not read audit log: %s", err)
+	}
+	return res, nil
+}
+
+// DefaultLog is the log the requests are recorded to by Middleware, replaced by the server with
+// the log opened from the configured file
+var DefaultLog = NewLog(1000)
+
+// statusRecorder captures the status code of the response written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// Unwrap returns the underlying ResponseWriter, giving http.ResponseController access to it
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware implements mux.MiddlewareFunc.  It records every request which isn't read-only,
+// along with its caller, the status of the response and the changes recorded by the handler, to
+// DefaultLog.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		d := &details{}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), detailsKey{}, d)))
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		identity := ""
+		if id := auth.RequestIdentity(r); id != nil {
+			identity = id.Name
+		}
+		e := Entry{
+			Time:      start,
+			Identity:  identity,
+			RequestID: accesslog.RequestIDFromContext(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Route:     route,
+			Status:    rec.status,
+		}
+		d.fill(&e)
+		DefaultLog.Add(e)
+	})
+}
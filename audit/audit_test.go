@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/auth"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+func paths(entries []Entry) string {
+	res := []string{}
+	for _, e := range entries {
+		res = append(res, e.Path)
+	}
+	return fmt.Sprint(res)
+}
+
+func addEntries(l *Log, from, to int) {
+	for i := from; i <= to; i++ {
+		l.Add(Entry{Method: "POST", Path: fmt.Sprintf("/%d", i), Status: 200})
+	}
+}
+
+func TestLogKeepsMostRecentEntries(t *testing.T) {
+	l := NewLog(3)
+	addEntries(l, 1, 4)
+	entries, err := l.Recent(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(entries); got != "[/4 /3 /2]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestOpenLogPersistsEntries(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	path := filepath.Join(t.TempDir(), "audit.db")
+
+	l, err := OpenLog(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addEntries(l, 1, 4)
+	entries, err := l.Recent(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(entries); got != "[/4 /3]" {
+		t.Errorf("got %s", got)
+	}
+	l.Close()
+
+	// The entries are kept across restarts, and trimmed when fewer of them are kept
+	l, err = OpenLog(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	entries, err = l.Recent(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(entries); got != "[/4 /3]" {
+		t.Errorf("got %s after reopening", got)
+	}
+	addEntries(l, 5, 5)
+	entries, err = l.Recent(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(entries); got != "[/5 /4]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestMiddlewareRecordsChanges(t *testing.T) {
+	previous := DefaultLog
+	DefaultLog = NewLog(10)
+	defer func() { DefaultLog = previous }()
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/groups/web/targets":
+			Record(r.Context(), &store.GroupDiff{Name: "web", AddedTargets: []string{"a:80"}})
+		case "/api/txn":
+			RecordBody(r.Context(), []byte(strings.Repeat("x", maxBodySize+1)))
+			RecordGroups(r.Context(), "web", "db", "web")
+		}
+	}))
+	for _, path := range []string{"/api/v2/groups/web/targets", "/api/txn"} {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(auth.NewContext(r.Context(), &auth.Identity{Name: "ci"})))
+	}
+	// Nothing is recorded for the read-only requests
+	Record(context.Background(), &store.GroupDiff{Name: "ignored"})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v2/groups/web/targets", nil))
+
+	entries, err := DefaultLog.Recent(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	txn, add := entries[0], entries[1]
+	if add.Identity != "ci" || !reflect.DeepEqual(add.Groups, []string{"web"}) || len(add.Changes) != 1 || add.Changes[0].AddedTargets[0] != "a:80" || add.Body != "" {
+		t.Errorf("got entry %+v", add)
+	}
+	if !reflect.DeepEqual(txn.Groups, []string{"web", "db"}) || len(txn.Changes) != 0 {
+		t.Errorf("got groups %v and changes %v", txn.Groups, txn.Changes)
+	}
+	if len(txn.Body) != maxBodySize || !txn.BodyTruncated {
+		t.Errorf("got a body of %d bytes, truncated: %t", len(txn.Body), txn.BodyTruncated)
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+)
+
+type contextKey struct{}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Name  string
+	Admin bool
+}
+
+// FromContext returns the identity of the authenticated caller, or nil if the request wasn't
+// authenticated
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// NewContext returns a copy of ctx carrying the identity
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// Authenticate returns the identity matching the bearer token, or nil if it doesn't match any
+// of the configured tokens.
+func Authenticate(authConf *config.AuthConfig, token string) *Identity {
+	if authConf == nil || token == "" {
+		return nil
+	}
+	for _, t := range authConf.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return &Identity{Name: t.Name, Admin: t.Admin}
+		}
+	}
+	return nil
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// RequestIdentity returns the identity of the caller of the request, authenticating it from its
+// bearer token when the identity isn't already in the request context.
+func RequestIdentity(r *http.Request) *Identity {
+	if id := FromContext(r.Context()); id != nil {
+		return id
+	}
+	return Authenticate(config.Current().Auth, bearerToken(r))
+}
+
+// Middleware authenticates requests using the tokens of the current configuration.  When
+// authentication is enabled, requests which modify data must carry a valid bearer token while
+// read-only requests are allowed anonymously.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authConf := config.Current().Auth
+		if !authConf.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := Authenticate(authConf, bearerToken(r))
+		if id != nil {
+			r = r.WithContext(NewContext(r.Context(), id))
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if id == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, r, http.StatusUnauthorized, "unauthenticated", "A valid bearer token is required")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin only allows requests authenticated with an admin token.  When authentication
+// is disabled, admin endpoints are not available.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := RequestIdentity(r)
+		if id == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, "unauthenticated", "A valid bearer token is required")
+			return
+		}
+		if !id.Admin {
+			writeError(w, r, http.StatusForbidden, "permission_denied", "An admin token is required")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// writeError writes the error as a JSON error object for the v2 API, and as plain text otherwise
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	if !strings.HasPrefix(r.URL.Path, "/api/v2/") {
+		http.Error(w, "ERROR: "+msg, status)
+		return
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": msg},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
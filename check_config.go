@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+)
+
+// runCheckConfig validates a configuration file, reporting every problem found
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	schema := fs.Bool("schema", false, "Print the JSON schema of the configuration file and exit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *schema {
+		b, err := config.Schema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not generate schema: %s\n", err)
+			return 1
+		}
+		fmt.Printf("%s\n", b)
+		return 0
+	}
+
+	// The file can be given as an argument, otherwise the -conf flag is used
+	confPath := *flagConfPath
+	if fs.NArg() > 0 {
+		confPath = fs.Arg(0)
+	}
+
+	problems := config.Check(confPath, config.EnvOverrides(), flagOverrides.Overrides())
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Configuration %s is invalid:\n", confPath)
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", p)
+		}
+		return 1
+	}
+	fmt.Printf("Configuration %s is valid\n", confPath)
+	return 0
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/importer"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"gopkg.in/yaml.v2"
+)
+
+// AddTarget adds the target to the target group, creating the group if it doesn't exist
+func (c *Client) AddTarget(ctx context.Context, targetGroup, target string) error {
+	_, err := c.AddTargets(ctx, targetGroup, []string{target})
+	return err
+}
+
+// RemoveTarget removes the target from the target group
+func (c *Client) RemoveTarget(ctx context.Context, targetGroup, target string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v2/groups/%s/targets/%s", url.PathEscape(targetGroup), url.PathEscape(target)),
+	})
+	return err
+}
+
+// RemoveTargetGroup deletes the target group along with all of its targets and labels
+func (c *Client) RemoveTargetGroup(ctx context.Context, targetGroup string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v2/groups/%s", url.PathEscape(targetGroup)),
+	})
+	return err
+}
+
+// GetLabels returns the labels of the target group
+func (c *Client) GetLabels(ctx context.Context, targetGroup string) (map[string]string, error) {
+	res := &labelsBody{}
+	if err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/api/v2/groups/%s/labels", url.PathEscape(targetGroup)), nil, res); err != nil {
+		return nil, err
+	}
+	if res.Labels == nil {
+		res.Labels = map[string]string{}
+	}
+	return res.Labels, nil
+}
+
+// AddLabels adds or updates the labels of the target group, creating the group if it doesn't exist
+func (c *Client) AddLabels(ctx context.Context, targetGroup string, labels map[string]string) error {
+	_, err := c.PatchLabels(ctx, targetGroup, labels, nil)
+	return err
+}
+
+// RemoveLabel removes the label from the target group
+func (c *Client) RemoveLabel(ctx context.Context, targetGroup, label string) error {
+	_, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   fmt.Sprintf("/api/v2/groups/%s/labels/%s", url.PathEscape(targetGroup), url.PathEscape(label)),
+	})
+	return err
+}
+
+// GetTargets returns the target groups in the Prometheus HTTP SD format
+func (c *Client) GetTargets(ctx context.Context) ([]store.TargetGroup, error) {
+	b, err := c.do(ctx, request{method: http.MethodGet, path: "/api/targets"})
+	if err != nil {
+		return nil, err
+	}
+	groups := []store.TargetGroup{}
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return nil, fmt.Errorf("Could not decode target groups: %s", err)
+	}
+	return groups, nil
+}
+
+// GetTargetGroups returns every target group keyed by target group name, with its version in
+// the metadata, reading them one page at a time
+func (c *Client) GetTargetGroups(ctx context.Context) (map[string]*store.TargetGroup, error) {
+	groups := map[string]*store.TargetGroup{}
+	pageToken := ""
+	for {
+		page, err := c.ListGroups(ctx, maxPageSize, pageToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range page.Groups {
+			groups[g.Name] = g.TargetGroup()
+		}
+		if page.NextPageToken == "" {
+			return groups, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// GetConfig returns the configuration the server has been started with
+func (c *Client) GetConfig(ctx context.Context) (*config.Config, error) {
+	b, err := c.do(ctx, request{method: http.MethodGet, path: "/debug_config"})
+	if err != nil {
+		return nil, err
+	}
+	cnf := &config.Config{}
+	if err := yaml.Unmarshal(b, cnf); err != nil {
+		return nil, fmt.Errorf("Could not decode config: %s", err)
+	}
+	return cnf, nil
+}
+
+// Health returns nil if the server reports itself as healthy
+func (c *Client) Health(ctx context.Context) error {
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/health"})
+	return err
+}
+
+// Metrics returns the Prometheus metrics of the server in the text exposition format
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	b, err := c.do(ctx, request{method: http.MethodGet, path: "/metrics"})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ImportFileSD imports the target groups of a Prometheus file_sd file, in JSON or YAML, naming
+// them after name.  With dryRun, the changes are returned without being applied.
+func (c *Client) ImportFileSD(ctx context.Context, name, format string, data []byte, prune, dryRun bool) (*importer.Result, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("format", format)
+	return c.importTargetGroups(ctx, "/api/import/file_sd", query, data, prune, dryRun)
+}
+
+// ImportStaticConfigs imports the target groups of the static_configs blocks of a prometheus.yml
+// configuration file.  With dryRun, the changes are returned without being applied.
+func (c *Client) ImportStaticConfigs(ctx context.Context, data []byte, prune, dryRun bool) (*importer.Result, error) {
+	return c.importTargetGroups(ctx, "/api/import/static_configs", url.Values{}, data, prune, dryRun)
+}
+
+// ImportCSV imports target groups from CSV rows of group,target[,label=value...].  With dryRun,
+// the changes are returned without being applied.
+func (c *Client) ImportCSV(ctx context.Context, data []byte, prune, dryRun bool) (*importer.Result, error) {
+	return c.importTargetGroups(ctx, "/api/import/csv", url.Values{}, data, prune, dryRun)
+}
+
+// ImportAnsibleInventory imports the host groups of an Ansible inventory, in INI or YAML, with
+// port appended to the address of every target unless it's zero.  With dryRun, the changes are
+// returned without being applied.
+func (c *Client) ImportAnsibleInventory(ctx context.Context, format string, port int, data []byte, prune, dryRun bool) (*importer.Result, error) {
+	query := url.Values{}
+	query.Set("format", format)
+	if port != 0 {
+		query.Set("port", strconv.Itoa(port))
+	}
+	return c.importTargetGroups(ctx, "/api/import/ansible", query, data, prune, dryRun)
+}
+
+func (c *Client) importTargetGroups(ctx context.Context, path string, query url.Values, data []byte, prune, dryRun bool) (*importer.Result, error) {
+	query.Set("prune", strconv.FormatBool(prune))
+	query.Set("dry_run", strconv.FormatBool(dryRun))
+	b, err := c.do(ctx, request{
+		method:      http.MethodPost,
+		path:        path,
+		query:       query,
+		body:        data,
+		contentType: "application/octet-stream",
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &importer.Result{}
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, fmt.Errorf("Could not decode import result: %s", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 250 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// Client is a client for the prom-http-sd-server REST API.  It's safe for concurrent use.
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	headers      http.Header
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithHTTPClient sets the HTTP client used to send requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the timeout of each individual HTTP request, including retries
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// WithRetries sets the maximum number of times a failed request is retried and the initial
+// backoff between attempts, which doubles after every attempt.  Only connection errors and
+// 429, 502, 503 and 504 responses are retried.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// WithBearerToken sets the token sent in the Authorization header of every request
+func WithBearerToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithBasicAuth sets the basic auth credentials sent with every request
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		c.headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+// WithHeader sets a header sent with every request
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		c.headers.Set(name, value)
+	}
+}
+
+// NewClient creates a new Client for the server listening at baseURL, for example
+// http://127.0.0.1:80
+func NewClient(baseURL string, opts ...Option) (*Client, error) {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL '%s': %s", baseURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid server URL '%s': missing host", baseURL)
+	}
+
+	c := &Client{
+		baseURL:      strings.TrimSuffix(u.String(), "/"),
+		httpClient:   &http.Client{Timeout: defaultTimeout},
+		headers:      http.Header{},
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+type ifMatchKey struct{}
+
+// WithIfMatch returns a copy of ctx making the changes of a target group sent with it conditional
+// on the group still being at the version, as returned by GetGroup.  The server rejects the change
+// with a 412 status, see IsPreconditionFailed, when the group was modified in the meantime.
+func WithIfMatch(ctx context.Context, version uint64) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, version)
+}
+
+// request is a single API request
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+}
+
+// do sends the request, retrying it on transient failures, and returns the response body.
+// Non-2xx responses are returned as an *APIError.
+func (c *Client) do(ctx context.Context, r request) ([]byte, error) {
+	reqURL := c.baseURL + r.path
+	if len(r.query) > 0 {
+		reqURL += "?" + r.query.Encode()
+	}
+
+	backoff := c.retryBackoff
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt > 0 {
+			// Add up to 20% of jitter so that concurrent clients don't retry in lockstep
+			wait := backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+			if backoff *= 2; backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+		}
+
+		body, retry, err := c.send(ctx, reqURL, r)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		if !retry || ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// doJSON sends the request with the JSON encoding of in as body, unless it's nil, and decodes the
+// response body into out, unless it's nil
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	r := request{method: method, path: path}
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		r.body = b
+		r.contentType = "application/json"
+	}
+	b, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return fmt.Errorf("Could not decode response of %s %s: %s", method, path, err)
+		}
+	}
+	return nil
+}
+
+// send sends a single attempt of the request and reports whether it can be retried
+func (c *Client) send(ctx context.Context, reqURL string, r request) ([]byte, bool, error) {
+	var body io.Reader
+	if r.body != nil {
+		body = strings.NewReader(string(r.body))
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, reqURL, body)
+	if err != nil {
+		return nil, false, err
+	}
+	for name, values := range c.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if version, ok := ctx.Value(ifMatchKey{}).(uint64); ok {
+		req.Header.Set("If-Match", strconv.Quote(strconv.FormatUint(version, 10)))
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		apiErr := newAPIError(r.method, r.path, res.StatusCode, b)
+		return nil, apiErr.Temporary(), apiErr
+	}
+	return b, false, nil
+}
@@ -0,0 +1,344 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// newTestClient returns a client of a server answering with handler, retrying quickly
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := NewClient(srv.URL, append([]Option{WithRetries(3, time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	return c
+}
+
+func TestRetriedStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			var calls int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= 2 {
+					http.Error(w, "ERROR: try again", status)
+					return
+				}
+				fmt.Fprint(w, "OK")
+			})
+			if err := c.Health(context.Background()); err != nil {
+				t.Fatalf("Health: %s", err)
+			}
+			if calls != 3 {
+				t.Errorf("got %d calls, want 3", calls)
+			}
+		})
+	}
+}
+
+func TestNotRetriedStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusInternalServerError} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			var calls int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				http.Error(w, "ERROR: failed", status)
+			})
+			err := c.Health(context.Background())
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != status {
+				t.Fatalf("got error %v, want an APIError with status %d", err, status)
+			}
+			if calls != 1 {
+				t.Errorf("got %d calls, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "ERROR: unavailable", http.StatusServiceUnavailable)
+	}, WithRetries(2, time.Millisecond))
+
+	err := c.Health(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.Temporary() {
+		t.Fatalf("got error %v, want a temporary APIError", err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}, WithRetries(2, 20*time.Millisecond))
+
+	start := time.Now()
+	if err := c.Health(context.Background()); err != nil {
+		t.Fatalf("Health: %s", err)
+	}
+	// The backoff doubles after every attempt: 20ms then 40ms, plus up to 20% of jitter
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("retried after %s, want at least 60ms", elapsed)
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, WithRetries(5, time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Health(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func TestConnectionErrorsRetried(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	c, err := NewClient(srv.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	var apiErr *APIError
+	if err := c.Health(context.Background()); err == nil || errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want a connection error", err)
+	}
+}
+
+func TestTimeoutPerRequest(t *testing.T) {
+	var calls int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}
+
+	// The attempt timing out is retried, and the next one gets a full timeout
+	c := newTestClient(t, handler, WithTimeout(50*time.Millisecond), WithRetries(1, time.Millisecond))
+	start := time.Now()
+	if err := c.Health(context.Background()); err != nil {
+		t.Fatalf("Health: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s, want the first attempt to time out after 50ms", elapsed)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+
+	calls = 0
+	c = newTestClient(t, handler, WithTimeout(50*time.Millisecond), WithRetries(0, time.Millisecond))
+	if err := c.Health(context.Background()); err == nil {
+		t.Fatal("got no error, want a timeout")
+	}
+}
+
+func TestAuthHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		want string
+	}{
+		{"bearer", WithBearerToken("s3cret"), "Bearer s3cret"},
+		{"basic", WithBasicAuth("user", "pass"), "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("Authorization")
+			}, tt.opt)
+			if err := c.Health(context.Background()); err != nil {
+				t.Fatalf("Health: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got Authorization %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIfMatchHeader(t *testing.T) {
+	got := ""
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("If-Match")
+	})
+	if err := c.RemoveTargetGroup(WithIfMatch(context.Background(), 7), "web"); err != nil {
+		t.Fatalf("RemoveTargetGroup: %s", err)
+	}
+	if got != `"7"` {
+		t.Errorf("got If-Match %q, want %q", got, `"7"`)
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		code    string
+		message string
+		details []string
+	}{
+		{"v1", http.StatusNotFound, "ERROR: Target group web doesn't exist\n", "", "Target group web doesn't exist", nil},
+		{"v2", http.StatusBadRequest, `{"error":{"code":"invalid_argument","message":"Invalid targets","details":["a","b"]}}`,
+			"invalid_argument", "Invalid targets", []string{"a", "b"}},
+		{"empty", http.StatusBadGateway, "", "", "Bad Gateway", nil},
+		{"not an error object", http.StatusInternalServerError, `{"foo":1}`, "", `{"foo":1}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newAPIError(http.MethodGet, "/api/x", tt.status, []byte(tt.body))
+			if e.StatusCode != tt.status || e.Code != tt.code || e.Message != tt.message || fmt.Sprint(e.Details) != fmt.Sprint(tt.details) {
+				t.Errorf("got %+v", e)
+			}
+		})
+	}
+
+	e := newAPIError(http.MethodPost, "/api/v2/groups/web/targets", http.StatusBadRequest,
+		[]byte(`{"error":{"code":"invalid_argument","message":"Invalid targets","details":["a","b"]}}`))
+	want := "POST /api/v2/groups/web/targets failed with status 400: Invalid targets (a; b)"
+	if e.Error() != want {
+		t.Errorf("got %q, want %q", e.Error(), want)
+	}
+
+	checks := []struct {
+		status int
+		is     func(error) bool
+	}{
+		{http.StatusNotFound, IsNotFound},
+		{http.StatusBadRequest, IsBadRequest},
+		{http.StatusUnauthorized, IsUnauthorized},
+		{http.StatusForbidden, IsUnauthorized},
+		{http.StatusConflict, IsConflict},
+		{http.StatusPreconditionFailed, IsPreconditionFailed},
+	}
+	for _, c := range checks {
+		err := fmt.Errorf("wrapped: %w", newAPIError(http.MethodGet, "/", c.status, nil))
+		if !c.is(err) {
+			t.Errorf("status %d not matched", c.status)
+		}
+		if c.is(newAPIError(http.MethodGet, "/", http.StatusTeapot, nil)) {
+			t.Errorf("status %d matched by the check of %d", http.StatusTeapot, c.status)
+		}
+	}
+}
+
+func TestGetTargetGroupsPages(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/groups" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("page_token") {
+		case "":
+			fmt.Fprint(w, `{"groups":[{"name":"a","targets":["a:80"],"labels":{"env":"prod"},"templates":[],"version":3}],"next_page_token":"YQ"}`)
+		case "YQ":
+			fmt.Fprint(w, `{"groups":[{"name":"b","targets":["b:80"],"labels":{},"templates":["a"],"version":1}]}`)
+		default:
+			http.Error(w, "unexpected page", http.StatusBadRequest)
+		}
+	})
+
+	groups, err := c.GetTargetGroups(context.Background())
+	if err != nil {
+		t.Fatalf("GetTargetGroups: %s", err)
+	}
+	if len(groups) != 2 || groups["a"].Labels["env"] != "prod" || groups["a"].Metadata.Version != 3 || groups["b"].Templates[0] != "a" {
+		t.Errorf("got %+v", groups)
+	}
+}
+
+func TestApplyTxn(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/txn" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"groups":{"a":{"name":"a","target_count":1,"labels":{},"version":2},"b":null}}`)
+	})
+	txn := &store.Txn{Operations: []*store.TxnOp{
+		{Op: store.TxnAddTarget, Group: "a", Target: "a:80"},
+		{Op: store.TxnDeleteGroup, Group: "b"},
+	}}
+	groups, err := c.ApplyTxn(context.Background(), txn)
+	if err != nil {
+		t.Fatalf("ApplyTxn: %s", err)
+	}
+	if groups["a"].Version != 2 || groups["b"] != nil {
+		t.Errorf("got %+v", groups)
+	}
+}
+
+func TestWatchResumes(t *testing.T) {
+	var conns int32
+	revisions := make(chan string, 2)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		revisions <- r.URL.Query().Get("revision")
+		w.Header().Set("Content-Type", "text/event-stream")
+		if atomic.AddInt32(&conns, 1) == 1 {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			fmt.Fprint(w, "id: 4\nevent: target_added\ndata: {\"revision\":4,\"type\":\"target_added\",\"group\":\"a\",\"target\":\"a:80\"}\n\n")
+			// The stream is interrupted
+			return
+		}
+		fmt.Fprint(w, "id: 5\nevent: target_removed\ndata: {\"revision\":5,\"type\":\"target_removed\",\"group\":\"a\",\"target\":\"a:80\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	done := errors.New("done")
+	events := []string{}
+	err := c.Watch(context.Background(), &WatchQuery{Groups: []string{"a"}}, func(e *store.Event) error {
+		events = append(events, fmt.Sprintf("%d %s %s", e.Revision, e.Type, e.Target))
+		if len(events) == 2 {
+			return done
+		}
+		return nil
+	})
+	if err != done {
+		t.Fatalf("got error %v, want the error of the handler", err)
+	}
+	if got := strings.Join(events, ", "); got != "4 target_added a:80, 5 target_removed a:80" {
+		t.Errorf("got events %s", got)
+	}
+	if first, second := <-revisions, <-revisions; first != "" || second != "4" {
+		t.Errorf("got revisions %q and %q, want the stream resumed from 4", first, second)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError is returned when the server responds with a non-2xx status code
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+	// Code and Details are set from the error objects returned by the v2 API
+	Code    string
+	Details []string
+}
+
+func newAPIError(method, path string, statusCode int, body []byte) *APIError {
+	e := &APIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: statusCode,
+	}
+
+	obj := struct {
+		Error struct {
+			Code    string   `json:"code"`
+			Message string   `json:"message"`
+			Details []string `json:"details"`
+		} `json:"error"`
+	}{}
+	if json.Unmarshal(body, &obj) == nil && obj.Error.Code != "" {
+		e.Code = obj.Error.Code
+		e.Message = obj.Error.Message
+		e.Details = obj.Error.Details
+	} else {
+		e.Message = strings.TrimPrefix(strings.TrimSpace(string(body)), "ERROR: ")
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(statusCode)
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s failed with status %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+	if len(e.Details) > 0 {
+		msg += " (" + strings.Join(e.Details, "; ") + ")"
+	}
+	return msg
+}
+
+// Temporary returns true if the request may succeed when retried
+func (e *APIError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func hasStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// IsNotFound returns true if the error was caused by a target group, target or label which doesn't
+// exist
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsBadRequest returns true if the error was caused by an invalid target, label or parameter
+func IsBadRequest(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// IsUnauthorized returns true if the request was rejected because of missing or invalid credentials
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+// IsConflict returns true if the request conflicts with the current state of the target groups,
+// like a target group which already exists or a concurrent modification
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsPreconditionFailed returns true if the target group changed since the version passed to
+// WithIfMatch, or a condition of a transaction doesn't hold
+func IsPreconditionFailed(err error) bool {
+	return hasStatus(err, http.StatusPreconditionFailed)
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// maxPageSize is the largest page of target groups returned by the server
+const maxPageSize = 1000
+
+// Group is a target group of the v2 API
+type Group struct {
+	Name      string            `json:"name"`
+	Targets   []string          `json:"targets"`
+	Labels    map[string]string `json:"labels"`
+	Templates []string          `json:"templates"`
+	// EffectiveLabels are the labels layered over those of the templates, which are only returned
+	EffectiveLabels map[string]string `json:"effective_labels,omitempty"`
+	// Version increases with every change of the group, see WithIfMatch
+	Version uint64 `json:"version"`
+}
+
+// TargetGroup returns the group as a store.TargetGroup, with its version in the metadata
+func (g *Group) TargetGroup() *store.TargetGroup {
+	return &store.TargetGroup{
+		Name:      g.Name,
+		Targets:   g.Targets,
+		Labels:    g.Labels,
+		Templates: g.Templates,
+		Metadata:  &store.GroupMetadata{Version: g.Version},
+	}
+}
+
+// GroupList is a page of target groups.  NextPageToken is passed to get the following page, and
+// is empty on the last page.
+type GroupList struct {
+	Groups        []*Group `json:"groups"`
+	NextPageToken string   `json:"next_page_token,omitempty"`
+}
+
+// GroupSummary is a target group listed by SearchGroups, without its targets
+type GroupSummary struct {
+	Name            string            `json:"name"`
+	TargetCount     int               `json:"target_count"`
+	Labels          map[string]string `json:"labels"`
+	EffectiveLabels map[string]string `json:"effective_labels"`
+	CreatedAt       *time.Time        `json:"created_at,omitempty"`
+	ModifiedAt      *time.Time        `json:"modified_at,omitempty"`
+	Version         uint64            `json:"version"`
+}
+
+// GroupSummaryList is a page of target groups returned by SearchGroups
+type GroupSummaryList struct {
+	Groups        []*GroupSummary `json:"groups"`
+	NextPageToken string          `json:"next_page_token,omitempty"`
+}
+
+// GroupDetails is a target group returned by GetGroupDetails
+type GroupDetails struct {
+	GroupSummary
+	Targets []string `json:"targets"`
+}
+
+// GroupQuery restricts the target groups returned by SearchGroups.  The zero value returns the
+// first page of every group.
+type GroupQuery struct {
+	// Prefix of the names of the groups
+	Prefix string
+	// Labels the groups must all have
+	Labels    map[string]string
+	PageSize  int
+	PageToken string
+}
+
+type targetsBody struct {
+	Targets []string `json:"targets"`
+}
+
+type labelsBody struct {
+	Labels map[string]string `json:"labels"`
+}
+
+type labelsPatchBody struct {
+	Labels map[string]*string `json:"labels"`
+}
+
+type nameBody struct {
+	Name string `json:"name"`
+}
+
+type mergeBody struct {
+	Into          string `json:"into"`
+	LabelConflict string `json:"label_conflict,omitempty"`
+	RemoveSource  bool   `json:"remove_source"`
+}
+
+type txnResult struct {
+	Groups map[string]*GroupSummary `json:"groups"`
+}
+
+func decode(b []byte, v interface{}, what string) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("Could not decode %s: %s", what, err)
+	}
+	return nil
+}
+
+func groupPath(name string) string {
+	return "/api/v2/groups/" + url.PathEscape(name)
+}
+
+func pageQuery(pageSize int, pageToken string) url.Values {
+	query := url.Values{}
+	if pageSize > 0 {
+		query.Set("page_size", strconv.Itoa(pageSize))
+	}
+	if pageToken != "" {
+		query.Set("page_token", pageToken)
+	}
+	return query
+}
+
+// ListGroups returns a page of at most pageSize target groups sorted by name, the server's
+// default when pageSize is 0, starting after the pageToken of the previous page
+func (c *Client) ListGroups(ctx context.Context, pageSize int, pageToken string) (*GroupList, error) {
+	b, err := c.do(ctx, request{method: http.MethodGet, path: "/api/v2/groups", query: pageQuery(pageSize, pageToken)})
+	if err != nil {
+		return nil, err
+	}
+	res := &GroupList{}
+	if err := decode(b, res, "target groups"); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetGroup returns the target group
+func (c *Client) GetGroup(ctx context.Context, name string) (*Group, error) {
+	res := &Group{}
+	if err := c.doJSON(ctx, http.MethodGet, groupPath(name), nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PutGroup creates the target group, or replaces its targets, labels and templates, and returns
+// the resulting group
+func (c *Client) PutGroup(ctx context.Context, g *Group) (*Group, error) {
+	body := &Group{Name: g.Name, Targets: g.Targets, Labels: g.Labels, Templates: g.Templates}
+	res := &Group{}
+	if err := c.doJSON(ctx, http.MethodPut, groupPath(g.Name), body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddTargets adds the targets to the target group, creating the group if it doesn't exist, and
+// returns all of its targets
+func (c *Client) AddTargets(ctx context.Context, name string, targets []string) ([]string, error) {
+	res := &targetsBody{}
+	if err := c.doJSON(ctx, http.MethodPost, groupPath(name)+"/targets", &targetsBody{Targets: targets}, res); err != nil {
+		return nil, err
+	}
+	return res.Targets, nil
+}
+
+// PatchLabels sets the labels of the target group and removes the removed ones, creating the
+// group if it doesn't exist, and returns all of its labels
+func (c *Client) PatchLabels(ctx context.Context, name string, set map[string]string, removed []string) (map[string]string, error) {
+	body := &labelsPatchBody{Labels: map[string]*string{}}
+	for k, v := range set {
+		v := v
+		body.Labels[k] = &v
+	}
+	for _, k := range removed {
+		body.Labels[k] = nil
+	}
+	res := &labelsBody{}
+	if err := c.doJSON(ctx, http.MethodPatch, groupPath(name)+"/labels", body, res); err != nil {
+		return nil, err
+	}
+	return res.Labels, nil
+}
+
+// RenameGroup atomically renames the target group to newName, which must not exist, and returns
+// the renamed group
+func (c *Client) RenameGroup(ctx context.Context, name, newName string) (*Group, error) {
+	res := &Group{}
+	if err := c.doJSON(ctx, http.MethodPost, groupPath(name)+"/rename", &nameBody{Name: newName}, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CopyGroup creates the target group newName, which must not exist, with the targets and labels
+// of the target group, and returns the new group
+func (c *Client) CopyGroup(ctx context.Context, name, newName string) (*Group, error) {
+	res := &Group{}
+	if err := c.doJSON(ctx, http.MethodPost, groupPath(name)+"/copy", &nameBody{Name: newName}, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MergeGroup atomically adds the targets and labels of the target group to the group into,
+// resolving the conflicting labels with one of the store.LabelConflictPolicies, and removing the
+// merged group with removeSource.  It returns the resulting group.
+func (c *Client) MergeGroup(ctx context.Context, name, into, labelConflict string, removeSource bool) (*Group, error) {
+	body := &mergeBody{Into: into, LabelConflict: labelConflict, RemoveSource: removeSource}
+	res := &Group{}
+	if err := c.doJSON(ctx, http.MethodPost, groupPath(name)+"/merge", body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SearchGroups returns a page of the summaries of the target groups matching the query, sorted
+// by name
+func (c *Client) SearchGroups(ctx context.Context, q *GroupQuery) (*GroupSummaryList, error) {
+	if q == nil {
+		q = &GroupQuery{}
+	}
+	query := pageQuery(q.PageSize, q.PageToken)
+	if q.Prefix != "" {
+		query.Set("prefix", q.Prefix)
+	}
+	for k, v := range q.Labels {
+		query.Add("label", fmt.Sprintf("%s=%s", k, v))
+	}
+	b, err := c.do(ctx, request{method: http.MethodGet, path: "/api/groups", query: query})
+	if err != nil {
+		return nil, err
+	}
+	res := &GroupSummaryList{}
+	if err := decode(b, res, "target groups"); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetGroupDetails returns the targets, labels, creation and modification times of the target group
+func (c *Client) GetGroupDetails(ctx context.Context, name string) (*GroupDetails, error) {
+	res := &GroupDetails{}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/groups/"+url.PathEscape(name), nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ApplyTxn applies the operations of the transaction at once, provided its conditions hold, and
+// returns the summaries of the target groups it changed, nil for the deleted ones.  Nothing is
+// applied when it fails, with a 412 status when a condition doesn't hold.
+func (c *Client) ApplyTxn(ctx context.Context, txn *store.Txn) (map[string]*GroupSummary, error) {
+	res := &txnResult{}
+	if err := c.doJSON(ctx, http.MethodPost, "/api/txn", txn, res); err != nil {
+		return nil, err
+	}
+	return res.Groups, nil
+}
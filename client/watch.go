@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// WatchQuery restricts the events returned by Watch.  The zero value watches every target group
+// from the current state of the store.
+type WatchQuery struct {
+	// Groups whose events are returned, all of them when empty
+	Groups []string
+	// Labels the groups must have, before or after the change
+	Labels map[string]string
+	// Revision of the last event already received, to resume from it.  When not set, the stream
+	// starts with a reset event followed by the events describing every group.
+	Revision *uint64
+}
+
+// Watch calls handle with the events of the target groups streamed by /api/watch, until ctx is
+// done or handle returns an error, which is then returned.  When the stream is interrupted, it's
+// resumed from the last event received, after the same backoff as failed requests.  It fails once
+// reconnecting failed as many times in a row as the requests are retried.
+func (c *Client) Watch(ctx context.Context, q *WatchQuery, handle func(*store.Event) error) error {
+	if q == nil {
+		q = &WatchQuery{}
+	}
+	query := url.Values{}
+	for _, g := range q.Groups {
+		query.Add("group", g)
+	}
+	for k, v := range q.Labels {
+		query.Add("label", fmt.Sprintf("%s=%s", k, v))
+	}
+	revision := q.Revision
+
+	// The stream lasts longer than the timeout of the requests
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+
+	backoff := c.retryBackoff
+	failures := 0
+	for {
+		if revision != nil {
+			query.Set("revision", strconv.FormatUint(*revision, 10))
+		}
+		received, err := c.stream(ctx, &httpClient, query, func(e *store.Event) error {
+			revision = &e.Revision
+			return handle(e)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if hErr, ok := err.(*handlerError); ok {
+			return hErr.err
+		}
+		if apiErr, ok := err.(*APIError); ok && !apiErr.Temporary() {
+			return err
+		}
+		if received {
+			failures, backoff = 0, c.retryBackoff
+		}
+		if failures++; failures > c.maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// handlerError wraps the errors returned by the handler of Watch, so that they aren't retried
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+// stream reads the events of a single connection to /api/watch, reporting whether any event was
+// received before it ended
+func (c *Client) stream(ctx context.Context, httpClient *http.Client, query url.Values, handle func(*store.Event) error) (bool, error) {
+	const path = "/api/watch"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	for name, values := range c.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b := make([]byte, 4096)
+		n, _ := res.Body.Read(b)
+		return false, newAPIError(http.MethodGet, path, res.StatusCode, b[:n])
+	}
+
+	received := false
+	data := ""
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line ends the event, the comments sent to keep the stream alive have no data
+			if data == "" {
+				continue
+			}
+			e := &store.Event{}
+			if err := json.Unmarshal([]byte(data), e); err != nil {
+				return received, fmt.Errorf("Could not decode event: %s", err)
+			}
+			data = ""
+			received = true
+			if err := handle(e); err != nil {
+				return received, &handlerError{err: err}
+			}
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, fmt.Errorf("Watch stream closed by the server")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hartfordfive/prom-http-sd-server/client"
+	"github.com/hartfordfive/prom-http-sd-server/importer"
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+var errUsage = errors.New("invalid usage")
+
+// groupOutput is the machine readable representation of a target group
+type groupOutput struct {
+	Name    string            `json:"name" yaml:"name"`
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// changeOutput is the machine readable representation of the result of a change
+type changeOutput struct {
+	TargetGroup string            `json:"target_group" yaml:"target_group"`
+	Action      string            `json:"action" yaml:"action"`
+	Targets     []string          `json:"targets,omitempty" yaml:"targets,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	LabelNames  []string          `json:"label_names,omitempty" yaml:"label_names,omitempty"`
+}
+
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, k := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func runGroups(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	groups, err := c.GetTargetGroups(ctx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := []groupOutput{}
+	for _, name := range names {
+		res = append(res, groupOutput{Name: name, Targets: groups[name].Targets, Labels: groups[name].Labels})
+	}
+	return out.print(res, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTARGETS\tLABELS")
+		for _, g := range res {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", g.Name, len(g.Targets), formatLabels(g.Labels))
+		}
+		tw.Flush()
+	})
+}
+
+func runGet(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	groups, err := c.GetTargetGroups(ctx)
+	if err != nil {
+		return err
+	}
+	tg, ok := groups[args[0]]
+	if !ok {
+		return fmt.Errorf("Target group %s doesn't exist", args[0])
+	}
+	res := groupOutput{Name: args[0], Targets: tg.Targets, Labels: tg.Labels}
+	return out.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "Name:    %s\n", res.Name)
+		fmt.Fprintf(w, "Labels:  %s\n", formatLabels(res.Labels))
+		fmt.Fprintf(w, "Targets:\n")
+		for _, t := range res.Targets {
+			fmt.Fprintf(w, "  %s\n", t)
+		}
+	})
+}
+
+func runAddTarget(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	for _, t := range args[1:] {
+		if !lib.IsValidTargetName(t) {
+			return fmt.Errorf("Target name '%s' is invalid", t)
+		}
+		if err := c.AddTarget(ctx, args[0], t); err != nil {
+			return err
+		}
+	}
+	res := changeOutput{TargetGroup: args[0], Action: "add-target", Targets: args[1:]}
+	return out.print(res, func(w io.Writer) {
+		for _, t := range res.Targets {
+			fmt.Fprintf(w, "Added target %s to target group %s\n", t, res.TargetGroup)
+		}
+	})
+}
+
+func runRemoveTarget(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	for _, t := range args[1:] {
+		if err := c.RemoveTarget(ctx, args[0], t); err != nil {
+			return err
+		}
+	}
+	res := changeOutput{TargetGroup: args[0], Action: "remove-target", Targets: args[1:]}
+	return out.print(res, func(w io.Writer) {
+		for _, t := range res.Targets {
+			fmt.Fprintf(w, "Removed target %s from target group %s\n", t, res.TargetGroup)
+		}
+	})
+}
+
+func runRemoveGroup(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := c.RemoveTargetGroup(ctx, args[0]); err != nil {
+		return err
+	}
+	res := changeOutput{TargetGroup: args[0], Action: "remove-group"}
+	return out.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "Removed target group %s\n", res.TargetGroup)
+	})
+}
+
+func runSetLabels(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	labels := map[string]string{}
+	for _, lvpair := range args[1:] {
+		parts := strings.SplitN(lvpair, "=", 2)
+		if len(parts) != 2 {
+			return errUsage
+		}
+		if !lib.IsValidLabelName(parts[0]) {
+			return fmt.Errorf("Label name '%s' is invalid", parts[0])
+		}
+		labels[parts[0]] = parts[1]
+	}
+	if err := c.AddLabels(ctx, args[0], labels); err != nil {
+		return err
+	}
+	res := changeOutput{TargetGroup: args[0], Action: "set-labels", Labels: labels}
+	return out.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "Set labels %s on target group %s\n", formatLabels(res.Labels), res.TargetGroup)
+	})
+}
+
+func runRemoveLabel(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	for _, l := range args[1:] {
+		if err := c.RemoveLabel(ctx, args[0], l); err != nil {
+			return err
+		}
+	}
+	res := changeOutput{TargetGroup: args[0], Action: "remove-label", LabelNames: args[1:]}
+	return out.print(res, func(w io.Writer) {
+		for _, l := range res.LabelNames {
+			fmt.Fprintf(w, "Removed label %s from target group %s\n", l, res.TargetGroup)
+		}
+	})
+}
+
+// diffDesiredState loads the desired target groups from the file and returns the changes
+// required to bring the server to that state, along with the live target groups they're computed
+// from.  Target groups which aren't in the file are left alone and, unless prune is set, so are
+// targets and labels which aren't in the file.
+func diffDesiredState(ctx context.Context, c *client.Client, path, format string, port int, prune bool) ([]*store.GroupDiff, map[string]*store.TargetGroup, error) {
+	desired, err := loadTargetGroups(path, format, port)
+	if err != nil {
+		return nil, nil, err
+	}
+	live, err := c.GetTargetGroups(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return importer.Plan(live, desired, prune), live, nil
+}
+
+func printDiffs(out *printer, diffs []*store.GroupDiff) error {
+	return out.print(diffs, func(w io.Writer) {
+		if len(diffs) == 0 {
+			fmt.Fprintln(w, "No differences")
+			return
+		}
+		for _, d := range diffs {
+			fmt.Fprint(w, d.String())
+		}
+	})
+}
+
+func runDiff(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "", "Format of the file: json, yaml, csv, file_sd, prometheus or ansible (default: inferred from the file extension)")
+	port := fs.Int("port", 0, "Port appended to the targets of an Ansible inventory")
+	prune := fs.Bool("prune", false, "Include targets and labels of the target groups in the file which only exist on the server")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	diffs, _, err := diffDesiredState(ctx, c, fs.Arg(0), *format, *port, *prune)
+	if err != nil {
+		return err
+	}
+	return printDiffs(out, diffs)
+}
+
+func runImport(ctx context.Context, c *client.Client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "Format of the file: json, yaml, csv, file_sd, prometheus or ansible (default: inferred from the file extension)")
+	port := fs.Int("port", 0, "Port appended to the targets of an Ansible inventory")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes which would be applied")
+	prune := fs.Bool("prune", false, "Remove targets and labels of the imported target groups which aren't in the file")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	diffs, live, err := diffDesiredState(ctx, c, fs.Arg(0), *format, *port, *prune)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return printDiffs(out, diffs)
+	}
+
+	// The changes are applied at once, and only if the target groups didn't change since the diff
+	if txn := importer.PlanTxn(live, diffs, *prune); txn != nil {
+		if _, err := c.ApplyTxn(ctx, txn); err != nil {
+			if client.IsPreconditionFailed(err) {
+				return fmt.Errorf("Target groups were modified while importing, nothing was applied: %s", err)
+			}
+			return err
+		}
+	}
+	return printDiffs(out, diffs)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/importer"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"gopkg.in/yaml.v2"
+)
+
+// loadTargetGroups reads the target groups from the file at path.  JSON and YAML files
+// contain a map of target group names to their targets and labels, while CSV files
+// contain rows of group,target[,label=value...].  Prometheus file_sd files, in JSON or YAML,
+// are imported as groups named after the file, and the static_configs of a prometheus.yml
+// file as groups named after their job.  Ansible inventories, in INI or YAML, are imported
+// as groups named after their host groups, with port appended to the targets when it isn't
+// zero.  When format is empty, it's inferred from the file extension, .ini being an Ansible
+// inventory.
+func loadTargetGroups(path, format string, port int) (map[string]*store.TargetGroup, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if ext == "yml" {
+		ext = "yaml"
+	}
+	if format == "" {
+		format = ext
+		if format == "ini" {
+			format = "ansible"
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*store.TargetGroup{}
+	switch format {
+	case "json":
+		if err := json.Unmarshal(b, &groups); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(b, &groups); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+		}
+	case "csv":
+		if groups, err = importer.CSV(strings.NewReader(string(b))); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+		}
+	case "file_sd":
+		fileFormat := "json"
+		if ext == "yaml" {
+			fileFormat = "yaml"
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if groups, err = importer.FileSD(b, fileFormat, name); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+		}
+	case "prometheus":
+		if groups, err = importer.StaticConfigs(b); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+		}
+	case "ansible":
+		inventoryFormat := "ini"
+		if ext == "yaml" {
+			inventoryFormat = "yaml"
+		}
+		var warnings []string
+		if groups, warnings, err = importer.AnsibleInventory(b, inventoryFormat, port); err != nil {
+			return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported file format '%s'", format)
+	}
+
+	for name, tg := range groups {
+		if tg == nil {
+			tg = &store.TargetGroup{}
+			groups[name] = tg
+		}
+		tg.Name = name
+		if tg.Labels == nil {
+			tg.Labels = map[string]string{}
+		}
+	}
+	if err := importer.Validate(groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/client"
+	"github.com/hartfordfive/prom-http-sd-server/version"
+)
+
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(ctx context.Context, c *client.Client, out *printer, args []string) error
+}
+
+var commands = []command{
+	{"groups", "", "List all target groups", runGroups},
+	{"get", "<target_group>", "Show the targets and labels of a target group", runGet},
+	{"add-target", "<target_group> <target> [<target>...]", "Add one or more targets to a target group", runAddTarget},
+	{"remove-target", "<target_group> <target> [<target>...]", "Remove one or more targets from a target group", runRemoveTarget},
+	{"remove-group", "<target_group>", "Delete a target group along with all of its targets and labels", runRemoveGroup},
+	{"set-labels", "<target_group> <label>=<value> [<label>=<value>...]", "Add or update labels of a target group", runSetLabels},
+	{"remove-label", "<target_group> <label> [<label>...]", "Remove one or more labels from a target group", runRemoveLabel},
+	{"import", "[-format json|yaml|csv|file_sd|prometheus|ansible] [-port <port>] [-dry-run] [-prune] <file>", "Import target groups from a JSON, YAML, CSV, file_sd, prometheus.yml or Ansible inventory file", runImport},
+	{"diff", "[-format json|yaml|csv|file_sd|prometheus|ansible] [-port <port>] [-prune] <file>", "Show the differences between a file of desired target groups and the server", runDiff},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sdctl [flags] <command> [args]\n\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n\t%s\n", cmd.name, cmd.usage, cmd.description)
+	}
+}
+
+func main() {
+	defaultServer := os.Getenv("SDCTL_SERVER")
+	if defaultServer == "" {
+		defaultServer = "http://127.0.0.1:80"
+	}
+
+	flagServer := flag.String("server", defaultServer, "Address of the prom-http-sd-server (env: SDCTL_SERVER)")
+	flagToken := flag.String("token", os.Getenv("SDCTL_TOKEN"), "Bearer token used to authenticate with the server (env: SDCTL_TOKEN)")
+	flagTimeout := flag.Duration("timeout", 10*time.Second, "Timeout of each request sent to the server")
+	flagRetries := flag.Int("retries", 3, "Number of times failed requests are retried")
+	flagOutput := flag.String("o", "text", "Output format: text, json or yaml")
+	flagVersion := flag.Bool("version", false, "Show version and exit")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *flagVersion {
+		fmt.Printf("sdctl %s (Git hash: %s)\n", version.Version, version.CommitHash)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	out, err := newPrinter(*flagOutput, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(2)
+	}
+
+	opts := []client.Option{
+		client.WithTimeout(*flagTimeout),
+		client.WithRetries(*flagRetries, 250*time.Millisecond),
+	}
+	if *flagToken != "" {
+		opts = append(opts, client.WithBearerToken(*flagToken))
+	}
+	c, err := client.NewClient(*flagServer, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(context.Background(), c, out, flag.Args()[1:]); err != nil {
+			if err == errUsage {
+				fmt.Fprintf(os.Stderr, "Usage: sdctl %s %s\n", cmd.name, cmd.usage)
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", name)
+	usage()
+	os.Exit(2)
+}
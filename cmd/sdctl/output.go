@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v2"
+)
+
+// printer writes command results either as human readable text or in a machine readable format
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	switch format {
+	case "text", "json", "yaml":
+		return &printer{format: format, w: w}, nil
+	}
+	return nil, fmt.Errorf("Unsupported output format '%s'", format)
+}
+
+// print writes v in the selected machine readable format, or calls text to render it as text
+func (p *printer) print(v interface{}, text func(w io.Writer)) error {
+	switch p.format {
+	case "json":
+		b, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.w, "%s\n", b)
+	case "yaml":
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.w, "%s", b)
+	default:
+		text(p.w)
+	}
+	return nil
+}
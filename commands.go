@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runCommand runs the given subcommand with its arguments and returns the process exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "migrate":
+		return runMigrate(args)
+	case "check-config":
+		return runCheckConfig(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n", name)
+		fmt.Fprintf(os.Stderr, "Available commands:\n")
+		fmt.Fprintf(os.Stderr, "\tmigrate\tCopy all target groups from one data store to another\n")
+		fmt.Fprintf(os.Stderr, "\tcheck-config\tValidate a configuration file\n")
+		return 2
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+// AccessLogConfig controls the logging of the requests served by the API
+type AccessLogConfig struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled" desc:"Log every request served"`
+	ExcludePaths []string `yaml:"exclude_paths" json:"exclude_paths" desc:"Request paths which are never logged"`
+	SampleRatio  float64  `yaml:"sample_ratio" json:"sample_ratio" desc:"Fraction of the successful requests which are logged, failed requests are always logged" min:"0" max:"1"`
+}
+
+func newAccessLogConfig() *AccessLogConfig {
+	c := &AccessLogConfig{
+		Enabled:      true,
+		ExcludePaths: []string{"/metrics", "/health"},
+		SampleRatio:  1,
+	}
+	return c
+}
+
+// Excluded returns true when requests to the path must not be logged
+func (c *AccessLogConfig) Excluded(path string) bool {
+	for _, p := range c.ExcludePaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *AccessLogConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, fieldErrorf("access_log.sample_ratio", "must be between 0 and 1, got %v", c.SampleRatio))
+	}
+	return errs
+}
@@ -0,0 +1,34 @@
+package config
+
+// AuditConfig configures the log of the requests which attempted to modify the target groups
+type AuditConfig struct {
+	Path       string `yaml:"path" json:"path" desc:"Path of the BoltDB file keeping the audit log across restarts, which is kept in memory when empty"`
+	MaxEntries int    `yaml:"max_entries" json:"max_entries" desc:"Number of entries kept, the oldest being removed first" min:"1"`
+}
+
+func newAuditConfig() *AuditConfig {
+	c := &AuditConfig{
+		MaxEntries: 1000,
+	}
+	return c
+}
+
+// Persistent returns true when the audit log is kept in a file
+func (c *AuditConfig) Persistent() bool {
+	return c != nil && c.Path != ""
+}
+
+func (c *AuditConfig) validate(storePath, outboxPath string) []*FieldError {
+	errs := []*FieldError{}
+	if c.MaxEntries < 1 {
+		errs = append(errs, fieldErrorf("audit.max_entries", "must be greater than 0"))
+	}
+	// BoltDB files are locked while open, so the audit log can't share its file
+	if c.Path != "" && c.Path == storePath {
+		errs = append(errs, fieldErrorf("audit.path", "must not be the same file as local_config.store_path"))
+	}
+	if c.Path != "" && c.Path == outboxPath {
+		errs = append(errs, fieldErrorf("audit.path", "must not be the same file as webhooks.outbox_path"))
+	}
+	return errs
+}
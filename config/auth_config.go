@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+)
+
+// AuthToken is a bearer token allowed to modify the target groups.  Admin tokens are also
+// allowed to use the administration endpoints.
+type AuthToken struct {
+	Name  string `yaml:"name" json:"name" desc:"Name identifying the token holder"`
+	Token string `yaml:"token" json:"token" desc:"Secret bearer token"`
+	Admin bool   `yaml:"admin" json:"admin" desc:"Allow the token to use the administration endpoints"`
+}
+
+// AuthConfig holds the tokens used to authenticate API requests.  When no tokens are
+// configured, authentication is disabled.
+type AuthConfig struct {
+	Tokens []AuthToken `yaml:"tokens" json:"tokens" desc:"Bearer tokens allowed to modify target groups, as a YAML list of name, token and admin"`
+}
+
+// Enabled returns true when at least one token is configured
+func (c *AuthConfig) Enabled() bool {
+	return c != nil && len(c.Tokens) > 0
+}
+
+func (c *AuthConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	names := map[string]bool{}
+	for i, t := range c.Tokens {
+		if t.Name == "" {
+			errs = append(errs, fieldErrorf(fmt.Sprintf("auth.tokens[%d].name", i), "must not be empty"))
+		} else if names[t.Name] {
+			errs = append(errs, fieldErrorf(fmt.Sprintf("auth.tokens[%d].name", i), "'%s' is used by more than one token", t.Name))
+		}
+		if t.Token == "" {
+			errs = append(errs, fieldErrorf(fmt.Sprintf("auth.tokens[%d].token", i), "must not be empty"))
+		}
+		names[t.Name] = true
+	}
+	return errs
+}
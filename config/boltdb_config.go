@@ -1,7 +1,7 @@
 package config
 
 type BoltDBConfig struct {
-	TargetStorePath string `yaml:"store_path" json:"store_path"`
+	TargetStorePath string `yaml:"store_path" json:"store_path" desc:"Path of the BoltDB file used by the local data store"`
 }
 
 func newBoltDBConfig() *BoltDBConfig {
@@ -16,6 +16,10 @@ func LoadConfig(filePath string) (*BoltDBConfig, error) {
 	return c, nil
 }
 
-func (c *BoltDBConfig) validate() error {
-	return nil
+func (c *BoltDBConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.TargetStorePath == "" {
+		errs = append(errs, fieldErrorf("local_config.store_path", "must not be empty"))
+	}
+	return errs
 }
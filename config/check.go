@@ -0,0 +1,143 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	yamlErrorLine    = regexp.MustCompile(`^line (\d+): (.*)$`)
+	yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type config\.(\w+)$`)
+)
+
+// Check loads the configuration like NewConfig but, instead of stopping at the first problem,
+// returns every problem found.  Unlike NewConfig, keys which don't match any configuration field
+// are reported, and the files and directories referenced by the configuration are checked.
+func Check(configPath string, overrides ...Overrides) []*FieldError {
+	errs := []*FieldError{}
+
+	if configPath != "" {
+		b, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return []*FieldError{fieldErrorf(configPath, "could not read config: %s", err)}
+		}
+		errs = append(errs, checkStrictYAML(b)...)
+	}
+
+	c, err := NewConfig(configPath, overrides...)
+	if err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			return append(errs, verr.Errors...)
+		}
+		return append(errs, fieldErrorf(configPath, "%s", err))
+	}
+
+	if c.StoreType == "local" {
+		if fe := checkWritable("local_config.store_path", c.LocalDBConfig.TargetStorePath); fe != nil {
+			errs = append(errs, fe)
+		}
+	}
+	if c.Webhooks.Enabled() {
+		if fe := checkWritable("webhooks.outbox_path", c.Webhooks.OutboxPath); fe != nil {
+			errs = append(errs, fe)
+		}
+	}
+	if c.Audit.Persistent() {
+		if fe := checkWritable("audit.path", c.Audit.Path); fe != nil {
+			errs = append(errs, fe)
+		}
+	}
+	if c.Logging.Output == "file" {
+		if fe := checkWritable("logging.file.path", c.Logging.File.Path); fe != nil {
+			errs = append(errs, fe)
+		}
+	}
+	for i, f := range c.FileSD.Files {
+		if fe := checkWritable(fmt.Sprintf("file_sd.files[%d].path", i), f.Path); fe != nil {
+			errs = append(errs, fe)
+		}
+	}
+	if c.TLS.Enabled() {
+		if _, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile); err != nil {
+			errs = append(errs, fieldErrorf("tls", "could not load certificate: %s", err))
+		}
+	}
+	return errs
+}
+
+// checkStrictYAML reports keys which don't match any configuration field
+func checkStrictYAML(b []byte) []*FieldError {
+	c := &Config{}
+	err := yaml.UnmarshalStrict(b, c)
+	if err == nil {
+		return nil
+	}
+
+	errs := []*FieldError{}
+	for _, fe := range yamlErrors(err) {
+		if m := yamlUnknownField.FindStringSubmatch(fe.Message); m != nil {
+			fe.Message = fmt.Sprintf("unknown key '%s' in %s", m[1], m[2])
+			errs = append(errs, fe)
+		}
+	}
+	return errs
+}
+
+// yamlErrors converts the errors returned when unmarshalling YAML into field errors
+func yamlErrors(err error) []*FieldError {
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return []*FieldError{fieldErrorf("yaml", "%s", err)}
+	}
+	errs := []*FieldError{}
+	for _, msg := range typeErr.Errors {
+		if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+			errs = append(errs, fieldErrorf(fmt.Sprintf("line %s", m[1]), "%s", m[2]))
+		} else {
+			errs = append(errs, fieldErrorf("yaml", "%s", msg))
+		}
+	}
+	return errs
+}
+
+// checkWritable verifies the file at path can be created or, if it exists, written to
+func checkWritable(field, path string) *FieldError {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.IsDir() {
+			return fieldErrorf(field, "'%s' is a directory", path)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return fieldErrorf(field, "'%s' is not writable: %s", path, err)
+		}
+		f.Close()
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fieldErrorf(field, "could not access '%s': %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return fieldErrorf(field, "directory '%s' doesn't exist", dir)
+	}
+	if !dirInfo.IsDir() {
+		return fieldErrorf(field, "'%s' is not a directory", dir)
+	}
+	f, err := ioutil.TempFile(dir, "."+strings.TrimPrefix(filepath.Base(path), ".")+".check")
+	if err != nil {
+		return fieldErrorf(field, "directory '%s' is not writable: %s", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
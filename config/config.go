@@ -4,52 +4,283 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"sync/atomic"
 
 	"gopkg.in/yaml.v2"
 )
 
-var GlobalConfig *Config
+var current atomic.Value
 
 type Config struct {
-	StoreType     string        `yaml:"store_type" json:"store_type"`
-	Host          string        `yaml:"server_host" json:"server_host"`
-	Port          int           `yaml:"server_port" json:"server_port"`
-	LocalDBConfig *BoltDBConfig `yaml:"local_config" json:"local_config"`
-	ConsulConfig  *ConsulConfig `yaml:"consul_config" json:"consul_config"`
+	StoreType     string             `yaml:"store_type" json:"store_type" desc:"Type of data store used to persist the target groups (local or consul)" enum:"local,consul"`
+	Host          string             `yaml:"server_host" json:"server_host" desc:"Host on which the server listens"`
+	Port          int                `yaml:"server_port" json:"server_port" desc:"Port on which the server listens" min:"1" max:"65535"`
+	LocalDBConfig *BoltDBConfig      `yaml:"local_config" json:"local_config"`
+	ConsulConfig  *ConsulConfig      `yaml:"consul_config" json:"consul_config"`
+	TLS           *TLSConfig         `yaml:"tls" json:"tls"`
+	Auth          *AuthConfig        `yaml:"auth" json:"auth"`
+	Logging       *LoggingConfig     `yaml:"logging" json:"logging"`
+	Reload        *ReloadConfig      `yaml:"config_reload" json:"config_reload"`
+	Tracing       *TracingConfig     `yaml:"tracing" json:"tracing"`
+	AccessLog     *AccessLogConfig   `yaml:"access_log" json:"access_log"`
+	RateLimit     *RateLimitConfig   `yaml:"rate_limit" json:"rate_limit"`
+	Audit         *AuditConfig       `yaml:"audit" json:"audit"`
+	Webhooks      *WebhooksConfig    `yaml:"webhooks" json:"webhooks"`
+	FileSD        *FileSDConfig      `yaml:"file_sd" json:"file_sd"`
+	HTTPSD        *HTTPSDConfig      `yaml:"http_sd" json:"http_sd"`
+	GRPC          *GRPCConfig        `yaml:"grpc" json:"grpc"`
+	LabelPolicy   *LabelPolicyConfig `yaml:"label_policy" json:"label_policy"`
 }
 
-func NewConfig(configPath string) (*Config, error) {
-	c := &Config{}
-	b, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Could not read config: %s", err))
+// NewConfig loads the configuration file at configPath, applies the overrides in order, so that
+// later overrides take precedence, and validates the result.  When configPath is empty, the
+// configuration is built from the defaults and the overrides.
+func NewConfig(configPath string, overrides ...Overrides) (*Config, error) {
+	c := &Config{
+		Host: "127.0.0.1",
+		Port: 80,
 	}
+	c.setDefaults()
+	loadErrs := []*FieldError{}
 
-	err = yaml.Unmarshal([]byte(b), &c)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Could not unmarshal config: %v", err))
+	// Without a configuration file, the configuration is built from the overrides only
+	if configPath != "" {
+		b, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not read config: %s", err))
+		}
+
+		err = yaml.Unmarshal([]byte(b), &c)
+		if _, ok := err.(*yaml.TypeError); ok {
+			// Values of the wrong type are reported along with the other invalid fields
+			loadErrs = append(loadErrs, yamlErrors(err)...)
+		} else if err != nil {
+			return nil, errors.New(fmt.Sprintf("Could not unmarshal config: %v", err))
+		}
+	}
+
+	for _, o := range overrides {
+		loadErrs = append(loadErrs, o.apply(c)...)
 	}
 
+	// Sections left empty in the file are unmarshalled as nil
+	c.setDefaults()
+
 	if err := c.validate(); err != nil {
-		return nil, err
+		return nil, &ValidationError{Errors: append(loadErrs, err.(*ValidationError).Errors...)}
+	}
+	if len(loadErrs) > 0 {
+		return nil, &ValidationError{Errors: loadErrs}
 	}
 
 	return c, nil
 }
 
+func (c *Config) setDefaults() {
+	if c.TLS == nil {
+		c.TLS = &TLSConfig{}
+	}
+	if c.Auth == nil {
+		c.Auth = &AuthConfig{}
+	}
+	if c.Logging == nil {
+		c.Logging = newLoggingConfig()
+	}
+	c.Logging.setDefaults()
+	if c.Reload == nil {
+		c.Reload = newReloadConfig()
+	}
+	if c.Tracing == nil {
+		c.Tracing = newTracingConfig()
+	}
+	if c.AccessLog == nil {
+		c.AccessLog = newAccessLogConfig()
+	}
+	if c.RateLimit == nil {
+		c.RateLimit = newRateLimitConfig()
+	}
+	if c.Audit == nil {
+		c.Audit = newAuditConfig()
+	}
+	if c.Webhooks == nil {
+		c.Webhooks = newWebhooksConfig()
+	}
+	if c.FileSD == nil {
+		c.FileSD = newFileSDConfig()
+	}
+	if c.HTTPSD == nil {
+		c.HTTPSD = &HTTPSDConfig{}
+	}
+	if c.GRPC == nil {
+		c.GRPC = newGRPCConfig()
+	}
+	if c.LabelPolicy == nil {
+		c.LabelPolicy = &LabelPolicyConfig{}
+	}
+}
+
+// Current returns the configuration currently in effect
+func Current() *Config {
+	c, _ := current.Load().(*Config)
+	return c
+}
+
+// SetCurrent replaces the configuration currently in effect
+func SetCurrent(c *Config) {
+	current.Store(c)
+}
+
+// Serialize returns the configuration as YAML, with the auth tokens, tracing headers and webhook
+// secrets redacted
 func (c *Config) Serialize() (string, error) {
-	if b, err := yaml.Marshal(c); err != nil {
+	redacted := *c
+	if c.Auth != nil {
+		redacted.Auth = &AuthConfig{}
+		for _, t := range c.Auth.Tokens {
+			t.Token = "<redacted>"
+			redacted.Auth.Tokens = append(redacted.Auth.Tokens, t)
+		}
+	}
+	if c.Tracing != nil && len(c.Tracing.Headers) > 0 {
+		tracing := *c.Tracing
+		tracing.Headers = map[string]string{}
+		for k := range c.Tracing.Headers {
+			tracing.Headers[k] = "<redacted>"
+		}
+		redacted.Tracing = &tracing
+	}
+	if c.Webhooks != nil && len(c.Webhooks.Endpoints) > 0 {
+		webhooks := *c.Webhooks
+		webhooks.Endpoints = nil
+		for _, e := range c.Webhooks.Endpoints {
+			if e.Secret != "" {
+				e.Secret = "<redacted>"
+			}
+			webhooks.Endpoints = append(webhooks.Endpoints, e)
+		}
+		redacted.Webhooks = &webhooks
+	}
+
+	if b, err := yaml.Marshal(&redacted); err != nil {
 		return "", err
 	} else {
 		return string(b), nil
 	}
 }
 
+// RestartRequiredChanges returns the names of the settings which differ between c and newConf
+// and can only be applied by restarting the server.
+func (c *Config) RestartRequiredChanges(newConf *Config) []string {
+	changes := []string{}
+	if c.StoreType != newConf.StoreType {
+		changes = append(changes, "store_type")
+	}
+	if c.Host != newConf.Host {
+		changes = append(changes, "server_host")
+	}
+	if c.Port != newConf.Port {
+		changes = append(changes, "server_port")
+	}
+	if !reflect.DeepEqual(c.LocalDBConfig, newConf.LocalDBConfig) {
+		changes = append(changes, "local_config")
+	}
+	if !reflect.DeepEqual(c.ConsulConfig, newConf.ConsulConfig) {
+		changes = append(changes, "consul_config")
+	}
+	if c.TLS.Enabled() != newConf.TLS.Enabled() {
+		changes = append(changes, "tls")
+	}
+	if c.Logging.restartRequired(newConf.Logging) {
+		changes = append(changes, "logging")
+	}
+	if c.Webhooks.OutboxPath != newConf.Webhooks.OutboxPath {
+		changes = append(changes, "webhooks.outbox_path")
+	}
+	if !reflect.DeepEqual(c.Audit, newConf.Audit) {
+		changes = append(changes, "audit")
+	}
+	if !reflect.DeepEqual(c.Tracing, newConf.Tracing) {
+		changes = append(changes, "tracing")
+	}
+	if !reflect.DeepEqual(c.GRPC, newConf.GRPC) {
+		changes = append(changes, "grpc")
+	}
+	return changes
+}
+
+// KeepRestartRequiredSettings copies the settings which can only be applied by restarting
+// the server from c into newConf.  TLS certificates can be replaced, but TLS can't be
+// enabled or disabled.  Likewise, only the log level can be changed.
+func (c *Config) KeepRestartRequiredSettings(newConf *Config) {
+	newConf.StoreType = c.StoreType
+	newConf.Host = c.Host
+	newConf.Port = c.Port
+	newConf.LocalDBConfig = c.LocalDBConfig
+	newConf.ConsulConfig = c.ConsulConfig
+	newConf.Tracing = c.Tracing
+	newConf.GRPC = c.GRPC
+	newConf.Webhooks.OutboxPath = c.Webhooks.OutboxPath
+	newConf.Audit = c.Audit
+	if c.Logging.restartRequired(newConf.Logging) {
+		logging := *c.Logging
+		logging.Level = newConf.Logging.Level
+		newConf.Logging = &logging
+	}
+	if c.TLS.Enabled() != newConf.TLS.Enabled() {
+		newConf.TLS = c.TLS
+	}
+}
+
 func (c *Config) validate() error {
-	validStoreType := map[string]bool{"local": true, "consul": true}
-	if ok, _ := validStoreType[c.StoreType]; !ok {
-		return errors.New("Only the local data store is currently supported")
+	errs := []*FieldError{}
+
+	switch c.StoreType {
+	case "local":
+		if c.LocalDBConfig == nil {
+			errs = append(errs, fieldErrorf("local_config", "must be set when store_type is local"))
+		} else {
+			errs = append(errs, c.LocalDBConfig.validate()...)
+		}
+	case "consul":
+		if c.ConsulConfig == nil {
+			errs = append(errs, fieldErrorf("consul_config", "must be set when store_type is consul"))
+		} else {
+			errs = append(errs, c.ConsulConfig.validate()...)
+		}
+	case "":
+		errs = append(errs, fieldErrorf("store_type", "must be set to local or consul"))
+	default:
+		errs = append(errs, fieldErrorf("store_type", "unsupported data store '%s', must be local or consul", c.StoreType))
 	}
 
+	if c.Host == "" {
+		errs = append(errs, fieldErrorf("server_host", "must not be empty"))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fieldErrorf("server_port", "must be between 1 and 65535, got %d", c.Port))
+	}
+
+	errs = append(errs, c.TLS.validate()...)
+	errs = append(errs, c.Auth.validate()...)
+	errs = append(errs, c.Logging.validate()...)
+	errs = append(errs, c.Reload.validate()...)
+	errs = append(errs, c.Tracing.validate()...)
+	errs = append(errs, c.AccessLog.validate()...)
+	errs = append(errs, c.RateLimit.validate()...)
+	storePath := ""
+	if c.LocalDBConfig != nil {
+		storePath = c.LocalDBConfig.TargetStorePath
+	}
+	errs = append(errs, c.Audit.validate(storePath, c.Webhooks.OutboxPath)...)
+	errs = append(errs, c.Webhooks.validate(storePath)...)
+	errs = append(errs, c.FileSD.validate()...)
+	errs = append(errs, c.HTTPSD.validate()...)
+	errs = append(errs, c.GRPC.validate(c.Port)...)
+	errs = append(errs, c.LabelPolicy.validate()...)
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
 	return nil
 }
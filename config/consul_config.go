@@ -1,7 +1,53 @@
 package config
 
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
 type ConsulConfig struct {
-	Host       string `json:"host" yaml:"host"`
-	DC         string `json:"dc" yaml:"dc"`
-	AllowStale bool   `json:"allow_stale" yaml:"allow_stale"`
+	Host       string `json:"host" yaml:"host" desc:"Address of the Consul agent (host:port)"`
+	DC         string `json:"dc" yaml:"dc" desc:"Consul datacenter"`
+	AllowStale bool   `json:"allow_stale" yaml:"allow_stale" desc:"Allow reads from Consul followers which may return stale data"`
+}
+
+func (c *ConsulConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.Host == "" {
+		errs = append(errs, fieldErrorf("consul_config.host", "must not be empty"))
+	} else if err := validateConsulAddress(c.Host); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validateConsulAddress checks the address is either host:port, an http(s) URL or a unix socket
+func validateConsulAddress(addr string) *FieldError {
+	if strings.HasPrefix(addr, "unix://") {
+		return nil
+	}
+	hostPort := addr
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fieldErrorf("consul_config.host", "invalid address '%s': %s", addr, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fieldErrorf("consul_config.host", "unsupported scheme '%s', must be http, https or unix", u.Scheme)
+		}
+		hostPort = u.Host
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return fieldErrorf("consul_config.host", "invalid address '%s', must be in the form host:port", addr)
+	}
+	if host == "" {
+		return fieldErrorf("consul_config.host", "invalid address '%s', the host is missing", addr)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fieldErrorf("consul_config.host", "invalid port '%s', must be between 1 and 65535", port)
+	}
+	return nil
 }
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSDFile is a Prometheus file_sd file rendered from the target groups
+type FileSDFile struct {
+	Path   string            `yaml:"path" json:"path" desc:"Path of the file"`
+	Format string            `yaml:"format" json:"format" desc:"Format of the file (json or yaml), guessed from the extension when not set"`
+	Groups []string          `yaml:"groups" json:"groups" desc:"Target groups written to the file, all of them when empty"`
+	Labels map[string]string `yaml:"labels" json:"labels" desc:"Only write the target groups which have all of these labels"`
+	// RelabelConfigs are applied to the targets of the selected groups
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs" json:"relabel_configs" desc:"Prometheus relabel_configs applied to every target written to the file, in order"`
+}
+
+// FileFormat returns the format of the file, guessing it from the extension when it isn't set
+func (f *FileSDFile) FileFormat() string {
+	if f.Format != "" {
+		return f.Format
+	}
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
+// FileSDConfig configures the file_sd files which are regularly written from the target groups
+type FileSDConfig struct {
+	Interval time.Duration `yaml:"interval" json:"interval" desc:"How often the file_sd files are written"`
+	Files    []FileSDFile  `yaml:"files" json:"files" desc:"Files to write, as a YAML list of path, format, groups, labels and relabel_configs"`
+}
+
+func newFileSDConfig() *FileSDConfig {
+	c := &FileSDConfig{
+		Interval: 30 * time.Second,
+	}
+	return c
+}
+
+func (c *FileSDConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.Interval <= 0 {
+		errs = append(errs, fieldErrorf("file_sd.interval", "must be greater than 0"))
+	}
+	paths := map[string]bool{}
+	for i := range c.Files {
+		f := &c.Files[i]
+		field := fmt.Sprintf("file_sd.files[%d]", i)
+		if f.Path == "" {
+			errs = append(errs, fieldErrorf(field+".path", "must not be empty"))
+		} else if paths[f.Path] {
+			errs = append(errs, fieldErrorf(field+".path", "'%s' is used by more than one file", f.Path))
+		}
+		paths[f.Path] = true
+		switch f.Format {
+		case "", "json", "yaml":
+		default:
+			errs = append(errs, fieldErrorf(field+".format", "must be json or yaml, got '%s'", f.Format))
+		}
+		errs = append(errs, validateRelabelConfigs(field+".relabel_configs", f.RelabelConfigs)...)
+	}
+	return errs
+}
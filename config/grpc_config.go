@@ -0,0 +1,37 @@
+package config
+
+import (
+	"time"
+)
+
+// GRPCConfig configures the gRPC API, served on its own port with the same host, TLS and
+// auth settings as the REST API
+type GRPCConfig struct {
+	Port              int           `yaml:"port" json:"port" desc:"Port on which the gRPC API listens, disabled when 0" min:"0" max:"65535"`
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval" json:"keepalive_interval" desc:"How often idle connections are pinged to check they're still alive"`
+}
+
+func newGRPCConfig() *GRPCConfig {
+	c := &GRPCConfig{
+		KeepaliveInterval: 2 * time.Minute,
+	}
+	return c
+}
+
+// Enabled returns true when the gRPC API is served
+func (c *GRPCConfig) Enabled() bool {
+	return c != nil && c.Port != 0
+}
+
+func (c *GRPCConfig) validate(serverPort int) []*FieldError {
+	errs := []*FieldError{}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fieldErrorf("grpc.port", "must be between 0 and 65535, got %d", c.Port))
+	} else if c.Port != 0 && c.Port == serverPort {
+		errs = append(errs, fieldErrorf("grpc.port", "must differ from server_port"))
+	}
+	if c.Enabled() && c.KeepaliveInterval <= 0 {
+		errs = append(errs, fieldErrorf("grpc.keepalive_interval", "must be greater than 0"))
+	}
+	return errs
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HTTPSDEndpoint is an HTTP SD endpoint serving the target groups relabeled by its relabel
+// configs at /api/targets/<name>
+type HTTPSDEndpoint struct {
+	Name           string          `yaml:"name" json:"name" desc:"Name of the endpoint, served at /api/targets/<name>"`
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs" json:"relabel_configs" desc:"Prometheus relabel_configs applied to every target, in order, as a YAML list of source_labels, separator, regex, modulus, target_label, replacement and action"`
+}
+
+// HTTPSDConfig configures the HTTP SD endpoints serving differently relabeled views of the
+// target groups, besides /api/targets
+type HTTPSDConfig struct {
+	Endpoints []HTTPSDEndpoint `yaml:"endpoints" json:"endpoints" desc:"Endpoints served under /api/targets, as a YAML list of name and relabel_configs"`
+}
+
+// Endpoint returns the endpoint with the given name, or nil if there's none
+func (c *HTTPSDConfig) Endpoint(name string) *HTTPSDEndpoint {
+	for i := range c.Endpoints {
+		if c.Endpoints[i].Name == name {
+			return &c.Endpoints[i]
+		}
+	}
+	return nil
+}
+
+func (c *HTTPSDConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	names := map[string]bool{}
+	for i := range c.Endpoints {
+		e := &c.Endpoints[i]
+		field := fmt.Sprintf("http_sd.endpoints[%d]", i)
+		if e.Name == "" {
+			errs = append(errs, fieldErrorf(field+".name", "must not be empty"))
+		} else if strings.Contains(e.Name, "/") {
+			errs = append(errs, fieldErrorf(field+".name", "'%s' must not contain /", e.Name))
+		} else if names[e.Name] {
+			errs = append(errs, fieldErrorf(field+".name", "'%s' is used by more than one endpoint", e.Name))
+		}
+		names[e.Name] = true
+		errs = append(errs, validateRelabelConfigs(field+".relabel_configs", e.RelabelConfigs)...)
+	}
+	return errs
+}
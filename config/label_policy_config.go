@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+)
+
+// LabelRule restricts the labels whose whole name matches the Name regular expression
+type LabelRule struct {
+	Name       string   `yaml:"name" json:"name" desc:"Regular expression matching the whole name of the labels the rule applies to"`
+	Forbidden  bool     `yaml:"forbidden" json:"forbidden" desc:"Reject the labels, which can still be removed"`
+	AdminOnly  bool     `yaml:"admin_only" json:"admin_only" desc:"Only allow admin tokens to set or remove the labels"`
+	Values     []string `yaml:"values" json:"values" desc:"Values allowed for the labels, any value when empty"`
+	ValueRegex string   `yaml:"value_regex" json:"value_regex" desc:"Regular expression the whole value of the labels must match"`
+
+	// The regular expressions are compiled by validate
+	nameRegex  *regexp.Regexp
+	valueRegex *regexp.Regexp
+}
+
+// AllowsValue returns true when the value is one of Values and matches ValueRegex, when they're set
+func (r *LabelRule) AllowsValue(value string) bool {
+	if len(r.Values) > 0 && !lib.Contains(r.Values, value) {
+		return false
+	}
+	return r.valueRegex == nil || r.valueRegex.MatchString(value)
+}
+
+// LabelPolicyConfig restricts the labels which can be set on and removed from the target groups
+type LabelPolicyConfig struct {
+	Required []string    `yaml:"required" json:"required" desc:"Labels the target groups must be created with, as their own labels or inherited from their templates, and which they can't lose afterwards.  Adding a target can't create a group once labels are required"`
+	Rules    []LabelRule `yaml:"rules" json:"rules" desc:"Rules restricting the labels, the first rule matching the name of a label applies, as a YAML list of name, forbidden, admin_only, values and value_regex"`
+}
+
+// Rule returns the first rule matching the name of the label, or nil if there's none
+func (c *LabelPolicyConfig) Rule(label string) *LabelRule {
+	for i := range c.Rules {
+		if c.Rules[i].nameRegex != nil && c.Rules[i].nameRegex.MatchString(label) {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+func (c *LabelPolicyConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	for i, k := range c.Required {
+		if !lib.IsValidLabelName(k) {
+			errs = append(errs, fieldErrorf(fmt.Sprintf("label_policy.required[%d]", i), "'%s' isn't a valid label name", k))
+		}
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		field := fmt.Sprintf("label_policy.rules[%d]", i)
+		if r.Name == "" {
+			errs = append(errs, fieldErrorf(field+".name", "must not be empty"))
+		} else if re, err := regexp.Compile("^(?:" + r.Name + ")$"); err != nil {
+			errs = append(errs, fieldErrorf(field+".name", "invalid regular expression: %s", err))
+		} else {
+			r.nameRegex = re
+		}
+		if r.ValueRegex != "" {
+			if re, err := regexp.Compile("^(?:" + r.ValueRegex + ")$"); err != nil {
+				errs = append(errs, fieldErrorf(field+".value_regex", "invalid regular expression: %s", err))
+			} else {
+				r.valueRegex = re
+			}
+		}
+		if r.Forbidden && (r.AdminOnly || len(r.Values) > 0 || r.ValueRegex != "") {
+			errs = append(errs, fieldErrorf(field+".forbidden", "can't be combined with admin_only, values or value_regex"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+type LoggingConfig struct {
+	Level    string             `yaml:"level" json:"level" desc:"Log level (debug, info, warn or error)" enum:"debug,info,warn,error,dpanic,panic,fatal"`
+	Encoding string             `yaml:"encoding" json:"encoding" desc:"Format of the log entries (json or console)" enum:"json,console"`
+	Output   string             `yaml:"output" json:"output" desc:"Where the logs are written (stderr, stdout or file)" enum:"stderr,stdout,file"`
+	File     *LogFileConfig     `yaml:"file" json:"file"`
+	Sampling *LogSamplingConfig `yaml:"sampling" json:"sampling"`
+}
+
+// LogFileConfig configures the log file and its rotation, used when the output is file
+type LogFileConfig struct {
+	Path       string `yaml:"path" json:"path" desc:"Path of the log file"`
+	MaxSize    int    `yaml:"max_size" json:"max_size" desc:"Size in megabytes after which the log file is rotated" min:"1"`
+	MaxBackups int    `yaml:"max_backups" json:"max_backups" desc:"Number of rotated log files to keep, 0 keeps them all" min:"0"`
+	MaxAge     int    `yaml:"max_age" json:"max_age" desc:"Number of days to keep the rotated log files, 0 keeps them forever" min:"0"`
+	Compress   bool   `yaml:"compress" json:"compress" desc:"Compress the rotated log files with gzip"`
+}
+
+// LogSamplingConfig limits the number of identical log entries written per second
+type LogSamplingConfig struct {
+	Initial    int `yaml:"initial" json:"initial" desc:"Number of identical entries logged each second before sampling starts, 0 disables sampling" min:"0"`
+	Thereafter int `yaml:"thereafter" json:"thereafter" desc:"Once sampling starts, only every Nth identical entry is logged" min:"1"`
+}
+
+func newLoggingConfig() *LoggingConfig {
+	c := &LoggingConfig{
+		Level:    "info",
+		Encoding: "json",
+		Output:   "stderr",
+	}
+	c.setDefaults()
+	return c
+}
+
+func (c *LoggingConfig) setDefaults() {
+	if c.File == nil {
+		c.File = &LogFileConfig{
+			MaxSize:    100,
+			MaxBackups: 5,
+		}
+	}
+	if c.Sampling == nil {
+		c.Sampling = &LogSamplingConfig{
+			Initial:    100,
+			Thereafter: 100,
+		}
+	}
+}
+
+// restartRequired returns true when settings other than the level, which can only be applied
+// by restarting the server, differ from newConf
+func (c *LoggingConfig) restartRequired(newConf *LoggingConfig) bool {
+	return c.Encoding != newConf.Encoding || c.Output != newConf.Output ||
+		*c.File != *newConf.File || *c.Sampling != *newConf.Sampling
+}
+
+func (c *LoggingConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(c.Level)); err != nil {
+		errs = append(errs, fieldErrorf("logging.level", "invalid log level '%s'", c.Level))
+	}
+	switch c.Encoding {
+	case "json", "console":
+	default:
+		errs = append(errs, fieldErrorf("logging.encoding", "must be json or console, got '%s'", c.Encoding))
+	}
+	switch c.Output {
+	case "stderr", "stdout":
+	case "file":
+		if c.File.Path == "" {
+			errs = append(errs, fieldErrorf("logging.file.path", "must be set when the output is file"))
+		}
+		if c.File.MaxSize < 1 {
+			errs = append(errs, fieldErrorf("logging.file.max_size", "must be greater than 0"))
+		}
+		if c.File.MaxBackups < 0 {
+			errs = append(errs, fieldErrorf("logging.file.max_backups", "must not be negative"))
+		}
+		if c.File.MaxAge < 0 {
+			errs = append(errs, fieldErrorf("logging.file.max_age", "must not be negative"))
+		}
+	default:
+		errs = append(errs, fieldErrorf("logging.output", "must be stderr, stdout or file, got '%s'", c.Output))
+	}
+	if c.Sampling.Initial < 0 {
+		errs = append(errs, fieldErrorf("logging.sampling.initial", "must not be negative"))
+	}
+	if c.Sampling.Initial > 0 && c.Sampling.Thereafter < 1 {
+		errs = append(errs, fieldErrorf("logging.sampling.thereafter", "must be greater than 0"))
+	}
+	return errs
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables which override configuration fields
+const EnvPrefix = "PROM_HTTP_SD_"
+
+// Field describes a configuration field which can be overridden from the environment or the
+// command line
+type Field struct {
+	// Path is the dot separated path of the field in the YAML configuration, for example
+	// consul_config.host
+	Path        string
+	EnvVar      string
+	Flag        string
+	Description string
+}
+
+// Overrides maps the path of configuration fields to the raw value they should be set to.  Values
+// are parsed as YAML, with the exception of string fields which are used as is.
+type Overrides map[string]string
+
+// Fields returns every configuration field which can be overridden
+func Fields() []Field {
+	return collectFields(reflect.TypeOf(Config{}), "")
+}
+
+func collectFields(t reflect.Type, prefix string) []Field {
+	fields := []Field{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+			fields = append(fields, collectFields(ft.Elem(), path+".")...)
+			continue
+		}
+
+		fields = append(fields, Field{
+			Path:        path,
+			EnvVar:      EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(path)),
+			Flag:        strings.NewReplacer("_", "-").Replace(path),
+			Description: sf.Tag.Get("desc"),
+		})
+	}
+	return fields
+}
+
+// EnvOverrides returns the overrides set through PROM_HTTP_SD_* environment variables
+func EnvOverrides() Overrides {
+	o := Overrides{}
+	for _, f := range Fields() {
+		if v, ok := os.LookupEnv(f.EnvVar); ok {
+			o[f.Path] = v
+		}
+	}
+	return o
+}
+
+// FlagOverrides holds the command line flags registered for every configuration field
+type FlagOverrides struct {
+	fs     *flag.FlagSet
+	values map[string]*string
+}
+
+// RegisterFlags registers a flag for every configuration field on the flag set
+func RegisterFlags(fs *flag.FlagSet) *FlagOverrides {
+	fo := &FlagOverrides{fs: fs, values: map[string]*string{}}
+	for _, f := range Fields() {
+		usage := fmt.Sprintf("%s (env: %s)", f.Description, f.EnvVar)
+		fo.values[f.Flag] = fs.String(f.Flag, "", usage)
+	}
+	return fo
+}
+
+// Overrides returns the configuration fields explicitly set on the command line.  It must be called
+// after the flag set has been parsed.
+func (fo *FlagOverrides) Overrides() Overrides {
+	o := Overrides{}
+	paths := map[string]string{}
+	for _, f := range Fields() {
+		paths[f.Flag] = f.Path
+	}
+	fo.fs.Visit(func(fl *flag.Flag) {
+		if v, ok := fo.values[fl.Name]; ok {
+			o[paths[fl.Name]] = *v
+		}
+	})
+	return o
+}
+
+// apply sets the fields of the configuration to the override values
+func (o Overrides) apply(c *Config) []*FieldError {
+	errs := []*FieldError{}
+	for path, raw := range o {
+		if err := setField(reflect.ValueOf(c).Elem(), strings.Split(path, "."), raw); err != nil {
+			errs = append(errs, &FieldError{Field: path, Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+func setField(v reflect.Value, path []string, raw string) error {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if strings.Split(sf.Tag.Get("yaml"), ",")[0] != path[0] {
+			continue
+		}
+
+		fv := v.Field(i)
+		if len(path) > 1 {
+			if fv.Kind() != reflect.Ptr || fv.Type().Elem().Kind() != reflect.Struct {
+				break
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			return setField(fv.Elem(), path[1:], raw)
+		}
+
+		if fv.Kind() == reflect.String {
+			fv.SetString(raw)
+			return nil
+		}
+		nv := reflect.New(fv.Type())
+		if err := yaml.Unmarshal([]byte(raw), nv.Interface()); err != nil {
+			return fmt.Errorf("invalid value '%s': %s", raw, err)
+		}
+		fv.Set(nv.Elem())
+		return nil
+	}
+	return fmt.Errorf("unknown configuration field")
+}
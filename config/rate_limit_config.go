@@ -0,0 +1,54 @@
+package config
+
+import (
+	"math"
+)
+
+// RateLimitConfig limits the rate of the requests served by the HTTP API to each caller, identified
+// by its auth token name or else its IP address.  It's disabled when requests_per_second is 0.
+type RateLimitConfig struct {
+	RequestsPerSecond float64  `yaml:"requests_per_second" json:"requests_per_second" desc:"Requests allowed per second and caller, 0 to disable rate limiting" min:"0"`
+	Burst             int      `yaml:"burst" json:"burst" desc:"Requests a caller can make at once above the rate, the rate rounded up when not set" min:"0"`
+	ExcludePaths      []string `yaml:"exclude_paths" json:"exclude_paths" desc:"Request paths which are never rate limited"`
+}
+
+func newRateLimitConfig() *RateLimitConfig {
+	c := &RateLimitConfig{
+		ExcludePaths: []string{"/metrics", "/health"},
+	}
+	return c
+}
+
+// Enabled returns true when the requests are rate limited
+func (c *RateLimitConfig) Enabled() bool {
+	return c != nil && c.RequestsPerSecond > 0
+}
+
+// BurstSize returns the number of requests a caller can make at once
+func (c *RateLimitConfig) BurstSize() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	return int(math.Ceil(c.RequestsPerSecond))
+}
+
+// Excluded returns true when requests to the path must not be rate limited
+func (c *RateLimitConfig) Excluded(path string) bool {
+	for _, p := range c.ExcludePaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RateLimitConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.RequestsPerSecond < 0 {
+		errs = append(errs, fieldErrorf("rate_limit.requests_per_second", "must not be negative, got %v", c.RequestsPerSecond))
+	}
+	if c.Burst < 0 {
+		errs = append(errs, fieldErrorf("rate_limit.burst", "must not be negative, got %d", c.Burst))
+	}
+	return errs
+}
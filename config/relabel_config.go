@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+)
+
+// Actions of the relabel configs, with the same semantics as in Prometheus
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelHashMod   = "hashmod"
+	RelabelLabelMap  = "labelmap"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+	RelabelLowercase = "lowercase"
+	RelabelUppercase = "uppercase"
+	RelabelKeepEqual = "keepequal"
+	RelabelDropEqual = "dropequal"
+)
+
+// RelabelActions lists the actions of the relabel configs
+var RelabelActions = []string{RelabelReplace, RelabelKeep, RelabelDrop, RelabelHashMod, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep,
+	RelabelLowercase, RelabelUppercase, RelabelKeepEqual, RelabelDropEqual}
+
+// RelabelConfig is a Prometheus relabel_config rewriting the labels of the targets.  The fields
+// which aren't set in the configuration file have the same defaults as in Prometheus.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels" json:"source_labels" desc:"Labels whose values are joined by the separator and matched against the regex"`
+	Separator    string   `yaml:"separator" json:"separator" desc:"Separator of the values of the source labels, ; when not set"`
+	Regex        string   `yaml:"regex" json:"regex" desc:"Regular expression matching the whole joined value, or the label names for labelmap, labeldrop and labelkeep, (.*) when not set"`
+	Modulus      uint64   `yaml:"modulus" json:"modulus" desc:"Modulus of the hash of the joined value for hashmod"`
+	TargetLabel  string   `yaml:"target_label" json:"target_label" desc:"Label set by replace, hashmod, lowercase and uppercase, which may refer to the groups of the regex for replace, or compared to the joined value by keepequal and dropequal"`
+	Replacement  string   `yaml:"replacement" json:"replacement" desc:"Value of the target label for replace, or name of the mapped labels for labelmap, which may refer to the groups of the regex, $1 when not set"`
+	Action       string   `yaml:"action" json:"action" desc:"Action performed when the regex matches, replace when not set" enum:"replace,keep,drop,hashmod,labelmap,labeldrop,labelkeep,lowercase,uppercase,keepequal,dropequal"`
+
+	// The regular expression is compiled by validate, see CompiledRegex
+	regex *regexp.Regexp
+}
+
+// defaultRelabelConfig has the defaults of the fields of the relabel configs
+var defaultRelabelConfig = RelabelConfig{
+	Separator:   ";",
+	Regex:       "(.*)",
+	Replacement: "$1",
+	Action:      RelabelReplace,
+}
+
+// UnmarshalYAML sets the defaults of the fields which aren't set, so that they can still be set
+// to an empty value
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = defaultRelabelConfig
+	type plain RelabelConfig
+	return unmarshal((*plain)(c))
+}
+
+// neverMatch is used in place of a regular expression which doesn't compile
+var neverMatch = regexp.MustCompile(`[^\x00-\x{10FFFF}]`)
+
+// CompiledRegex returns the anchored regular expression of the config.  It's compiled once by
+// validate, and otherwise compiled on each call, since the configs are shared by concurrent
+// requests.  A regular expression which doesn't compile never matches.
+func (c *RelabelConfig) CompiledRegex() *regexp.Regexp {
+	if c.regex != nil {
+		return c.regex
+	}
+	re, err := compileRelabelRegex(c.Regex)
+	if err != nil {
+		return neverMatch
+	}
+	return re
+}
+
+// compileRelabelRegex anchors the regular expression at both ends, like Prometheus
+func compileRelabelRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func validateRelabelConfigs(field string, configs []RelabelConfig) []*FieldError {
+	errs := []*FieldError{}
+	for i := range configs {
+		c := &configs[i]
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if re, err := compileRelabelRegex(c.Regex); err != nil {
+			errs = append(errs, fieldErrorf(f+".regex", "invalid regular expression: %s", err))
+		} else {
+			c.regex = re
+		}
+		for j, l := range c.SourceLabels {
+			if !lib.IsValidLabelName(l) {
+				errs = append(errs, fieldErrorf(fmt.Sprintf("%s.source_labels[%d]", f, j), "'%s' isn't a valid label name", l))
+			}
+		}
+
+		// The same checks as Prometheus, keep and drop only use the regex and the joined value,
+		// which is empty without source labels
+		switch c.Action {
+		case RelabelReplace, RelabelHashMod, RelabelLowercase, RelabelUppercase, RelabelKeepEqual, RelabelDropEqual:
+			if c.TargetLabel == "" {
+				errs = append(errs, fieldErrorf(f+".target_label", "must be set for the %s action", c.Action))
+			} else if (c.Action != RelabelReplace || !strings.Contains(c.TargetLabel, "$")) && !lib.IsValidLabelName(c.TargetLabel) {
+				errs = append(errs, fieldErrorf(f+".target_label", "'%s' isn't a valid label name", c.TargetLabel))
+			}
+			if c.Action == RelabelHashMod && c.Modulus == 0 {
+				errs = append(errs, fieldErrorf(f+".modulus", "must be greater than 0 for the hashmod action"))
+			}
+			if (c.Action == RelabelLowercase || c.Action == RelabelUppercase) && c.Replacement != defaultRelabelConfig.Replacement {
+				errs = append(errs, fieldErrorf(f+".replacement", "can't be set for the %s action", c.Action))
+			}
+			if (c.Action == RelabelKeepEqual || c.Action == RelabelDropEqual) && (c.Regex != defaultRelabelConfig.Regex ||
+				c.Separator != defaultRelabelConfig.Separator || c.Replacement != defaultRelabelConfig.Replacement || c.Modulus != 0) {
+				errs = append(errs, fieldErrorf(f, "the %s action only uses the source labels and the target label", c.Action))
+			}
+		case RelabelKeep, RelabelDrop:
+		case RelabelLabelMap:
+			if !strings.Contains(c.Replacement, "$") && !lib.IsValidLabelName(c.Replacement) {
+				errs = append(errs, fieldErrorf(f+".replacement", "'%s' isn't a valid label name", c.Replacement))
+			}
+		case RelabelLabelDrop, RelabelLabelKeep:
+			if len(c.SourceLabels) > 0 || c.TargetLabel != "" || c.Modulus != 0 ||
+				c.Separator != defaultRelabelConfig.Separator || c.Replacement != defaultRelabelConfig.Replacement {
+				errs = append(errs, fieldErrorf(f, "the %s action only uses the regex", c.Action))
+			}
+		default:
+			errs = append(errs, fieldErrorf(f+".action", "must be one of %s, got '%s'", strings.Join(RelabelActions, ", "), c.Action))
+		}
+	}
+	return errs
+}
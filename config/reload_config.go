@@ -0,0 +1,27 @@
+package config
+
+import (
+	"time"
+)
+
+// ReloadConfig controls whether the configuration file is watched for changes.  The
+// configuration is always reloaded when the process receives a SIGHUP.
+type ReloadConfig struct {
+	WatchFile bool          `yaml:"watch_file" json:"watch_file" desc:"Reload the configuration when the file changes"`
+	Interval  time.Duration `yaml:"interval" json:"interval" desc:"How often the configuration file is checked for changes"`
+}
+
+func newReloadConfig() *ReloadConfig {
+	c := &ReloadConfig{
+		Interval: 10 * time.Second,
+	}
+	return c
+}
+
+func (c *ReloadConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.WatchFile && c.Interval <= 0 {
+		errs = append(errs, fieldErrorf("config_reload.interval", "must be greater than 0"))
+	}
+	return errs
+}
@@ -0,0 +1,23 @@
+package config
+
+// TLSConfig holds the certificate and key used to serve the API over HTTPS
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" json:"cert_file" desc:"Path of the TLS certificate used to serve the API over HTTPS"`
+	KeyFile  string `yaml:"key_file" json:"key_file" desc:"Path of the TLS key used to serve the API over HTTPS"`
+}
+
+// Enabled returns true when a certificate and key are configured
+func (c *TLSConfig) Enabled() bool {
+	return c != nil && c.CertFile != "" && c.KeyFile != ""
+}
+
+func (c *TLSConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.CertFile == "" && c.KeyFile != "" {
+		errs = append(errs, fieldErrorf("tls.cert_file", "must be set when tls.key_file is set"))
+	}
+	if c.KeyFile == "" && c.CertFile != "" {
+		errs = append(errs, fieldErrorf("tls.key_file", "must be set when tls.cert_file is set"))
+	}
+	return errs
+}
@@ -0,0 +1,30 @@
+package config
+
+// TracingConfig configures the export of OpenTelemetry traces to an OTLP/HTTP collector
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled" json:"enabled" desc:"Export traces of the HTTP requests and data store operations"`
+	Endpoint    string            `yaml:"endpoint" json:"endpoint" desc:"Address (host:port) of the OTLP/HTTP collector"`
+	Insecure    bool              `yaml:"insecure" json:"insecure" desc:"Send the traces over plain HTTP instead of HTTPS"`
+	ServiceName string            `yaml:"service_name" json:"service_name" desc:"Service name reported in the traces"`
+	SampleRatio float64           `yaml:"sample_ratio" json:"sample_ratio" desc:"Fraction of the traces started by the server which are sampled" min:"0" max:"1"`
+	Headers     map[string]string `yaml:"headers" json:"headers" desc:"Headers added to the requests sent to the collector"`
+}
+
+func newTracingConfig() *TracingConfig {
+	c := &TracingConfig{
+		ServiceName: "prom-http-sd-server",
+		SampleRatio: 1,
+	}
+	return c
+}
+
+func (c *TracingConfig) validate() []*FieldError {
+	errs := []*FieldError{}
+	if c.Enabled && c.Endpoint == "" {
+		errs = append(errs, fieldErrorf("tracing.endpoint", "must be set when tracing is enabled"))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, fieldErrorf("tracing.sample_ratio", "must be between 0 and 1, got %v", c.SampleRatio))
+	}
+	return errs
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+)
+
+// WebhookEventTypes are the types of events which can be sent to the webhook endpoints
+var WebhookEventTypes = []string{"target_added", "target_removed", "labels_changed"}
+
+// WebhookEndpoint is an HTTP endpoint notified of the changes to the target groups
+type WebhookEndpoint struct {
+	Name    string        `yaml:"name" json:"name" desc:"Name identifying the endpoint"`
+	URL     string        `yaml:"url" json:"url" desc:"URL to which the notifications are posted"`
+	Secret  string        `yaml:"secret" json:"secret" desc:"Secret used to sign the notifications with HMAC-SHA256"`
+	Events  []string      `yaml:"events" json:"events" desc:"Types of events sent to the endpoint (target_added, target_removed, labels_changed), all of them when empty"`
+	Groups  []string      `yaml:"groups" json:"groups" desc:"Target groups whose events are sent to the endpoint, all of them when empty"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout" desc:"Timeout of each delivery attempt, 10s when not set"`
+}
+
+// Wants returns true when events of the type and target group must be sent to the endpoint
+func (e *WebhookEndpoint) Wants(eventType, group string) bool {
+	return (len(e.Events) == 0 || lib.Contains(e.Events, eventType)) && (len(e.Groups) == 0 || lib.Contains(e.Groups, group))
+}
+
+// WebhooksConfig configures the notifications sent to webhook endpoints when target groups change
+type WebhooksConfig struct {
+	OutboxPath     string            `yaml:"outbox_path" json:"outbox_path" desc:"Path of the BoltDB file keeping the notifications until they are delivered and the last revision notified, webhooks are disabled when empty.  With the consul store, only set it on one instance"`
+	MaxAttempts    int               `yaml:"max_attempts" json:"max_attempts" desc:"Number of delivery attempts after which a notification is dropped" min:"1"`
+	InitialBackoff time.Duration     `yaml:"initial_backoff" json:"initial_backoff" desc:"Delay before the first retry, doubled after each failed attempt"`
+	MaxBackoff     time.Duration     `yaml:"max_backoff" json:"max_backoff" desc:"Maximum delay between two attempts"`
+	Endpoints      []WebhookEndpoint `yaml:"endpoints" json:"endpoints" desc:"Endpoints notified of the changes, as a YAML list of name, url, secret, events, groups and timeout"`
+}
+
+func newWebhooksConfig() *WebhooksConfig {
+	c := &WebhooksConfig{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+	}
+	return c
+}
+
+// Enabled returns true when the notifications can be persisted
+func (c *WebhooksConfig) Enabled() bool {
+	return c != nil && c.OutboxPath != ""
+}
+
+// Endpoint returns the endpoint with the given name, or nil if there's none
+func (c *WebhooksConfig) Endpoint(name string) *WebhookEndpoint {
+	for i := range c.Endpoints {
+		if c.Endpoints[i].Name == name {
+			return &c.Endpoints[i]
+		}
+	}
+	return nil
+}
+
+func (c *WebhooksConfig) validate(storePath string) []*FieldError {
+	errs := []*FieldError{}
+	if len(c.Endpoints) > 0 && c.OutboxPath == "" {
+		errs = append(errs, fieldErrorf("webhooks.outbox_path", "must be set when webhook endpoints are configured"))
+	}
+	// BoltDB files are locked while open, so the outbox can't share its file with the data store
+	if c.OutboxPath != "" && c.OutboxPath == storePath {
+		errs = append(errs, fieldErrorf("webhooks.outbox_path", "must not be the same file as local_config.store_path"))
+	}
+	if c.MaxAttempts < 1 {
+		errs = append(errs, fieldErrorf("webhooks.max_attempts", "must be greater than 0"))
+	}
+	if c.InitialBackoff <= 0 {
+		errs = append(errs, fieldErrorf("webhooks.initial_backoff", "must be greater than 0"))
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		errs = append(errs, fieldErrorf("webhooks.max_backoff", "must not be less than initial_backoff"))
+	}
+
+	names := map[string]bool{}
+	for i, e := range c.Endpoints {
+		field := fmt.Sprintf("webhooks.endpoints[%d]", i)
+		if e.Name == "" {
+			errs = append(errs, fieldErrorf(field+".name", "must not be empty"))
+		} else if names[e.Name] {
+			errs = append(errs, fieldErrorf(field+".name", "'%s' is used by more than one endpoint", e.Name))
+		}
+		names[e.Name] = true
+
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fieldErrorf(field+".url", "invalid URL '%s', must be an http or https URL", e.URL))
+		}
+		for _, t := range e.Events {
+			if !lib.Contains(WebhookEventTypes, t) {
+				errs = append(errs, fieldErrorf(field+".events", "unknown event type '%s'", t))
+			}
+		}
+		if e.Timeout < 0 {
+			errs = append(errs, fieldErrorf(field+".timeout", "must not be negative"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,142 @@
+package filesd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/relabel"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	metricWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpsdserver_file_sd_writes_total",
+		Help: "Number of times a file_sd file has been written because its content changed.",
+	}, []string{"path"})
+	metricWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpsdserver_file_sd_write_errors_total",
+		Help: "Number of times a file_sd file could not be written.",
+	}, []string{"path"})
+)
+
+// Run writes the file_sd files of the current configuration, at the interval of the current
+// configuration, until the context is done
+func Run(ctx context.Context, ds store.DataStore) {
+	for {
+		fileSDConf := config.Current().FileSD
+		if len(fileSDConf.Files) > 0 {
+			WriteFiles(ctx, ds, fileSDConf.Files)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(fileSDConf.Interval):
+		}
+	}
+}
+
+// WriteFiles renders the target groups of the data store, with their effective labels, to every
+// file.  Files are only written when their content changes.
+func WriteFiles(ctx context.Context, ds store.DataStore, files []config.FileSDFile) error {
+	groups, err := ds.GetTargetGroups(ctx)
+	if err != nil {
+		logger.Logger.Error("Could not read target groups for the file_sd files", zap.Error(err))
+		return err
+	}
+	groups = store.ResolveTemplates(groups)
+
+	var lastErr error
+	for i := range files {
+		f := &files[i]
+		changed, err := writeFile(f, groups)
+		if err != nil {
+			logger.Logger.Error("Could not write file_sd file", zap.String("path", f.Path), zap.Error(err))
+			metricWriteErrors.WithLabelValues(f.Path).Inc()
+			lastErr = err
+			continue
+		}
+		if changed {
+			logger.Logger.Debug("Wrote file_sd file", zap.String("path", f.Path))
+			metricWrites.WithLabelValues(f.Path).Inc()
+		}
+	}
+	return lastErr
+}
+
+// writeFile renders the target groups selected by the file, relabeled by its relabel configs,
+// and replaces the file when its content differs
+func writeFile(f *config.FileSDFile, groups map[string]*store.TargetGroup) (bool, error) {
+	data, err := store.MarshalTargetGroups(relabel.TargetGroups(selectGroups(f, groups), f.RelabelConfigs), f.FileFormat())
+	if err != nil {
+		return false, err
+	}
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	if current, err := ioutil.ReadFile(f.Path); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	return true, atomicWrite(f.Path, data)
+}
+
+// selectGroups returns the target groups matching the group and label filters of the file
+func selectGroups(f *config.FileSDFile, groups map[string]*store.TargetGroup) map[string]*store.TargetGroup {
+	selected := map[string]*store.TargetGroup{}
+	for name, tg := range groups {
+		if len(f.Groups) > 0 && !lib.Contains(f.Groups, name) {
+			continue
+		}
+		matches := true
+		for k, v := range f.Labels {
+			if tg.Labels[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			selected[name] = tg
+		}
+	}
+	return selected
+}
+
+// atomicWrite writes the data to a temporary file in the same directory, then renames it,
+// so that Prometheus never reads a partially written file
+func atomicWrite(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Could not rename %s: %s", tmp.Name(), err)
+	}
+	return nil
+}
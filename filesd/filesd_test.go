@@ -0,0 +1,195 @@
+package filesd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// fileTargetGroup is a target group as read back from a file_sd file
+type fileTargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// setupFileSDTest returns a data store holding the web and db groups, web inheriting the env
+// label from the base template, which has no targets
+func setupFileSDTest(t *testing.T) store.DataStore {
+	t.Helper()
+	logger.Logger = zap.NewNop()
+	ds, err := store.NewBoltDBDataStore(filepath.Join(t.TempDir(), "store.db"), make(chan bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ds.Shutdown)
+	_, err = ds.ApplyTxn(context.Background(), &store.Txn{Operations: []*store.TxnOp{
+		{Op: store.TxnSetLabel, Group: "base", Label: "env", Value: "prod"},
+		{Op: store.TxnAddTarget, Group: "web", Target: "a:80"},
+		{Op: store.TxnSetLabel, Group: "web", Label: "job", Value: "web"},
+		{Op: store.TxnSetTemplates, Group: "web", Templates: []string{"base"}},
+		{Op: store.TxnAddTarget, Group: "db", Target: "b:5432"},
+		{Op: store.TxnSetLabel, Group: "db", Label: "env", Value: "staging"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func readFile(t *testing.T, path, format string) []fileTargetGroup {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := []fileTargetGroup{}
+	if format == "yaml" {
+		err = yaml.Unmarshal(b, &groups)
+	} else {
+		err = json.Unmarshal(b, &groups)
+	}
+	if err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+	return groups
+}
+
+func TestWriteFilesFilters(t *testing.T) {
+	ds := setupFileSDTest(t)
+	dir := t.TempDir()
+	web := fileTargetGroup{Targets: []string{"a:80"}, Labels: map[string]string{"env": "prod", "job": "web"}}
+	db := fileTargetGroup{Targets: []string{"b:5432"}, Labels: map[string]string{"env": "staging"}}
+
+	for _, tc := range []struct {
+		name string
+		file config.FileSDFile
+		want []fileTargetGroup
+	}{
+		{
+			name: "groups",
+			file: config.FileSDFile{Path: filepath.Join(dir, "groups.json"), Groups: []string{"db"}},
+			want: []fileTargetGroup{db},
+		},
+		{
+			// The labels inherited from the templates are written and match the filter, while the
+			// template, which has no targets, is left out
+			name: "inherited labels",
+			file: config.FileSDFile{Path: filepath.Join(dir, "labels.yml"), Labels: map[string]string{"env": "prod"}},
+			want: []fileTargetGroup{web},
+		},
+		{
+			name: "groups and labels",
+			file: config.FileSDFile{Path: filepath.Join(dir, "none.json"), Groups: []string{"db"}, Labels: map[string]string{"env": "prod"}},
+			want: []fileTargetGroup{},
+		},
+		{
+			name: "relabeled",
+			file: config.FileSDFile{Path: filepath.Join(dir, "relabeled.json"), Groups: []string{"web"}, RelabelConfigs: []config.RelabelConfig{
+				{Action: "labeldrop", Regex: "job"},
+			}},
+			want: []fileTargetGroup{{Targets: []string{"a:80"}, Labels: map[string]string{"env": "prod"}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := WriteFiles(context.Background(), ds, []config.FileSDFile{tc.file}); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, tc.file.Path, tc.file.FileFormat()); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteFilesOnlyWritesChanges(t *testing.T) {
+	ds := setupFileSDTest(t)
+	f := config.FileSDFile{Path: filepath.Join(t.TempDir(), "targets.json"), Groups: []string{"db"}}
+	ctx := context.Background()
+	if err := WriteFiles(ctx, ds, []config.FileSDFile{f}); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(f.Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing a group which isn't written to the file leaves it untouched
+	if err := ds.AddTargetToGroup(ctx, "web", "c:80"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFiles(ctx, ds, []config.FileSDFile{f}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(f.Path); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(old) {
+		t.Fatalf("the unchanged file was written at %s", info.ModTime())
+	}
+
+	if err := ds.AddTargetToGroup(ctx, "db", "d:5432"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFiles(ctx, ds, []config.FileSDFile{f}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(f.Path); err != nil {
+		t.Fatal(err)
+	} else if info.ModTime().Equal(old) {
+		t.Fatal("the changed file wasn't written")
+	}
+	want := []fileTargetGroup{{Targets: []string{"b:5432", "d:5432"}, Labels: map[string]string{"env": "staging"}}}
+	if got := readFile(t, f.Path, "json"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "targets.json")
+	if err := os.WriteFile(path, []byte("[]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := atomicWrite(path, []byte("[{}]\n")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file is replaced by a new one rather than rewritten in place
+	if os.SameFile(before, after) {
+		t.Error("the file was written in place")
+	}
+	if after.Mode().Perm() != 0644 {
+		t.Errorf("got mode %s, want %s", after.Mode().Perm(), os.FileMode(0644))
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "[{}]\n" {
+		t.Errorf("got content %q, %v", b, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("the temporary file was left in %s: %v", dir, entries)
+	}
+
+	if err := atomicWrite(filepath.Join(dir, "missing", "targets.json"), []byte("[]\n")); err == nil {
+		t.Error("writing to a missing directory succeeded")
+	}
+}
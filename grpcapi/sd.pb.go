@@ -0,0 +1,726 @@
+// gRPC API of prom-http-sd-server.  sd.pb.go and sd_grpc.pb.go are generated from this file,
+// with `go generate ./grpcapi`.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: sd.proto
+
+package grpcapi
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_sd_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{0}
+}
+
+type GroupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GroupRequest) Reset() {
+	*x = GroupRequest{}
+	mi := &file_sd_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupRequest) ProtoMessage() {}
+
+func (x *GroupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupRequest.ProtoReflect.Descriptor instead.
+func (*GroupRequest) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GroupRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+type TargetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Target        string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TargetRequest) Reset() {
+	*x = TargetRequest{}
+	mi := &file_sd_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TargetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TargetRequest) ProtoMessage() {}
+
+func (x *TargetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TargetRequest.ProtoReflect.Descriptor instead.
+func (*TargetRequest) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TargetRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *TargetRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+type LabelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Label         string                 `protobuf:"bytes,2,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelRequest) Reset() {
+	*x = LabelRequest{}
+	mi := &file_sd_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelRequest) ProtoMessage() {}
+
+func (x *LabelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelRequest.ProtoReflect.Descriptor instead.
+func (*LabelRequest) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LabelRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *LabelRequest) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+type LabelsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LabelsRequest) Reset() {
+	*x = LabelsRequest{}
+	mi := &file_sd_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LabelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LabelsRequest) ProtoMessage() {}
+
+func (x *LabelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LabelsRequest.ProtoReflect.Descriptor instead.
+func (*LabelsRequest) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LabelsRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *LabelsRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type Labels struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Labels        map[string]string      `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Labels) Reset() {
+	*x = Labels{}
+	mi := &file_sd_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Labels) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Labels) ProtoMessage() {}
+
+func (x *Labels) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Labels.ProtoReflect.Descriptor instead.
+func (*Labels) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Labels) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type TargetGroup struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Name    string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Targets []string               `protobuf:"bytes,2,rep,name=targets,proto3" json:"targets,omitempty"`
+	// Labels set on the group itself
+	Labels map[string]string `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Labels of the group layered over those of its templates, as exposed to Prometheus
+	EffectiveLabels map[string]string `protobuf:"bytes,4,rep,name=effective_labels,json=effectiveLabels,proto3" json:"effective_labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *TargetGroup) Reset() {
+	*x = TargetGroup{}
+	mi := &file_sd_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TargetGroup) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TargetGroup) ProtoMessage() {}
+
+func (x *TargetGroup) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TargetGroup.ProtoReflect.Descriptor instead.
+func (*TargetGroup) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TargetGroup) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TargetGroup) GetTargets() []string {
+	if x != nil {
+		return x.Targets
+	}
+	return nil
+}
+
+func (x *TargetGroup) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *TargetGroup) GetEffectiveLabels() map[string]string {
+	if x != nil {
+		return x.EffectiveLabels
+	}
+	return nil
+}
+
+type TargetGroupList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Groups        []*TargetGroup         `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TargetGroupList) Reset() {
+	*x = TargetGroupList{}
+	mi := &file_sd_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TargetGroupList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TargetGroupList) ProtoMessage() {}
+
+func (x *TargetGroupList) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TargetGroupList.ProtoReflect.Descriptor instead.
+func (*TargetGroupList) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TargetGroupList) GetGroups() []*TargetGroup {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only stream the events of these groups
+	Groups []string `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	// Only stream the events of the groups having these labels
+	Labels map[string]string `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Resume the stream after this revision.  When the following events are no longer known,
+	// the stream starts with a reset event instead.
+	Revision      *uint64 `protobuf:"varint,3,opt,name=revision,proto3,oneof" json:"revision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_sd_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchRequest) GetGroups() []string {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *WatchRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *WatchRequest) GetRevision() uint64 {
+	if x != nil && x.Revision != nil {
+		return *x.Revision
+	}
+	return 0
+}
+
+type Event struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Revision uint64                 `protobuf:"varint,1,opt,name=revision,proto3" json:"revision,omitempty"`
+	// reset, target_added, target_removed or labels_changed
+	Type            string            `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Group           string            `protobuf:"bytes,3,opt,name=group,proto3" json:"group,omitempty"`
+	Target          string            `protobuf:"bytes,4,opt,name=target,proto3" json:"target,omitempty"`
+	Labels          map[string]string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	EffectiveLabels map[string]string `protobuf:"bytes,6,rep,name=effective_labels,json=effectiveLabels,proto3" json:"effective_labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_sd_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_sd_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_sd_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Event) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Event) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Event) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Event) GetEffectiveLabels() map[string]string {
+	if x != nil {
+		return x.EffectiveLabels
+	}
+	return nil
+}
+
+var File_sd_proto protoreflect.FileDescriptor
+
+const file_sd_proto_rawDesc = "" +
+	"\n" +
+	"\bsd.proto\x12\rpromhttpsd.v1\"\a\n" +
+	"\x05Empty\"$\n" +
+	"\fGroupRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\"=\n" +
+	"\rTargetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\":\n" +
+	"\fLabelRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x14\n" +
+	"\x05label\x18\x02 \x01(\tR\x05label\"\xa2\x01\n" +
+	"\rLabelsRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12@\n" +
+	"\x06labels\x18\x02 \x03(\v2(.promhttpsd.v1.LabelsRequest.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"~\n" +
+	"\x06Labels\x129\n" +
+	"\x06labels\x18\x01 \x03(\v2!.promhttpsd.v1.Labels.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xd6\x02\n" +
+	"\vTargetGroup\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\atargets\x18\x02 \x03(\tR\atargets\x12>\n" +
+	"\x06labels\x18\x03 \x03(\v2&.promhttpsd.v1.TargetGroup.LabelsEntryR\x06labels\x12Z\n" +
+	"\x10effective_labels\x18\x04 \x03(\v2/.promhttpsd.v1.TargetGroup.EffectiveLabelsEntryR\x0feffectiveLabels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aB\n" +
+	"\x14EffectiveLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"E\n" +
+	"\x0fTargetGroupList\x122\n" +
+	"\x06groups\x18\x01 \x03(\v2\x1a.promhttpsd.v1.TargetGroupR\x06groups\"\xd0\x01\n" +
+	"\fWatchRequest\x12\x16\n" +
+	"\x06groups\x18\x01 \x03(\tR\x06groups\x12?\n" +
+	"\x06labels\x18\x02 \x03(\v2'.promhttpsd.v1.WatchRequest.LabelsEntryR\x06labels\x12\x1f\n" +
+	"\brevision\x18\x03 \x01(\x04H\x00R\brevision\x88\x01\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\v\n" +
+	"\t_revision\"\xf4\x02\n" +
+	"\x05Event\x12\x1a\n" +
+	"\brevision\x18\x01 \x01(\x04R\brevision\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
+	"\x05group\x18\x03 \x01(\tR\x05group\x12\x16\n" +
+	"\x06target\x18\x04 \x01(\tR\x06target\x128\n" +
+	"\x06labels\x18\x05 \x03(\v2 .promhttpsd.v1.Event.LabelsEntryR\x06labels\x12T\n" +
+	"\x10effective_labels\x18\x06 \x03(\v2).promhttpsd.v1.Event.EffectiveLabelsEntryR\x0feffectiveLabels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aB\n" +
+	"\x14EffectiveLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\xd1\x04\n" +
+	"\fTargetGroups\x12F\n" +
+	"\x10AddTargetToGroup\x12\x1c.promhttpsd.v1.TargetRequest\x1a\x14.promhttpsd.v1.Empty\x12K\n" +
+	"\x15RemoveTargetFromGroup\x12\x1c.promhttpsd.v1.TargetRequest\x1a\x14.promhttpsd.v1.Empty\x12F\n" +
+	"\x11RemoveTargetGroup\x12\x1b.promhttpsd.v1.GroupRequest\x1a\x14.promhttpsd.v1.Empty\x12J\n" +
+	"\x14GetTargetGroupLabels\x12\x1b.promhttpsd.v1.GroupRequest\x1a\x15.promhttpsd.v1.Labels\x12G\n" +
+	"\x0fGetTargetGroups\x12\x14.promhttpsd.v1.Empty\x1a\x1e.promhttpsd.v1.TargetGroupList\x12F\n" +
+	"\x10AddLabelsToGroup\x12\x1c.promhttpsd.v1.LabelsRequest\x1a\x14.promhttpsd.v1.Empty\x12I\n" +
+	"\x14RemoveLabelFromGroup\x12\x1b.promhttpsd.v1.LabelRequest\x1a\x14.promhttpsd.v1.Empty\x12<\n" +
+	"\x05Watch\x12\x1b.promhttpsd.v1.WatchRequest\x1a\x14.promhttpsd.v1.Event0\x01B5Z3github.com/hartfordfive/prom-http-sd-server/grpcapib\x06proto3"
+
+var (
+	file_sd_proto_rawDescOnce sync.Once
+	file_sd_proto_rawDescData []byte
+)
+
+func file_sd_proto_rawDescGZIP() []byte {
+	file_sd_proto_rawDescOnce.Do(func() {
+		file_sd_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sd_proto_rawDesc), len(file_sd_proto_rawDesc)))
+	})
+	return file_sd_proto_rawDescData
+}
+
+var file_sd_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_sd_proto_goTypes = []any{
+	(*Empty)(nil),           // 0: promhttpsd.v1.Empty
+	(*GroupRequest)(nil),    // 1: promhttpsd.v1.GroupRequest
+	(*TargetRequest)(nil),   // 2: promhttpsd.v1.TargetRequest
+	(*LabelRequest)(nil),    // 3: promhttpsd.v1.LabelRequest
+	(*LabelsRequest)(nil),   // 4: promhttpsd.v1.LabelsRequest
+	(*Labels)(nil),          // 5: promhttpsd.v1.Labels
+	(*TargetGroup)(nil),     // 6: promhttpsd.v1.TargetGroup
+	(*TargetGroupList)(nil), // 7: promhttpsd.v1.TargetGroupList
+	(*WatchRequest)(nil),    // 8: promhttpsd.v1.WatchRequest
+	(*Event)(nil),           // 9: promhttpsd.v1.Event
+	nil,                     // 10: promhttpsd.v1.LabelsRequest.LabelsEntry
+	nil,                     // 11: promhttpsd.v1.Labels.LabelsEntry
+	nil,                     // 12: promhttpsd.v1.TargetGroup.LabelsEntry
+	nil,                     // 13: promhttpsd.v1.TargetGroup.EffectiveLabelsEntry
+	nil,                     // 14: promhttpsd.v1.WatchRequest.LabelsEntry
+	nil,                     // 15: promhttpsd.v1.Event.LabelsEntry
+	nil,                     // 16: promhttpsd.v1.Event.EffectiveLabelsEntry
+}
+var file_sd_proto_depIdxs = []int32{
+	10, // 0: promhttpsd.v1.LabelsRequest.labels:type_name -> promhttpsd.v1.LabelsRequest.LabelsEntry
+	11, // 1: promhttpsd.v1.Labels.labels:type_name -> promhttpsd.v1.Labels.LabelsEntry
+	12, // 2: promhttpsd.v1.TargetGroup.labels:type_name -> promhttpsd.v1.TargetGroup.LabelsEntry
+	13, // 3: promhttpsd.v1.TargetGroup.effective_labels:type_name -> promhttpsd.v1.TargetGroup.EffectiveLabelsEntry
+	6,  // 4: promhttpsd.v1.TargetGroupList.groups:type_name -> promhttpsd.v1.TargetGroup
+	14, // 5: promhttpsd.v1.WatchRequest.labels:type_name -> promhttpsd.v1.WatchRequest.LabelsEntry
+	15, // 6: promhttpsd.v1.Event.labels:type_name -> promhttpsd.v1.Event.LabelsEntry
+	16, // 7: promhttpsd.v1.Event.effective_labels:type_name -> promhttpsd.v1.Event.EffectiveLabelsEntry
+	2,  // 8: promhttpsd.v1.TargetGroups.AddTargetToGroup:input_type -> promhttpsd.v1.TargetRequest
+	2,  // 9: promhttpsd.v1.TargetGroups.RemoveTargetFromGroup:input_type -> promhttpsd.v1.TargetRequest
+	1,  // 10: promhttpsd.v1.TargetGroups.RemoveTargetGroup:input_type -> promhttpsd.v1.GroupRequest
+	1,  // 11: promhttpsd.v1.TargetGroups.GetTargetGroupLabels:input_type -> promhttpsd.v1.GroupRequest
+	0,  // 12: promhttpsd.v1.TargetGroups.GetTargetGroups:input_type -> promhttpsd.v1.Empty
+	4,  // 13: promhttpsd.v1.TargetGroups.AddLabelsToGroup:input_type -> promhttpsd.v1.LabelsRequest
+	3,  // 14: promhttpsd.v1.TargetGroups.RemoveLabelFromGroup:input_type -> promhttpsd.v1.LabelRequest
+	8,  // 15: promhttpsd.v1.TargetGroups.Watch:input_type -> promhttpsd.v1.WatchRequest
+	0,  // 16: promhttpsd.v1.TargetGroups.AddTargetToGroup:output_type -> promhttpsd.v1.Empty
+	0,  // 17: promhttpsd.v1.TargetGroups.RemoveTargetFromGroup:output_type -> promhttpsd.v1.Empty
+	0,  // 18: promhttpsd.v1.TargetGroups.RemoveTargetGroup:output_type -> promhttpsd.v1.Empty
+	5,  // 19: promhttpsd.v1.TargetGroups.GetTargetGroupLabels:output_type -> promhttpsd.v1.Labels
+	7,  // 20: promhttpsd.v1.TargetGroups.GetTargetGroups:output_type -> promhttpsd.v1.TargetGroupList
+	0,  // 21: promhttpsd.v1.TargetGroups.AddLabelsToGroup:output_type -> promhttpsd.v1.Empty
+	0,  // 22: promhttpsd.v1.TargetGroups.RemoveLabelFromGroup:output_type -> promhttpsd.v1.Empty
+	9,  // 23: promhttpsd.v1.TargetGroups.Watch:output_type -> promhttpsd.v1.Event
+	16, // [16:24] is the sub-list for method output_type
+	8,  // [8:16] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_sd_proto_init() }
+func file_sd_proto_init() {
+	if File_sd_proto != nil {
+		return
+	}
+	file_sd_proto_msgTypes[8].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sd_proto_rawDesc), len(file_sd_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sd_proto_goTypes,
+		DependencyIndexes: file_sd_proto_depIdxs,
+		MessageInfos:      file_sd_proto_msgTypes,
+	}.Build()
+	File_sd_proto = out.File
+	file_sd_proto_goTypes = nil
+	file_sd_proto_depIdxs = nil
+}
@@ -0,0 +1,408 @@
+// gRPC API of prom-http-sd-server.  sd.pb.go and sd_grpc.pb.go are generated from this file,
+// with `go generate ./grpcapi`.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: sd.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TargetGroups_AddTargetToGroup_FullMethodName      = "/promhttpsd.v1.TargetGroups/AddTargetToGroup"
+	TargetGroups_RemoveTargetFromGroup_FullMethodName = "/promhttpsd.v1.TargetGroups/RemoveTargetFromGroup"
+	TargetGroups_RemoveTargetGroup_FullMethodName     = "/promhttpsd.v1.TargetGroups/RemoveTargetGroup"
+	TargetGroups_GetTargetGroupLabels_FullMethodName  = "/promhttpsd.v1.TargetGroups/GetTargetGroupLabels"
+	TargetGroups_GetTargetGroups_FullMethodName       = "/promhttpsd.v1.TargetGroups/GetTargetGroups"
+	TargetGroups_AddLabelsToGroup_FullMethodName      = "/promhttpsd.v1.TargetGroups/AddLabelsToGroup"
+	TargetGroups_RemoveLabelFromGroup_FullMethodName  = "/promhttpsd.v1.TargetGroups/RemoveLabelFromGroup"
+	TargetGroups_Watch_FullMethodName                 = "/promhttpsd.v1.TargetGroups/Watch"
+)
+
+// TargetGroupsClient is the client API for TargetGroups service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TargetGroups exposes the operations of the data store.  When authentication is enabled,
+// the methods which modify the target groups require an "authorization: Bearer <token>"
+// metadata entry.
+type TargetGroupsClient interface {
+	// Adds the target to the group, which can't be created this way when the label policy
+	// requires labels
+	AddTargetToGroup(ctx context.Context, in *TargetRequest, opts ...grpc.CallOption) (*Empty, error)
+	RemoveTargetFromGroup(ctx context.Context, in *TargetRequest, opts ...grpc.CallOption) (*Empty, error)
+	RemoveTargetGroup(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetTargetGroupLabels(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Labels, error)
+	GetTargetGroups(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TargetGroupList, error)
+	AddLabelsToGroup(ctx context.Context, in *LabelsRequest, opts ...grpc.CallOption) (*Empty, error)
+	RemoveLabelFromGroup(ctx context.Context, in *LabelRequest, opts ...grpc.CallOption) (*Empty, error)
+	// Watch streams the changes of the target groups, like GET /api/watch
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+}
+
+type targetGroupsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTargetGroupsClient(cc grpc.ClientConnInterface) TargetGroupsClient {
+	return &targetGroupsClient{cc}
+}
+
+func (c *targetGroupsClient) AddTargetToGroup(ctx context.Context, in *TargetRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, TargetGroups_AddTargetToGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) RemoveTargetFromGroup(ctx context.Context, in *TargetRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, TargetGroups_RemoveTargetFromGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) RemoveTargetGroup(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, TargetGroups_RemoveTargetGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) GetTargetGroupLabels(ctx context.Context, in *GroupRequest, opts ...grpc.CallOption) (*Labels, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Labels)
+	err := c.cc.Invoke(ctx, TargetGroups_GetTargetGroupLabels_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) GetTargetGroups(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*TargetGroupList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TargetGroupList)
+	err := c.cc.Invoke(ctx, TargetGroups_GetTargetGroups_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) AddLabelsToGroup(ctx context.Context, in *LabelsRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, TargetGroups_AddLabelsToGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) RemoveLabelFromGroup(ctx context.Context, in *LabelRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, TargetGroups_RemoveLabelFromGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetGroupsClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TargetGroups_ServiceDesc.Streams[0], TargetGroups_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TargetGroups_WatchClient = grpc.ServerStreamingClient[Event]
+
+// TargetGroupsServer is the server API for TargetGroups service.
+// All implementations must embed UnimplementedTargetGroupsServer
+// for forward compatibility.
+//
+// TargetGroups exposes the operations of the data store.  When authentication is enabled,
+// the methods which modify the target groups require an "authorization: Bearer <token>"
+// metadata entry.
+type TargetGroupsServer interface {
+	// Adds the target to the group, which can't be created this way when the label policy
+	// requires labels
+	AddTargetToGroup(context.Context, *TargetRequest) (*Empty, error)
+	RemoveTargetFromGroup(context.Context, *TargetRequest) (*Empty, error)
+	RemoveTargetGroup(context.Context, *GroupRequest) (*Empty, error)
+	GetTargetGroupLabels(context.Context, *GroupRequest) (*Labels, error)
+	GetTargetGroups(context.Context, *Empty) (*TargetGroupList, error)
+	AddLabelsToGroup(context.Context, *LabelsRequest) (*Empty, error)
+	RemoveLabelFromGroup(context.Context, *LabelRequest) (*Empty, error)
+	// Watch streams the changes of the target groups, like GET /api/watch
+	Watch(*WatchRequest, grpc.ServerStreamingServer[Event]) error
+	mustEmbedUnimplementedTargetGroupsServer()
+}
+
+// UnimplementedTargetGroupsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTargetGroupsServer struct{}
+
+func (UnimplementedTargetGroupsServer) AddTargetToGroup(context.Context, *TargetRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddTargetToGroup not implemented")
+}
+func (UnimplementedTargetGroupsServer) RemoveTargetFromGroup(context.Context, *TargetRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveTargetFromGroup not implemented")
+}
+func (UnimplementedTargetGroupsServer) RemoveTargetGroup(context.Context, *GroupRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveTargetGroup not implemented")
+}
+func (UnimplementedTargetGroupsServer) GetTargetGroupLabels(context.Context, *GroupRequest) (*Labels, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTargetGroupLabels not implemented")
+}
+func (UnimplementedTargetGroupsServer) GetTargetGroups(context.Context, *Empty) (*TargetGroupList, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTargetGroups not implemented")
+}
+func (UnimplementedTargetGroupsServer) AddLabelsToGroup(context.Context, *LabelsRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddLabelsToGroup not implemented")
+}
+func (UnimplementedTargetGroupsServer) RemoveLabelFromGroup(context.Context, *LabelRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveLabelFromGroup not implemented")
+}
+func (UnimplementedTargetGroupsServer) Watch(*WatchRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedTargetGroupsServer) mustEmbedUnimplementedTargetGroupsServer() {}
+func (UnimplementedTargetGroupsServer) testEmbeddedByValue()                      {}
+
+// UnsafeTargetGroupsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TargetGroupsServer will
+// result in compilation errors.
+type UnsafeTargetGroupsServer interface {
+	mustEmbedUnimplementedTargetGroupsServer()
+}
+
+func RegisterTargetGroupsServer(s grpc.ServiceRegistrar, srv TargetGroupsServer) {
+	// If the following call panics, it indicates UnimplementedTargetGroupsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TargetGroups_ServiceDesc, srv)
+}
+
+func _TargetGroups_AddTargetToGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TargetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).AddTargetToGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_AddTargetToGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).AddTargetToGroup(ctx, req.(*TargetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_RemoveTargetFromGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TargetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).RemoveTargetFromGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_RemoveTargetFromGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).RemoveTargetFromGroup(ctx, req.(*TargetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_RemoveTargetGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).RemoveTargetGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_RemoveTargetGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).RemoveTargetGroup(ctx, req.(*GroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_GetTargetGroupLabels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).GetTargetGroupLabels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_GetTargetGroupLabels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).GetTargetGroupLabels(ctx, req.(*GroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_GetTargetGroups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).GetTargetGroups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_GetTargetGroups_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).GetTargetGroups(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_AddLabelsToGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).AddLabelsToGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_AddLabelsToGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).AddLabelsToGroup(ctx, req.(*LabelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_RemoveLabelFromGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetGroupsServer).RemoveLabelFromGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetGroups_RemoveLabelFromGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetGroupsServer).RemoveLabelFromGroup(ctx, req.(*LabelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetGroups_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TargetGroupsServer).Watch(m, &grpc.GenericServerStream[WatchRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TargetGroups_WatchServer = grpc.ServerStreamingServer[Event]
+
+// TargetGroups_ServiceDesc is the grpc.ServiceDesc for TargetGroups service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TargetGroups_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "promhttpsd.v1.TargetGroups",
+	HandlerType: (*TargetGroupsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddTargetToGroup",
+			Handler:    _TargetGroups_AddTargetToGroup_Handler,
+		},
+		{
+			MethodName: "RemoveTargetFromGroup",
+			Handler:    _TargetGroups_RemoveTargetFromGroup_Handler,
+		},
+		{
+			MethodName: "RemoveTargetGroup",
+			Handler:    _TargetGroups_RemoveTargetGroup_Handler,
+		},
+		{
+			MethodName: "GetTargetGroupLabels",
+			Handler:    _TargetGroups_GetTargetGroupLabels_Handler,
+		},
+		{
+			MethodName: "GetTargetGroups",
+			Handler:    _TargetGroups_GetTargetGroups_Handler,
+		},
+		{
+			MethodName: "AddLabelsToGroup",
+			Handler:    _TargetGroups_AddLabelsToGroup_Handler,
+		},
+		{
+			MethodName: "RemoveLabelFromGroup",
+			Handler:    _TargetGroups_RemoveLabelFromGroup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _TargetGroups_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sd.proto",
+}
@@ -0,0 +1,193 @@
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sd.proto
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"github.com/hartfordfive/prom-http-sd-server/auth"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	metricGRPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "httpsdserver_grpc_req_duration_seconds",
+		Help: "Duration of gRPC requests.",
+	}, []string{"method", "code"})
+)
+
+// readOnlyMethods are the methods allowed anonymously when authentication is enabled
+var readOnlyMethods = map[string]bool{
+	TargetGroups_GetTargetGroupLabels_FullMethodName: true,
+	TargetGroups_GetTargetGroups_FullMethodName:      true,
+	TargetGroups_Watch_FullMethodName:                true,
+}
+
+// NewServer creates a gRPC server serving the TargetGroups service from store.StoreInstance,
+// with the same authentication as the REST API.  opts are added to the options of the server,
+// for example to set its TLS credentials.
+func NewServer(conf *config.GRPCConfig, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time: conf.KeepaliveInterval,
+		}),
+		// Clients registering themselves keep their connection open between calls
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.ChainUnaryInterceptor(unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamInterceptor),
+	}, opts...)
+	srv := grpc.NewServer(opts...)
+	RegisterTargetGroupsServer(srv, &service{})
+	return srv
+}
+
+// authenticate returns the context of the call carrying the identity of the caller, or an
+// Unauthenticated error when the method modifies data and the caller has no valid token
+func authenticate(ctx context.Context, method string) (context.Context, error) {
+	authConf := config.Current().Auth
+	if !authConf.Enabled() {
+		return ctx, nil
+	}
+
+	id := auth.Authenticate(authConf, bearerToken(ctx))
+	if id != nil {
+		ctx = auth.NewContext(ctx, id)
+	}
+	if id == nil && !readOnlyMethods[method] {
+		return ctx, status.Error(codes.Unauthenticated, "A valid bearer token is required")
+	}
+	return ctx, nil
+}
+
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, h := range md.Get("authorization") {
+		if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+			return strings.TrimSpace(h[7:])
+		}
+	}
+	return ""
+}
+
+// record observes the duration of the call and, for the methods modifying data, adds it to
+// the audit log like the REST requests, along with the change requested by req
+func record(ctx context.Context, method string, req interface{}, start time.Time, err error) {
+	code := status.Code(err)
+	metricGRPCDuration.WithLabelValues(method, code.String()).Observe(time.Since(start).Seconds())
+
+	log := logger.Logger.With(zap.String("method", method), zap.String("code", code.String()))
+	if err != nil && code != codes.NotFound && code != codes.InvalidArgument && code != codes.Unauthenticated {
+		log.Error("gRPC call failed", zap.Error(err))
+	} else {
+		log.Debug("gRPC call served")
+	}
+
+	if readOnlyMethods[method] {
+		return
+	}
+	identity := ""
+	if id := auth.FromContext(ctx); id != nil {
+		identity = id.Name
+	}
+	e := audit.Entry{
+		Time:     start,
+		Identity: identity,
+		Method:   "GRPC",
+		Path:     method,
+		Status:   httpStatus(code),
+	}
+	if c := requestedChange(method, req); c != nil {
+		e.Groups, e.Changes = []string{c.Name}, []*store.GroupDiff{c}
+	}
+	audit.DefaultLog.Add(e)
+}
+
+// requestedChange returns the change of the target group requested by a call to the method
+// modifying it
+func requestedChange(method string, req interface{}) *store.GroupDiff {
+	switch req := req.(type) {
+	case *TargetRequest:
+		if method == TargetGroups_AddTargetToGroup_FullMethodName {
+			return &store.GroupDiff{Name: req.Group, AddedTargets: []string{req.Target}}
+		}
+		return &store.GroupDiff{Name: req.Group, RemovedTargets: []string{req.Target}}
+	case *GroupRequest:
+		return &store.GroupDiff{Name: req.Group, Deleted: true}
+	case *LabelsRequest:
+		return &store.GroupDiff{Name: req.Group, SetLabels: req.Labels}
+	case *LabelRequest:
+		return &store.GroupDiff{Name: req.Group, RemovedLabels: []string{req.Label}}
+	}
+	return nil
+}
+
+// httpStatus returns the HTTP status code equivalent to the gRPC code, as recorded in the audit log
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return 200
+	case codes.InvalidArgument:
+		return 400
+	case codes.Unauthenticated:
+		return 401
+	case codes.PermissionDenied:
+		return 403
+	case codes.NotFound:
+		return 404
+	case codes.AlreadyExists, codes.Aborted:
+		return 409
+	case codes.FailedPrecondition:
+		return 412
+	case codes.Unavailable:
+		return 503
+	}
+	return 500
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := authenticate(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	record(ctx, info.FullMethod, req, start, err)
+	return resp, err
+}
+
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := authenticate(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+	record(ctx, info.FullMethod, nil, start, err)
+	return err
+}
+
+// authenticatedStream overrides the context of the stream with the one carrying the identity of
+// the caller
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
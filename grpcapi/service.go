@@ -0,0 +1,180 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/policy"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// service implements the TargetGroups service of sd.proto on top of store.StoreInstance
+type service struct {
+	UnimplementedTargetGroupsServer
+}
+
+// checkLabels returns an error when the labels set and removed on the target group violate the
+// label policy.  creates tells whether the call creates the group if it doesn't exist.  The
+// change must be applied with the returned context, conditioned on the state of the group the
+// policy was checked against.
+func checkLabels(ctx context.Context, group string, set map[string]string, removed []string, creates bool) (context.Context, error) {
+	c, err := policy.NewChange(ctx, store.StoreInstance, group, set, removed, creates)
+	if err != nil {
+		return ctx, storeError(err)
+	}
+	if err := policy.Check(ctx, c); err != nil {
+		pe := &policy.Error{}
+		if errors.As(err, &pe) && pe.PermissionDenied {
+			return ctx, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return ctx, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(c.Conditions) > 0 {
+		ctx = store.WithConditions(ctx, c.Conditions...)
+	}
+	return ctx, nil
+}
+
+// storeError returns the gRPC status corresponding to an error returned by the data store
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, store.ErrTargetGroupNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrTargetGroupExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, store.ErrLabelConflict), errors.Is(err, store.ErrConcurrentModification):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, store.ErrTxnConditionFailed), errors.Is(err, store.ErrTemplateInUse):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *service) AddTargetToGroup(ctx context.Context, req *TargetRequest) (*Empty, error) {
+	if !lib.IsValidTargetName(req.Target) {
+		return nil, status.Error(codes.InvalidArgument, "Target name is invalid")
+	}
+	ctx, err := checkLabels(ctx, req.Group, nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.StoreInstance.AddTargetToGroup(ctx, req.Group, req.Target); err != nil {
+		return nil, storeError(err)
+	}
+	return &Empty{}, nil
+}
+
+func (s *service) RemoveTargetFromGroup(ctx context.Context, req *TargetRequest) (*Empty, error) {
+	if err := store.StoreInstance.RemoveTargetFromGroup(ctx, req.Group, req.Target); err != nil {
+		return nil, storeError(err)
+	}
+	return &Empty{}, nil
+}
+
+func (s *service) RemoveTargetGroup(ctx context.Context, req *GroupRequest) (*Empty, error) {
+	if err := store.StoreInstance.RemoveTargetGroup(ctx, req.Group); err != nil {
+		return nil, storeError(err)
+	}
+	return &Empty{}, nil
+}
+
+func (s *service) GetTargetGroupLabels(ctx context.Context, req *GroupRequest) (*Labels, error) {
+	labels, err := store.StoreInstance.GetTargetGroupLabels(ctx, req.Group)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &Labels{Labels: *labels}, nil
+}
+
+func (s *service) GetTargetGroups(ctx context.Context, _ *Empty) (*TargetGroupList, error) {
+	groups, err := store.StoreInstance.GetTargetGroups(ctx)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := &TargetGroupList{}
+	resolved := store.ResolveTemplates(groups)
+	for _, name := range names {
+		tg := groups[name]
+		res.Groups = append(res.Groups, &TargetGroup{Name: name, Targets: tg.Targets, Labels: tg.Labels, EffectiveLabels: resolved[name].Labels})
+	}
+	return res, nil
+}
+
+func (s *service) AddLabelsToGroup(ctx context.Context, req *LabelsRequest) (*Empty, error) {
+	for name := range req.Labels {
+		if !lib.IsValidLabelName(name) {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Label name '%s' is invalid", name))
+		}
+	}
+	ctx, err := checkLabels(ctx, req.Group, req.Labels, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.StoreInstance.AddLabelsToGroup(ctx, req.Group, req.Labels); err != nil {
+		return nil, storeError(err)
+	}
+	return &Empty{}, nil
+}
+
+func (s *service) RemoveLabelFromGroup(ctx context.Context, req *LabelRequest) (*Empty, error) {
+	ctx, err := checkLabels(ctx, req.Group, nil, []string{req.Label}, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.StoreInstance.RemoveLabelFromGroup(ctx, req.Group, req.Label); err != nil {
+		return nil, storeError(err)
+	}
+	return &Empty{}, nil
+}
+
+// Watch streams the changes of the target groups, like handler.WatchHandler
+func (s *service) Watch(req *WatchRequest, stream grpc.ServerStreamingServer[Event]) error {
+	filter := &store.EventFilter{Groups: req.Groups, Labels: req.Labels}
+
+	events, sub, err := store.WatcherInstance.Subscribe(req.GetRevision(), req.Revision != nil)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer sub.Close()
+
+	for {
+		for _, e := range events {
+			if !filter.Match(e) {
+				continue
+			}
+			err := stream.Send(&Event{
+				Revision:        e.Revision,
+				Type:            e.Type,
+				Group:           e.Group,
+				Target:          e.Target,
+				Labels:          e.Labels,
+				EffectiveLabels: e.EffectiveLabels,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case events = <-sub.C:
+			if events == nil {
+				// The watcher stopped or dropped the subscription
+				return status.Error(codes.Unavailable, "Watch stream closed by the server")
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
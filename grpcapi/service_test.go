@@ -0,0 +1,143 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// setupGRPCTest serves the TargetGroups service from a new Bolt data store, with the
+// authentication tokens, and returns a client connected to it
+func setupGRPCTest(t *testing.T, tokens ...config.AuthToken) TargetGroupsClient {
+	t.Helper()
+	logger.Logger = zap.NewNop()
+	ds, err := store.NewBoltDBDataStore(filepath.Join(t.TempDir(), "store.db"), make(chan bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, previousConf := store.StoreInstance, config.Current()
+	store.StoreInstance = ds
+	config.SetCurrent(&config.Config{Auth: &config.AuthConfig{Tokens: tokens}, LabelPolicy: &config.LabelPolicyConfig{}})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(&config.GRPCConfig{})
+	go srv.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+		ds.Shutdown()
+		store.StoreInstance = previousStore
+		config.SetCurrent(previousConf)
+	})
+	return NewTargetGroupsClient(conn)
+}
+
+func TestTargetGroups(t *testing.T) {
+	client := setupGRPCTest(t)
+	ctx := context.Background()
+
+	if _, err := client.AddTargetToGroup(ctx, &TargetRequest{Group: "web", Target: "a:80"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AddLabelsToGroup(ctx, &LabelsRequest{Group: "web", Labels: map[string]string{"env": "prod"}}); err != nil {
+		t.Fatal(err)
+	}
+	labels, err := client.GetTargetGroupLabels(ctx, &GroupRequest{Group: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels.Labels) != 1 || labels.Labels["env"] != "prod" {
+		t.Errorf("got labels %v", labels.Labels)
+	}
+
+	list, err := client.GetTargetGroups(ctx, &Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(list.Groups))
+	}
+	tg := list.Groups[0]
+	if tg.Name != "web" || len(tg.Targets) != 1 || tg.Targets[0] != "a:80" || tg.EffectiveLabels["env"] != "prod" {
+		t.Errorf("got group %v", tg)
+	}
+
+	if _, err := client.RemoveLabelFromGroup(ctx, &LabelRequest{Group: "web", Label: "env"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RemoveTargetGroup(ctx, &GroupRequest{Group: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RemoveTargetFromGroup(ctx, &TargetRequest{Group: "web", Target: "a:80"}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v, want %s", err, codes.NotFound)
+	}
+	if _, err := client.AddTargetToGroup(ctx, &TargetRequest{Group: "web", Target: "not a target"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	client := setupGRPCTest(t, config.AuthToken{Name: "ci", Token: "secret"})
+	ctx := context.Background()
+
+	if _, err := client.AddTargetToGroup(ctx, &TargetRequest{Group: "web", Target: "a:80"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("got error %v, want %s", err, codes.Unauthenticated)
+	}
+	authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if _, err := client.AddTargetToGroup(authCtx, &TargetRequest{Group: "web", Target: "a:80"}); err != nil {
+		t.Fatal(err)
+	}
+	// The call is audited along with the change it requested
+	entries, err := audit.DefaultLog.Recent(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.Identity != "ci" || len(e.Changes) != 1 || e.Changes[0].Name != "web" || len(e.Changes[0].AddedTargets) != 1 {
+		t.Errorf("got audit entry %+v", e)
+	}
+	// Reading doesn't require a token
+	if _, err := client.GetTargetGroups(ctx, &Empty{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStoreError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{store.ErrTargetGroupNotFound, codes.NotFound},
+		{store.ErrTargetGroupExists, codes.AlreadyExists},
+		{store.ErrLabelConflict, codes.Aborted},
+		{store.ErrConcurrentModification, codes.Aborted},
+		{store.ErrTxnConditionFailed, codes.FailedPrecondition},
+		{store.ErrTemplateInUse, codes.FailedPrecondition},
+		{errors.New("disk full"), codes.Internal},
+	} {
+		err := storeError(fmt.Errorf("%w: web", tc.err))
+		if got := status.Code(err); got != tc.code {
+			t.Errorf("%v: got code %s, want %s", tc.err, got, tc.code)
+		}
+	}
+}
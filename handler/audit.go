@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"go.uber.org/zap"
+)
+
+// AuditHandler returns the most recent requests which attempted to modify the target groups,
+// the most recent first, limited to 100 entries by default or to the limit parameter.  It's only
+// served to admin tokens.
+var AuditHandler = func(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			http.Error(w, "ERROR: Parameter 'limit' must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	entries, err := audit.DefaultLog.Recent(limit)
+	if err != nil {
+		requestLogger(r).Error("Could not read audit log", zap.Error(err))
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	b, _ := json.MarshalIndent(entries, "", "    ")
+	fmt.Fprintf(w, "%s\n", b)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// groupVersion returns the version of the target group, 0 when it isn't known
+func groupVersion(tg *store.TargetGroup) uint64 {
+	if tg.Metadata == nil {
+		return 0
+	}
+	return tg.Metadata.Version
+}
+
+// setETag sets the ETag header of the response to the version of the target group, which is
+// passed back in the If-Match header to only change the group if it wasn't modified since
+func setETag(w http.ResponseWriter, tg *store.TargetGroup) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(groupVersion(tg), 10)))
+}
+
+// parseIfMatch returns the condition on the target group expressed by an If-Match header, which
+// is either the quoted version of the group returned in the ETag header, or * for any version
+func parseIfMatch(group, header string) (*store.TxnCondition, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		exists := true
+		return &store.TxnCondition{Group: group, Exists: &exists}, nil
+	}
+	if unquoted, err := strconv.Unquote(header); err == nil && !strings.HasPrefix(header, "`") {
+		if version, err := strconv.ParseUint(unquoted, 10, 64); err == nil {
+			return &store.TxnCondition{Group: group, Version: &version}, nil
+		}
+	}
+	return nil, fmt.Errorf("Header If-Match must be * or a single entity tag returned in the ETag header, like \"3\"")
+}
+
+// IfMatch makes the change of the target group named by the group or targetGroup route variable
+// conditional on the If-Match header of the request, when it's set.  The data store then only
+// applies the change if the group is at the version of the header, and the request fails with
+// 412 Precondition Failed otherwise.
+func IfMatch(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("If-Match")
+		if header == "" {
+			next(w, r)
+			return
+		}
+		vars := mux.Vars(r)
+		group, ok := vars["group"]
+		if !ok {
+			group = vars["targetGroup"]
+		}
+		cond, err := parseIfMatch(group, header)
+		if err != nil {
+			if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+				WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, err.Error())
+			} else {
+				http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+			}
+			return
+		}
+		next(w, r.WithContext(store.WithConditions(r.Context(), cond)))
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+)
+
+func TestIfMatchDoesNotMatchRecreatedGroup(t *testing.T) {
+	setupHandlerTest(t, &config.LabelPolicyConfig{})
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v2/groups/{group}", IfMatch(V2PutGroupHandler)).Methods("PUT")
+	router.HandleFunc("/api/v2/groups/{group}", IfMatch(V2DeleteGroupHandler)).Methods("DELETE")
+	do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v2/groups/web", strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("PUT", `{"targets": ["a:80"]}`, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if w := do("DELETE", "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	w = do("PUT", `{"targets": ["b:80"]}`, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	newETag := w.Header().Get("ETag")
+	if newETag == etag {
+		t.Fatalf("the recreated group has the ETag %s of the deleted one", etag)
+	}
+
+	// The ETag of the deleted group doesn't match the new one
+	if w := do("PUT", `{"targets": ["c:80"]}`, etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+	if w := do("PUT", `{"targets": ["c:80"]}`, newETag); w.Code != http.StatusOK {
+		t.Errorf("got status %d: %s", w.Code, w.Body)
+	}
+}
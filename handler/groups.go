@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// groupSummary is a target group listed by GroupsHandler.  Labels are the own labels of the
+// group, and EffectiveLabels those layered over the labels of its templates.
+type groupSummary struct {
+	Name            string            `json:"name"`
+	TargetCount     int               `json:"target_count"`
+	Labels          map[string]string `json:"labels"`
+	EffectiveLabels map[string]string `json:"effective_labels"`
+	CreatedAt       *time.Time        `json:"created_at,omitempty"`
+	ModifiedAt      *time.Time        `json:"modified_at,omitempty"`
+	Version         uint64            `json:"version"`
+}
+
+type groupSummaryList struct {
+	Groups        []*groupSummary `json:"groups"`
+	NextPageToken string          `json:"next_page_token,omitempty"`
+}
+
+// groupDetail is the target group returned by GroupHandler
+type groupDetail struct {
+	groupSummary
+	Targets []string `json:"targets"`
+}
+
+func newGroupSummary(name string, tg *store.TargetGroup, effective map[string]string) *groupSummary {
+	g := &groupSummary{Name: name, TargetCount: len(tg.Targets), Labels: tg.Labels, EffectiveLabels: effective, Version: groupVersion(tg)}
+	if g.Labels == nil {
+		g.Labels = map[string]string{}
+	}
+	if tg.Metadata != nil {
+		// The times aren't known for the groups created before they were recorded
+		if !tg.Metadata.CreatedAt.IsZero() {
+			g.CreatedAt = &tg.Metadata.CreatedAt
+		}
+		if !tg.Metadata.ModifiedAt.IsZero() {
+			g.ModifiedAt = &tg.Metadata.ModifiedAt
+		}
+	}
+	return g
+}
+
+// GroupsHandler returns the names, target counts, own and effective labels and metadata of the target groups
+// sorted by name, one page at a time.  The groups can be restricted to the names starting with
+// prefix=<prefix> and to the groups having some labels with label=<name>=<value>.
+var GroupsHandler = func(w http.ResponseWriter, r *http.Request) {
+	size, after, err := pageParams(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	qsargs := r.URL.Query()
+	query := &store.GroupQuery{
+		Prefix: qsargs.Get("prefix"),
+		Labels: map[string]string{},
+		After:  after,
+		// One more group is read to know whether there is a next page
+		Limit: size + 1,
+	}
+	for _, lvpair := range qsargs["label"] {
+		parts := strings.SplitN(lvpair, "=", 2)
+		if len(parts) != 2 {
+			msg := fmt.Sprintf("ERROR: Label '%s' must be in the form <label>=<value>", lvpair)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		query.Labels[parts[0]] = parts[1]
+	}
+
+	groups, err := store.StoreInstance.ListTargetGroups(r.Context(), query)
+	if err != nil {
+		requestLogger(r).Error("Could not list target groups", zap.Error(err))
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
+	}
+
+	res := &groupSummaryList{Groups: []*groupSummary{}}
+	if len(groups) > size {
+		res.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(groups[size-1].Name))
+		groups = groups[:size]
+	}
+	byName := make(map[string]*store.TargetGroup, len(groups))
+	for _, tg := range groups {
+		byName[tg.Name] = tg
+	}
+	effective, ok := effectiveLabels(w, r, byName)
+	if !ok {
+		return
+	}
+	for _, tg := range groups {
+		res.Groups = append(res.Groups, newGroupSummary(tg.Name, tg, effective[tg.Name]))
+	}
+	b, _ := json.MarshalIndent(res, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+// GroupHandler returns the targets, own and effective labels and metadata of a target group
+var GroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	tg, err := store.StoreInstance.GetTargetGroup(r.Context(), name)
+	if err != nil {
+		if !errors.Is(err, store.ErrTargetGroupNotFound) {
+			requestLogger(r).Error("Could not get target group", zap.String("target_group", name), zap.Error(err))
+		}
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
+	}
+
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{name: tg})
+	if !ok {
+		return
+	}
+	res := &groupDetail{groupSummary: *newGroupSummary(name, tg, effective[name]), Targets: append([]string{}, tg.Targets...)}
+	sort.Strings(res.Targets)
+	b, _ := json.MarshalIndent(res, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	setETag(w, tg)
+	fmt.Fprintf(w, "%s\n", b)
+}
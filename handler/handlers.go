@@ -2,17 +2,22 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/accesslog"
+	"github.com/hartfordfive/prom-http-sd-server/audit"
 	"github.com/hartfordfive/prom-http-sd-server/config"
 	"github.com/hartfordfive/prom-http-sd-server/lib"
 	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/relabel"
 	"github.com/hartfordfive/prom-http-sd-server/store"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
 )
 
 var (
@@ -42,6 +47,26 @@ var (
 	})
 )
 
+// storeErrorStatus returns the HTTP status code corresponding to an error returned by the data store
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrTargetGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrTargetGroupExists), errors.Is(err, store.ErrLabelConflict),
+		errors.Is(err, store.ErrConcurrentModification), errors.Is(err, store.ErrTemplateInUse):
+		return http.StatusConflict
+	case errors.Is(err, store.ErrTxnConditionFailed):
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// requestLogger returns the logger annotated with the ID of the request, so that the entries
+// logged by the handlers can be correlated with the access log
+func requestLogger(r *http.Request) *zap.Logger {
+	return logger.Logger.With(zap.String("request_id", accesslog.RequestIDFromContext(r.Context())))
+}
+
 var HealthHandler = func(w http.ResponseWriter, req *http.Request) {
 	/*
 		TO COMPLETE:
@@ -55,20 +80,27 @@ var AddTargetHandler = func(w http.ResponseWriter, r *http.Request) {
 	target := vars["target"]
 	targetGroup := vars["targetGroup"]
 
+	audit.Record(r.Context(), &store.GroupDiff{Name: targetGroup, AddedTargets: []string{target}})
 	if !lib.IsValidTargetName(target) {
-		http.Error(w, "ERROR: Target name is invalid", http.StatusInternalServerError)
+		http.Error(w, "ERROR: Target name is invalid", http.StatusBadRequest)
+		return
+	}
+	r, ok := checkLabels(w, r, targetGroup, nil, nil, true)
+	if !ok {
+		return
 	}
 
 	dataStore := store.StoreInstance
 
-	logger.Logger.Debug(fmt.Sprintf("Adding target %s to target list %s\n", target, targetGroup))
-	if err := dataStore.AddTargetToGroup(targetGroup, target); err != nil {
-		logger.Logger.Debug(fmt.Sprintf("Couldn't add target %s to target list %s\n", target, targetGroup))
-		logger.Logger.Error(err.Error())
+	log := requestLogger(r).With(zap.String("target_group", targetGroup), zap.String("target", target))
+	log.Debug("Adding target to target group")
+	if err := dataStore.AddTargetToGroup(r.Context(), targetGroup, target); err != nil {
+		log.Error("Could not add target to target group", zap.Error(err))
 		metricTargetGroupUpdatesFailed.Inc()
-	} else {
-		metricTargetGroupUpdates.Inc()
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
 	}
+	metricTargetGroupUpdates.Inc()
 	fmt.Fprintf(w, "OK")
 }
 
@@ -76,30 +108,36 @@ var RemoveTargetHandler = func(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	target := vars["target"]
 	targetGroup := vars["targetGroup"]
+	audit.Record(r.Context(), &store.GroupDiff{Name: targetGroup, RemovedTargets: []string{target}})
 
-	logger.Logger.Debug(fmt.Sprintf("Removing target %s from target list %s\n", target, targetGroup))
+	log := requestLogger(r).With(zap.String("target_group", targetGroup), zap.String("target", target))
+	log.Debug("Removing target from target group")
 	dataStore := store.StoreInstance
-	if err := dataStore.RemoveTargetFromGroup(targetGroup, target); err != nil {
-		logger.Logger.Debug(fmt.Sprintf("Couldn't remove target %s from target list %s\n", target, targetGroup))
-		logger.Logger.Error(err.Error())
+	if err := dataStore.RemoveTargetFromGroup(r.Context(), targetGroup, target); err != nil {
+		log.Error("Could not remove target from target group", zap.Error(err))
 		metricTargetRemoveFailed.Inc()
-	} else {
-		metricTargetRemove.Inc()
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
 	}
+	metricTargetRemove.Inc()
 	fmt.Fprintf(w, "OK")
 }
 
 var RemoveTargetGroupHandler = func(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	targetGroup := vars["targetGroup"]
+	audit.Record(r.Context(), &store.GroupDiff{Name: targetGroup, Deleted: true})
 
-	logger.Logger.Debug(fmt.Sprintf("Removing target group %s\n", targetGroup))
+	log := requestLogger(r).With(zap.String("target_group", targetGroup))
+	log.Debug("Removing target group")
 	dataStore := store.StoreInstance
-	if err := dataStore.RemoveTargetGroup(targetGroup); err != nil {
+	if err := dataStore.RemoveTargetGroup(r.Context(), targetGroup); err != nil {
+		log.Error("Could not remove target group", zap.Error(err))
 		metricTargetRemoveFailed.Inc()
-	} else {
-		metricTargetRemove.Inc()
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
 	}
+	metricTargetRemove.Inc()
 	fmt.Fprintf(w, "OK")
 }
 
@@ -112,20 +150,35 @@ var AddTargetGroupLabelsHandler = func(w http.ResponseWriter, r *http.Request) {
 	dat := qsargs["labels"]
 	labels := map[string]string{}
 	for _, lvpair := range dat {
-		parts := strings.Split(lvpair, "=")
+		parts := strings.SplitN(lvpair, "=", 2)
+		if len(parts) != 2 {
+			msg := fmt.Sprintf("ERROR: Label '%s' must be in the form <label>=<value>", lvpair)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
 		if !lib.IsValidLabelName(parts[0]) {
 			msg := fmt.Sprintf("ERROR: Label name '%s' is invalid", parts[0])
-			http.Error(w, msg, http.StatusInternalServerError)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
 		}
 		labels[parts[0]] = parts[1]
 	}
+	audit.Record(r.Context(), &store.GroupDiff{Name: targetGroup, SetLabels: labels})
+	r, ok := checkLabels(w, r, targetGroup, labels, nil, true)
+	if !ok {
+		return
+	}
 
+	log := requestLogger(r).With(zap.String("target_group", targetGroup))
+	log.Debug("Adding labels to target group", zap.Any("labels", labels))
 	dataStore := store.StoreInstance
-	if err := dataStore.AddLabelsToGroup(targetGroup, labels); err != nil {
+	if err := dataStore.AddLabelsToGroup(r.Context(), targetGroup, labels); err != nil {
+		log.Error("Could not add labels to target group", zap.Any("labels", labels), zap.Error(err))
 		metricTargetGroupLabelsUpdatesFailed.Inc()
-	} else {
-		metricTargetGroupLabelsUpdates.Inc()
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
 	}
+	metricTargetGroupLabelsUpdates.Inc()
 	fmt.Fprintf(w, "OK")
 }
 
@@ -133,16 +186,24 @@ var GetTargetGroupLabelsHandler = func(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	targetGroup := vars["targetGroup"]
 
-	dataStore := store.StoreInstance
-	dat, err := dataStore.GetTargetGroupLabels(targetGroup)
+	// The group is read along with its version, returned in the ETag header like the v2 routes
+	tg, err := store.StoreInstance.GetTargetGroup(r.Context(), targetGroup)
 
 	if err != nil {
+		if !errors.Is(err, store.ErrTargetGroupNotFound) {
+			requestLogger(r).Error("Could not get target group labels", zap.String("target_group", targetGroup), zap.Error(err))
+		}
 		fmt.Fprint(w, "[]\n")
 		return
 	}
 
-	b, _ := json.MarshalIndent(dat, "", "    ")
+	labels := tg.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	b, _ := json.MarshalIndent(labels, "", "    ")
 	res := string(b)
+	setETag(w, tg)
 	fmt.Fprintf(w, "%s\n", res)
 }
 
@@ -150,22 +211,58 @@ var RemoveTargetGroupLabelHandler = func(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	targetGroup := vars["targetGroup"]
 	label := vars["label"]
+	audit.Record(r.Context(), &store.GroupDiff{Name: targetGroup, RemovedLabels: []string{label}})
 
+	r, ok := checkLabels(w, r, targetGroup, nil, []string{label}, false)
+	if !ok {
+		return
+	}
+
+	log := requestLogger(r).With(zap.String("target_group", targetGroup), zap.String("label", label))
+	log.Debug("Removing label from target group")
 	dataStore := store.StoreInstance
-	if err := dataStore.RemoveLabelFromGroup(targetGroup, label); err != nil {
+	if err := dataStore.RemoveLabelFromGroup(r.Context(), targetGroup, label); err != nil {
+		log.Error("Could not remove label from target group", zap.Error(err))
 		metricTargetGroupLabelsUpdatesFailed.Inc()
-		http.Error(w, "ERROR", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
 		return
 	}
 	metricTargetGroupLabelsUpdates.Inc()
 	fmt.Fprintf(w, "OK")
 }
 
+// ShowTargetsHandler returns the target groups to Prometheus, relabeled by the relabel configs of
+// the HTTP SD endpoint when one is given in the path
 var ShowTargetsHandler = func(w http.ResponseWriter, req *http.Request) {
+	name, ok := mux.Vars(req)["endpoint"]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		dataStore := store.StoreInstance
+		res, err := dataStore.Serialize(req.Context(), false)
+		if err != nil {
+			requestLogger(req).Error("Could not serialize target groups", zap.Error(err))
+			fmt.Fprint(w, "[]\n")
+			return
+		}
+		fmt.Fprintf(w, "%s\n", res)
+		return
+	}
+
+	endpoint := config.Current().HTTPSD.Endpoint(name)
+	if endpoint == nil {
+		http.Error(w, fmt.Sprintf("ERROR: Unknown HTTP SD endpoint %s", name), http.StatusNotFound)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	dataStore := store.StoreInstance
-	res, err := dataStore.Serialize(false)
+	groups, err := store.StoreInstance.GetTargetGroups(req.Context())
+	if err != nil {
+		requestLogger(req).Error("Could not get target groups", zap.String("endpoint", name), zap.Error(err))
+		fmt.Fprint(w, "[]\n")
+		return
+	}
+	res, err := store.MarshalTargetGroups(relabel.TargetGroups(store.ResolveTemplates(groups), endpoint.RelabelConfigs), "json")
 	if err != nil {
+		requestLogger(req).Error("Could not serialize target groups", zap.String("endpoint", name), zap.Error(err))
 		fmt.Fprint(w, "[]\n")
 		return
 	}
@@ -175,14 +272,12 @@ var ShowTargetsHandler = func(w http.ResponseWriter, req *http.Request) {
 var ShowDebugTargetsHandler = func(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	dataStore := store.StoreInstance
-	res, err := dataStore.Serialize(true)
+	res, err := dataStore.Serialize(req.Context(), true)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println(res)
-
 	modifiedData := map[string]interface{}{}
 	err = json.Unmarshal([]byte(res), &modifiedData)
 	if err != nil {
@@ -190,8 +285,6 @@ var ShowDebugTargetsHandler = func(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	fmt.Println(modifiedData)
-
 	response, err := json.MarshalIndent(modifiedData, " ", " ")
 	fmt.Fprintf(w, "%s\n", string(response))
 }
@@ -202,7 +295,7 @@ var ShowDebugConfigHandler = func(w http.ResponseWriter, req *http.Request) {
 	// modifiedData["config"] = conf
 	// response, err := json.MarshalIndent(modifiedData, " ", " ")
 
-	printCnf, err := config.GlobalConfig.Serialize()
+	printCnf, err := config.Current().Serialize()
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
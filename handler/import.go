@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"github.com/hartfordfive/prom-http-sd-server/importer"
+	"github.com/hartfordfive/prom-http-sd-server/policy"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// maxImportSize is the largest request body accepted by the import endpoints
+const maxImportSize = 16 << 20
+
+// readImportBody returns the body of an import request, writing the error response when it
+// can't be read
+func readImportBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Could not read request body: %s", err), http.StatusBadRequest)
+		return nil, false
+	}
+	audit.RecordBody(r.Context(), b)
+	return b, true
+}
+
+// boolParam returns the value of a boolean query string parameter, false when it isn't set
+func boolParam(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("Parameter '%s' must be true or false", name)
+	}
+	return b, nil
+}
+
+// importTargetGroups applies the imported target groups to the data store, or only shows the
+// changes with dry_run=true, and writes the changes and the warnings as JSON.  With prune=true,
+// the targets and labels of the imported groups which aren't imported are removed.  Nothing is
+// applied when any target group is invalid, or when an imported group changes during the import.
+func importTargetGroups(w http.ResponseWriter, r *http.Request, groups map[string]*store.TargetGroup, warnings []string) {
+	dryRun, err := boolParam(r, "dry_run")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	prune, err := boolParam(r, "prune")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := importer.Validate(groups); err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// The policy is checked against the same state the import is conditioned on
+	current, err := importer.Current(r.Context(), store.StoreInstance, groups)
+	if err != nil {
+		requestLogger(r).Error("Could not get target groups", zap.Error(err))
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
+	}
+	diffs := importer.Plan(current, groups, prune)
+	for _, d := range diffs {
+		audit.RecordGroups(r.Context(), d.Name)
+	}
+	changes := []*policy.Change{}
+	for _, d := range diffs {
+		c := &policy.Change{
+			Group:   d.Name,
+			Created: current[d.Name] == nil,
+			Set:     d.SetLabels,
+			Removed: d.RemovedLabels,
+		}
+		// The templates of the groups are left untouched
+		before := map[string]*store.TargetGroup{}
+		if tg := current[d.Name]; tg != nil {
+			before[d.Name] = tg
+		}
+		after := map[string]*store.TargetGroup{d.Name: policy.Changed(d.Name, current[d.Name], d.SetLabels, d.RemovedLabels)}
+		if err := c.SetLabels(r.Context(), store.StoreInstance, before, after); err != nil {
+			requestLogger(r).Error("Could not get templates of target groups", zap.Error(err))
+			http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+			return
+		}
+		changes = append(changes, c)
+	}
+	r, ok := checkLabelChanges(w, r, changes...)
+	if !ok {
+		return
+	}
+
+	res, err := importer.Import(r.Context(), store.StoreInstance, current, diffs, prune, dryRun)
+	if errors.Is(err, store.ErrTxnConditionFailed) {
+		err = fmt.Errorf("Target groups were modified while importing, nothing was applied: %w", err)
+	}
+	if err != nil {
+		requestLogger(r).Error("Could not import target groups", zap.Error(err))
+		metricTargetGroupUpdatesFailed.Inc()
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
+	}
+	res.Warnings = warnings
+	if !dryRun && len(res.Changes) > 0 {
+		metricTargetGroupUpdates.Add(float64(len(res.Changes)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	b, _ := json.MarshalIndent(res, "", "    ")
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+// ImportFileSDHandler imports the target groups of a Prometheus file_sd file, in JSON or, with
+// format=yaml, in YAML.  The groups are named after the name parameter.
+var ImportFileSDHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "ERROR: Parameter 'name' is required", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	b, ok := readImportBody(w, r)
+	if !ok {
+		return
+	}
+	groups, err := importer.FileSD(b, format, name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	importTargetGroups(w, r, groups, nil)
+}
+
+// ImportStaticConfigsHandler imports the target groups of the static_configs blocks of a
+// prometheus.yml configuration file
+var ImportStaticConfigsHandler = func(w http.ResponseWriter, r *http.Request) {
+	b, ok := readImportBody(w, r)
+	if !ok {
+		return
+	}
+	groups, err := importer.StaticConfigs(b)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	importTargetGroups(w, r, groups, nil)
+}
+
+// ImportCSVHandler imports target groups from CSV rows of group,target[,label=value...]
+var ImportCSVHandler = func(w http.ResponseWriter, r *http.Request) {
+	b, ok := readImportBody(w, r)
+	if !ok {
+		return
+	}
+	groups, err := importer.CSV(strings.NewReader(string(b)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Could not parse CSV: %s", err), http.StatusBadRequest)
+		return
+	}
+	importTargetGroups(w, r, groups, nil)
+}
+
+// ImportAnsibleHandler imports the host groups of an Ansible inventory, in INI or, with
+// format=yaml, in YAML.  The port parameter is appended to the address of every target.
+var ImportAnsibleHandler = func(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ini"
+	}
+	port := 0
+	if v := r.URL.Query().Get("port"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 1 || p > 65535 {
+			http.Error(w, "ERROR: Parameter 'port' must be between 1 and 65535", http.StatusBadRequest)
+			return
+		}
+		port = p
+	}
+
+	b, ok := readImportBody(w, r)
+	if !ok {
+		return
+	}
+	groups, warnings, err := importer.AnsibleInventory(b, format, port)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	importTargetGroups(w, r, groups, warnings)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/hartfordfive/prom-http-sd-server/version"
+)
+
+// openAPISpec is the OpenAPI 3 document describing the API.  Its info.version is replaced by
+// the version of the binary when served.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPIHandler returns the OpenAPI 3 document describing the API
+var OpenAPIHandler = func(w http.ResponseWriter, r *http.Request) {
+	if version.Version == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+		return
+	}
+
+	// Only the info object is decoded, so that the rest of the document keeps its order
+	spec := map[string]json.RawMessage{}
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		WriteError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if err := json.Unmarshal(spec["info"], &info); err != nil {
+		WriteError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	info["version"] = version.Version
+	spec["info"], _ = json.Marshal(info)
+	writeJSON(w, http.StatusOK, spec)
+}
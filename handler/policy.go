@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/policy"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// checkLabels checks the labels set and removed by the request on the target group against the
+// label policy, writing the error response and returning false when they violate it.  creates
+// tells whether the request creates the group if it doesn't exist.  The change must be applied
+// with the returned request, conditioned on the state of the group the policy was checked against.
+func checkLabels(w http.ResponseWriter, r *http.Request, group string, set map[string]string, removed []string, creates bool) (*http.Request, bool) {
+	c, err := policy.NewChange(r.Context(), store.StoreInstance, group, set, removed, creates)
+	if err != nil {
+		if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+			writeStoreError(w, r, err, "Could not get target group", zap.String("target_group", group))
+		} else {
+			requestLogger(r).Error("Could not get target group", zap.String("target_group", group), zap.Error(err))
+			http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		}
+		return r, false
+	}
+	return checkLabelChanges(w, r, c)
+}
+
+// checkCopyLabels checks the labels of the target group created as newName with the labels and
+// templates of the group src, writing the error response and returning false when they violate
+// the label policy.  The copy must be made with the returned request, conditioned on src being
+// unchanged.
+func checkCopyLabels(w http.ResponseWriter, r *http.Request, name, newName string, src *store.TargetGroup) (*http.Request, bool) {
+	c := &policy.Change{Group: newName, Created: true, Set: src.Labels,
+		Conditions: []*store.TxnCondition{store.UnchangedCondition(name, src)}}
+	if err := c.SetLabels(r.Context(), store.StoreInstance, nil, map[string]*store.TargetGroup{newName: src}); err != nil {
+		writeStoreError(w, r, err, "Could not get templates of target group", zap.String("target_group", name))
+		return r, false
+	}
+	return checkLabelChanges(w, r, c)
+}
+
+// checkLabelChanges writes the error response and returns false when the changes violate the
+// label policy.  Otherwise it returns the request whose context holds the conditions of the
+// changes, which must be applied with it.
+func checkLabelChanges(w http.ResponseWriter, r *http.Request, changes ...*policy.Change) (*http.Request, bool) {
+	err := policy.Check(r.Context(), changes...)
+	if err == nil {
+		if conditions := policy.Conditions(changes...); len(conditions) > 0 {
+			r = r.WithContext(store.WithConditions(r.Context(), conditions...))
+		}
+		return r, true
+	}
+	pe := &policy.Error{}
+	errors.As(err, &pe)
+	status, code := http.StatusBadRequest, ErrCodeInvalidArgument
+	if pe.PermissionDenied {
+		status, code = http.StatusForbidden, ErrCodePermissionDenied
+	}
+	if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+		WriteError(w, status, code, "Label policy violation", pe.Violations...)
+	} else {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), status)
+	}
+	return r, false
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// setupPolicyTest sets a BoltDB data store and a configuration requiring the env label
+func setupPolicyTest(t *testing.T) {
+	t.Helper()
+	setupHandlerTest(t, &config.LabelPolicyConfig{Required: []string{"env"}})
+}
+
+// setupHandlerTest sets a BoltDB data store and a configuration with the label policy
+func setupHandlerTest(t *testing.T, labelPolicy *config.LabelPolicyConfig) {
+	t.Helper()
+	logger.Logger = zap.NewNop()
+	ds, err := store.NewBoltDBDataStore(filepath.Join(t.TempDir(), "store.db"), make(chan bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, previousConf := store.StoreInstance, config.Current()
+	store.StoreInstance = ds
+	config.SetCurrent(&config.Config{Auth: &config.AuthConfig{}, LabelPolicy: labelPolicy})
+	t.Cleanup(func() {
+		ds.Shutdown()
+		store.StoreInstance = previousStore
+		config.SetCurrent(previousConf)
+	})
+}
+
+func TestCheckLabelsConditionsTheChange(t *testing.T) {
+	setupPolicyTest(t)
+	ctx := context.Background()
+	if err := store.StoreInstance.AddLabelsToGroup(ctx, "web", map[string]string{"env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding a target to an existing group is allowed, as long as the group isn't deleted meanwhile,
+	// since the target would then create it without the required labels
+	r, ok := checkLabels(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/target/web/a:80", nil), "web", nil, nil, true)
+	if !ok {
+		t.Fatal("the change was rejected")
+	}
+	if err := store.StoreInstance.RemoveTargetGroup(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreInstance.AddTargetToGroup(r.Context(), "web", "a:80"); !errors.Is(err, store.ErrTxnConditionFailed) {
+		t.Fatalf("got error %v, want %v", err, store.ErrTxnConditionFailed)
+	}
+	if _, err := store.StoreInstance.GetTargetGroup(ctx, "web"); !errors.Is(err, store.ErrTargetGroupNotFound) {
+		t.Errorf("web was created: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	if _, ok := checkLabels(w, httptest.NewRequest("POST", "/api/target/web/a:80", nil), "web", nil, nil, true); ok {
+		t.Fatal("the creation of web without labels was allowed")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d", w.Code)
+	}
+}
+
+func TestV2RenameGroupChecksLabelPolicy(t *testing.T) {
+	setupPolicyTest(t)
+	ctx := context.Background()
+	if err := store.StoreInstance.AddTargetToGroup(ctx, "legacy", "a:80"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreInstance.AddLabelsToGroup(ctx, "web", map[string]string{"env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v2/groups/{group}/rename", V2RenameGroupHandler).Methods("POST")
+
+	tests := []struct {
+		group, body string
+		want        int
+	}{
+		{"legacy", `{"name": "legacy-2"}`, http.StatusBadRequest},
+		{"web", `{"name": "web-2"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/groups/"+tt.group+"/rename", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("renaming %s: got status %d, want %d: %s", tt.group, w.Code, tt.want, w.Body)
+		}
+	}
+	if _, err := store.StoreInstance.GetTargetGroup(ctx, "legacy"); err != nil {
+		t.Errorf("legacy was renamed: %v", err)
+	}
+}
+
+func TestRequiredLabelsApplyToEffectiveLabels(t *testing.T) {
+	setupPolicyTest(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/target/{targetGroup}/{target}", AddTargetHandler).Methods("POST")
+	router.HandleFunc("/api/labels/update/{targetGroup}/{label}", RemoveTargetGroupLabelHandler).Methods("DELETE")
+	router.HandleFunc("/api/v2/groups/{group}", V2PutGroupHandler).Methods("PUT")
+	router.HandleFunc("/api/txn", TxnHandler).Methods("POST")
+
+	tests := []struct {
+		name, method, path, body string
+		want                     int
+		error                    string
+	}{
+		{
+			name: "group created with the labels of its template", method: "POST", path: "/api/txn",
+			body: `{"operations": [{"op": "set_label", "group": "base", "label": "env", "value": "prod"},
+				{"op": "add_target", "group": "web", "target": "a:80"}, {"op": "set_templates", "group": "web", "templates": ["base"]},
+				{"op": "set_label", "group": "web", "label": "env", "value": "staging"}]}`,
+			want: http.StatusOK,
+		},
+		{
+			name: "target added to a new group", method: "POST", path: "/api/target/db/b:5432",
+			want: http.StatusBadRequest, error: "create it with its labels first",
+		},
+		{
+			name: "own label removed while inherited", method: "DELETE", path: "/api/labels/update/web/env",
+			want: http.StatusOK,
+		},
+		{
+			name: "inherited label removed with the template", method: "PUT", path: "/api/v2/groups/web",
+			body: `{"targets": ["a:80"]}`,
+			want: http.StatusBadRequest, error: "Label env of target group web is required and can't be removed",
+		},
+		{
+			name: "label removed from the template", method: "DELETE", path: "/api/labels/update/base/env",
+			want: http.StatusBadRequest, error: "Label env of target group base is required and can't be removed",
+		},
+		{
+			name: "template replaced", method: "POST", path: "/api/txn",
+			body: `{"operations": [{"op": "set_label", "group": "base-2", "label": "env", "value": "dev"},
+				{"op": "set_templates", "group": "web", "templates": ["base-2"]}]}`,
+			want: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.error) {
+			t.Errorf("%s: got status %d, want %d with %q: %s", tt.name, w.Code, tt.want, tt.error, w.Body)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// checkTemplates checks the templates set by the transaction against the target groups as they
+// would be after it, writing the error response and returning false when a template doesn't
+// exist or makes a group inherit from itself
+func checkTemplates(w http.ResponseWriter, r *http.Request, txn *store.Txn) bool {
+	setsTemplates := false
+	for _, op := range txn.Operations {
+		setsTemplates = setsTemplates || (op.Op == store.TxnSetTemplates && len(op.Templates) > 0)
+	}
+	if !setsTemplates {
+		return true
+	}
+
+	current, err := store.StoreInstance.GetTargetGroups(r.Context())
+	if err != nil {
+		if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+			writeStoreError(w, r, err, "Could not get target groups")
+		} else {
+			requestLogger(r).Error("Could not get target groups", zap.Error(err))
+			http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		}
+		return false
+	}
+	groups, err := txn.Preview(current)
+	if err != nil {
+		// The transaction fails with the same error when it's applied
+		return true
+	}
+	problems := []string{}
+	for _, op := range txn.Operations {
+		if op.Op == store.TxnSetTemplates {
+			if err := store.CheckTemplates(groups, op.Group, op.Templates); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "Invalid templates", problems...)
+	} else {
+		http.Error(w, fmt.Sprintf("ERROR: %s", strings.Join(problems, ", ")), http.StatusBadRequest)
+	}
+	return false
+}
+
+// effectiveLabels returns the effective labels of the target groups keyed by name, reading their
+// templates from the data store, and writes the error response and returns false when they
+// can't be read.  The groups which are nil are left out.
+func effectiveLabels(w http.ResponseWriter, r *http.Request, groups map[string]*store.TargetGroup) (map[string]map[string]string, bool) {
+	named := []*store.TargetGroup{}
+	for name, tg := range groups {
+		if tg != nil {
+			c := *tg
+			c.Name = name
+			named = append(named, &c)
+		}
+	}
+	all, err := store.LoadTemplates(r.Context(), store.StoreInstance, named...)
+	if err != nil {
+		if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+			writeStoreError(w, r, err, "Could not get templates of target groups")
+		} else {
+			requestLogger(r).Error("Could not get templates of target groups", zap.Error(err))
+			http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		}
+		return nil, false
+	}
+	res := make(map[string]map[string]string, len(named))
+	for _, tg := range named {
+		labels := tg.Labels
+		if len(tg.Templates) > 0 {
+			labels, _ = store.EffectiveLabels(all, tg.Name)
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		res[tg.Name] = labels
+	}
+	return res, true
+}
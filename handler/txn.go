@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/policy"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// txnResult is the response of TxnHandler, with the target groups changed by the transaction,
+// null for the deleted groups
+type txnResult struct {
+	Groups map[string]*groupSummary `json:"groups"`
+}
+
+// TxnHandler applies the operations of the store.Txn sent as the request body in a single
+// change, provided every condition holds.  Nothing is applied when a condition doesn't hold,
+// an operation fails or the body is invalid.
+var TxnHandler = func(w http.ResponseWriter, r *http.Request) {
+	txn := &store.Txn{}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxV2BodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(txn); err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: Invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	recordBody(r, txn)
+	if err := txn.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusBadRequest)
+		return
+	}
+	targets, labels := []string{}, []string{}
+	for _, op := range txn.Operations {
+		audit.RecordGroups(r.Context(), op.Group)
+		switch op.Op {
+		case store.TxnAddTarget:
+			targets = append(targets, op.Target)
+		case store.TxnSetLabel:
+			labels = append(labels, op.Label)
+		}
+	}
+	if problems := append(validateTargets(targets), validateLabelNames(labels)...); len(problems) > 0 {
+		http.Error(w, fmt.Sprintf("ERROR: %s", strings.Join(problems, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	if !checkTemplates(w, r, txn) {
+		return
+	}
+	changes, err := txnLabelChanges(r, txn)
+	if err != nil {
+		requestLogger(r).Error("Could not get target groups", zap.Strings("target_groups", txn.Groups()), zap.Error(err))
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), storeErrorStatus(err))
+		return
+	}
+	r, ok := checkLabelChanges(w, r, changes...)
+	if !ok {
+		return
+	}
+
+	groups, err := store.StoreInstance.ApplyTxn(r.Context(), txn)
+	if err != nil {
+		status := storeErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			requestLogger(r).Error("Could not apply transaction", zap.Strings("target_groups", txn.Groups()), zap.Error(err))
+		}
+		metricTargetGroupUpdatesFailed.Inc()
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), status)
+		return
+	}
+	metricTargetGroupUpdates.Inc()
+
+	res := &txnResult{Groups: map[string]*groupSummary{}}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	effective, ok := effectiveLabels(w, r, groups)
+	if !ok {
+		return
+	}
+	for _, name := range names {
+		res.Groups[name] = nil
+		if tg := groups[name]; tg != nil {
+			res.Groups[name] = newGroupSummary(name, tg, effective[name])
+		}
+	}
+	b, _ := json.MarshalIndent(res, "", "    ")
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+// txnLabelChanges returns the changes of the labels of the target groups made by the
+// transaction, to be checked against the label policy.  When the policy requires labels, the
+// effective labels of the groups are those they have after the whole transaction, which may
+// change their templates.
+func txnLabelChanges(r *http.Request, txn *store.Txn) ([]*policy.Change, error) {
+	set := map[string]map[string]string{}
+	removed := map[string]map[string]bool{}
+	creates := map[string]bool{}
+	for _, op := range txn.Operations {
+		if set[op.Group] == nil {
+			set[op.Group], removed[op.Group] = map[string]string{}, map[string]bool{}
+		}
+		switch op.Op {
+		case store.TxnAddTarget, store.TxnSetTemplates:
+			creates[op.Group] = true
+		case store.TxnSetLabel:
+			creates[op.Group] = true
+			set[op.Group][op.Label] = op.Value
+			delete(removed[op.Group], op.Label)
+		case store.TxnDeleteLabel:
+			delete(set[op.Group], op.Label)
+			removed[op.Group][op.Label] = true
+		}
+	}
+
+	required := len(config.Current().LabelPolicy.Required) > 0
+	current, preview := map[string]*store.TargetGroup{}, map[string]*store.TargetGroup{}
+	if required {
+		for _, name := range txn.Groups() {
+			tg, err := store.StoreInstance.GetTargetGroup(r.Context(), name)
+			if errors.Is(err, store.ErrTargetGroupNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			current[name] = tg
+		}
+		var err error
+		if preview, err = txn.Preview(current); err != nil {
+			// The transaction fails with the same error when it's applied, the other rules of the
+			// policy are still checked
+			required = false
+		}
+	}
+
+	changes := []*policy.Change{}
+	for _, name := range txn.Groups() {
+		if len(set[name]) == 0 && len(removed[name]) == 0 && !creates[name] {
+			continue
+		}
+		labels := []string{}
+		for k := range removed[name] {
+			labels = append(labels, k)
+		}
+		sort.Strings(labels)
+		c := &policy.Change{Group: name, Set: set[name], Removed: labels}
+		if required {
+			c.Created = current[name] == nil && preview[name] != nil
+			c.Conditions = []*store.TxnCondition{store.UnchangedCondition(name, current[name])}
+			if err := c.SetLabels(r.Context(), store.StoreInstance, current, preview); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
@@ -0,0 +1,727 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/policy"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// APIv2Prefix is the path prefix of the v2 API, whose errors are returned as JSON error objects
+const APIv2Prefix = "/api/v2/"
+
+// Codes of the v2 API error objects
+const (
+	ErrCodeInvalidArgument    = "invalid_argument"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeAlreadyExists      = "already_exists"
+	ErrCodeConflict           = "conflict"
+	ErrCodeFailedPrecondition = "failed_precondition"
+	ErrCodeMethodNotAllowed   = "method_not_allowed"
+	ErrCodeUnauthenticated    = "unauthenticated"
+	ErrCodePermissionDenied   = "permission_denied"
+	ErrCodeInternal           = "internal"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	maxV2BodySize   = 1 << 20
+)
+
+// ErrorObject is the body of every error response of the v2 API
+type ErrorObject struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error of the v2 API.  Code is one of the ErrCode constants and
+// Details lists the individual problems of an invalid request.
+type ErrorDetail struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+// v2Group is the representation of a target group in the v2 API.  EffectiveLabels and Version are
+// only returned, the If-Match header makes a change conditional on the version of the group.
+type v2Group struct {
+	Name            string            `json:"name"`
+	Targets         []string          `json:"targets"`
+	Labels          map[string]string `json:"labels"`
+	EffectiveLabels map[string]string `json:"effective_labels"`
+	Templates       []string          `json:"templates"`
+	Version         uint64            `json:"version"`
+}
+
+type v2GroupList struct {
+	Groups        []*v2Group `json:"groups"`
+	NextPageToken string     `json:"next_page_token,omitempty"`
+}
+
+type v2Targets struct {
+	Targets []string `json:"targets"`
+}
+
+type v2Labels struct {
+	Labels          map[string]string `json:"labels"`
+	EffectiveLabels map[string]string `json:"effective_labels"`
+}
+
+// v2GroupName is the body of the rename and copy operations
+type v2GroupName struct {
+	Name string `json:"name"`
+}
+
+// v2Merge is the body of the merge operation.  LabelConflict is one of store.LabelConflictPolicies,
+// fail by default.
+type v2Merge struct {
+	Into          string `json:"into"`
+	LabelConflict string `json:"label_conflict"`
+	RemoveSource  bool   `json:"remove_source"`
+}
+
+// v2LabelsPatch sets the labels with a value and removes those set to null
+type v2LabelsPatch struct {
+	Labels map[string]*string `json:"labels"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	b, _ := json.MarshalIndent(v, "", "    ")
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+// WriteError writes a v2 API error object
+func WriteError(w http.ResponseWriter, status int, code, message string, details ...string) {
+	writeJSON(w, status, &ErrorObject{Error: ErrorDetail{Code: code, Message: message, Details: details}})
+}
+
+// writeStoreError logs the error returned by the data store and writes the matching error object
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error, msg string, fields ...zap.Field) {
+	switch {
+	case errors.Is(err, store.ErrTargetGroupNotFound):
+		WriteError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	case errors.Is(err, store.ErrTargetGroupExists):
+		WriteError(w, http.StatusConflict, ErrCodeAlreadyExists, err.Error())
+		return
+	case errors.Is(err, store.ErrLabelConflict), errors.Is(err, store.ErrConcurrentModification),
+		errors.Is(err, store.ErrTemplateInUse):
+		WriteError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	case errors.Is(err, store.ErrTxnConditionFailed):
+		WriteError(w, http.StatusPreconditionFailed, ErrCodeFailedPrecondition, err.Error())
+		return
+	}
+	requestLogger(r).Error(msg, append(fields, zap.Error(err))...)
+	WriteError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("%s: %s", msg, err))
+}
+
+// decodeBody decodes the JSON body of the request into v, writing the error response when it's
+// invalid
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxV2BodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, fmt.Sprintf("Invalid request body: %s", err))
+		return false
+	}
+	return true
+}
+
+// recordBody adds the decoded body of the request to its audit log entry, along with the target
+// groups it modifies
+func recordBody(r *http.Request, body interface{}, groups ...string) {
+	b, _ := json.Marshal(body)
+	audit.RecordBody(r.Context(), b)
+	audit.RecordGroups(r.Context(), groups...)
+}
+
+// validateTargets returns the problems with the target names
+func validateTargets(targets []string) []string {
+	problems := []string{}
+	for _, t := range targets {
+		if !lib.IsValidTargetName(t) {
+			problems = append(problems, fmt.Sprintf("Target '%s' is invalid", t))
+		}
+	}
+	return problems
+}
+
+// validateLabelNames returns the problems with the label names
+func validateLabelNames(labels []string) []string {
+	problems := []string{}
+	for _, k := range labels {
+		if !lib.IsValidLabelName(k) {
+			problems = append(problems, fmt.Sprintf("Label name '%s' is invalid", k))
+		}
+	}
+	return problems
+}
+
+// getGroup returns the target group, writing the error response when it can't be found
+func getGroup(w http.ResponseWriter, r *http.Request, name string) (*store.TargetGroup, bool) {
+	tg, err := store.StoreInstance.GetTargetGroup(r.Context(), name)
+	if errors.Is(err, store.ErrTargetGroupNotFound) {
+		WriteError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Target group %s not found", name))
+		return nil, false
+	}
+	if err != nil {
+		writeStoreError(w, r, err, "Could not get target group", zap.String("target_group", name))
+		return nil, false
+	}
+	return tg, true
+}
+
+// applyGroupOps applies the operations to the target group in a single transaction, and returns
+// the resulting group along with its metadata
+func applyGroupOps(r *http.Request, name string, ops []*store.TxnOp) (*store.TargetGroup, error) {
+	groups, err := store.StoreInstance.ApplyTxn(r.Context(), &store.Txn{Operations: ops})
+	if err != nil {
+		return nil, err
+	}
+	if tg := groups[name]; tg != nil {
+		return tg, nil
+	}
+	// The group is unchanged
+	return store.StoreInstance.GetTargetGroup(r.Context(), name)
+}
+
+func newV2Group(name string, tg *store.TargetGroup, effective map[string]string) *v2Group {
+	g := &v2Group{Name: name, Targets: append([]string{}, tg.Targets...), Labels: tg.Labels, EffectiveLabels: effective, Templates: append([]string{}, tg.Templates...), Version: groupVersion(tg)}
+	sort.Strings(g.Targets)
+	if g.Labels == nil {
+		g.Labels = map[string]string{}
+	}
+	return g
+}
+
+// pageParams returns the page size and the name of the last group of the previous page
+func pageParams(r *http.Request) (int, string, error) {
+	size := defaultPageSize
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		s, err := strconv.Atoi(v)
+		if err != nil || s < 1 || s > maxPageSize {
+			return 0, "", fmt.Errorf("Parameter 'page_size' must be between 1 and %d", maxPageSize)
+		}
+		size = s
+	}
+	after := ""
+	if v := r.URL.Query().Get("page_token"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return 0, "", fmt.Errorf("Parameter 'page_token' is invalid")
+		}
+		after = string(b)
+	}
+	return size, after, nil
+}
+
+// V2ListGroupsHandler returns the target groups sorted by name, one page at a time.  The
+// next_page_token of the response is passed as page_token to get the following page.
+var V2ListGroupsHandler = func(w http.ResponseWriter, r *http.Request) {
+	size, after, err := pageParams(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, err.Error())
+		return
+	}
+
+	// One more group is read to know whether there is a next page
+	groups, err := store.StoreInstance.ListTargetGroups(r.Context(), &store.GroupQuery{After: after, Limit: size + 1})
+	if err != nil {
+		writeStoreError(w, r, err, "Could not list target groups")
+		return
+	}
+
+	res := &v2GroupList{Groups: []*v2Group{}}
+	if len(groups) > size {
+		res.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(groups[size-1].Name))
+		groups = groups[:size]
+	}
+	byName := make(map[string]*store.TargetGroup, len(groups))
+	for _, tg := range groups {
+		byName[tg.Name] = tg
+	}
+	effective, ok := effectiveLabels(w, r, byName)
+	if !ok {
+		return
+	}
+	for _, tg := range groups {
+		res.Groups = append(res.Groups, newV2Group(tg.Name, tg, effective[tg.Name]))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// V2GetGroupHandler returns a target group
+var V2GetGroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	tg, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{name: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, newV2Group(name, tg, effective[name]))
+}
+
+// V2PutGroupHandler creates the target group, or replaces its targets, labels and templates
+var V2PutGroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	body := &v2Group{}
+	if !decodeBody(w, r, body) {
+		return
+	}
+	if len(body.Targets) == 0 && len(body.Labels) == 0 && len(body.Templates) == 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "A target group needs at least one target, label or template")
+		return
+	}
+	if body.Name != "" && body.Name != name {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "The name of the body doesn't match the path")
+		return
+	}
+	labelNames := make([]string, 0, len(body.Labels))
+	for k := range body.Labels {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+	if problems := append(validateTargets(body.Targets), validateLabelNames(labelNames)...); len(problems) > 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "Invalid target group", problems...)
+		return
+	}
+
+	desired := &store.TargetGroup{Name: name, Targets: body.Targets, Labels: body.Labels, Templates: body.Templates}
+	status := http.StatusOK
+	current, err := store.StoreInstance.GetTargetGroup(r.Context(), name)
+	// The changes computed from the current group are only applied if it's still in this state
+	cond := store.UnchangedCondition(name, current)
+	if errors.Is(err, store.ErrTargetGroupNotFound) {
+		status = http.StatusCreated
+		current = &store.TargetGroup{Name: name}
+	} else if err != nil {
+		writeStoreError(w, r, err, "Could not get target group", zap.String("target_group", name))
+		return
+	}
+	// The changes are applied in a single transaction, so that the group is modified once
+	ops := []*store.TxnOp{}
+	change := &policy.Change{Group: name, Created: status == http.StatusCreated, Conditions: []*store.TxnCondition{cond}}
+	for _, d := range store.DiffTargetGroups(map[string]*store.TargetGroup{name: current}, map[string]*store.TargetGroup{name: desired}) {
+		ops = append(ops, d.Operations(true)...)
+		change.Set, change.Removed = d.SetLabels, d.RemovedLabels
+		// The current group was replaced by an empty one when it doesn't exist
+		d.Created = change.Created
+		audit.Record(r.Context(), d)
+	}
+	if !checkTemplates(w, r, &store.Txn{Operations: ops}) {
+		return
+	}
+	before := map[string]*store.TargetGroup{}
+	if !change.Created {
+		before[name] = current
+	}
+	// The labels inherited from the templates count as labels of the group
+	if err := change.SetLabels(r.Context(), store.StoreInstance, before, map[string]*store.TargetGroup{name: desired}); err != nil {
+		writeStoreError(w, r, err, "Could not get templates of target group", zap.String("target_group", name))
+		return
+	}
+	r, ok := checkLabelChanges(w, r, change)
+	if !ok {
+		return
+	}
+	tg, err := applyGroupOps(r, name, ops)
+	if err != nil {
+		metricTargetGroupUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not update target group", zap.String("target_group", name))
+		return
+	}
+	metricTargetGroupUpdates.Inc()
+
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{name: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, status, newV2Group(name, tg, effective[name]))
+}
+
+// V2DeleteGroupHandler deletes a target group along with its targets and labels
+var V2DeleteGroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	audit.Record(r.Context(), &store.GroupDiff{Name: name, Deleted: true})
+	if err := store.StoreInstance.RemoveTargetGroup(r.Context(), name); err != nil {
+		metricTargetRemoveFailed.Inc()
+		writeStoreError(w, r, err, "Could not remove target group", zap.String("target_group", name))
+		return
+	}
+	metricTargetRemove.Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// V2GetTargetsHandler returns the targets of a target group
+var V2GetTargetsHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	tg, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, &v2Targets{Targets: newV2Group(name, tg, nil).Targets})
+}
+
+// V2AddTargetsHandler adds targets to a target group, creating the group if it doesn't exist,
+// and returns all of its targets
+var V2AddTargetsHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	body := &v2Targets{}
+	if !decodeBody(w, r, body) {
+		return
+	}
+	if len(body.Targets) == 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "At least one target is required")
+		return
+	}
+	audit.Record(r.Context(), &store.GroupDiff{Name: name, AddedTargets: body.Targets})
+	if problems := validateTargets(body.Targets); len(problems) > 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "Invalid targets", problems...)
+		return
+	}
+	r, ok := checkLabels(w, r, name, nil, nil, true)
+	if !ok {
+		return
+	}
+
+	ops := []*store.TxnOp{}
+	for _, t := range body.Targets {
+		ops = append(ops, &store.TxnOp{Op: store.TxnAddTarget, Group: name, Target: t})
+	}
+	tg, err := applyGroupOps(r, name, ops)
+	if err != nil {
+		metricTargetGroupUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not add targets to target group", zap.String("target_group", name), zap.Strings("targets", body.Targets))
+		return
+	}
+	metricTargetGroupUpdates.Inc()
+
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, &v2Targets{Targets: newV2Group(name, tg, nil).Targets})
+}
+
+// V2RemoveTargetHandler removes a target from a target group
+var V2RemoveTargetHandler = func(w http.ResponseWriter, r *http.Request) {
+	name, target := mux.Vars(r)["group"], mux.Vars(r)["target"]
+	audit.Record(r.Context(), &store.GroupDiff{Name: name, RemovedTargets: []string{target}})
+	tg, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	if !lib.Contains(tg.Targets, target) {
+		WriteError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Target %s not found in target group %s", target, name))
+		return
+	}
+	if err := store.StoreInstance.RemoveTargetFromGroup(r.Context(), name, target); err != nil {
+		metricTargetRemoveFailed.Inc()
+		writeStoreError(w, r, err, "Could not remove target from target group", zap.String("target_group", name), zap.String("target", target))
+		return
+	}
+	metricTargetRemove.Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// V2GetLabelsHandler returns the labels of a target group
+var V2GetLabelsHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	tg, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{name: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, &v2Labels{Labels: newV2Group(name, tg, nil).Labels, EffectiveLabels: effective[name]})
+}
+
+// V2PatchLabelsHandler sets the labels of a target group which have a value and removes those
+// set to null, creating the group if it doesn't exist, and returns all of its labels
+var V2PatchLabelsHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	body := &v2LabelsPatch{}
+	if !decodeBody(w, r, body) {
+		return
+	}
+	if len(body.Labels) == 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "At least one label is required")
+		return
+	}
+
+	set := map[string]string{}
+	removed := []string{}
+	for k, v := range body.Labels {
+		if v == nil {
+			removed = append(removed, k)
+		} else {
+			set[k] = *v
+		}
+	}
+	sort.Strings(removed)
+	names := make([]string, 0, len(set))
+	for k := range set {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	audit.Record(r.Context(), &store.GroupDiff{Name: name, SetLabels: set, RemovedLabels: removed})
+	if problems := validateLabelNames(names); len(problems) > 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "Invalid labels", problems...)
+		return
+	}
+	r, ok := checkLabels(w, r, name, set, removed, true)
+	if !ok {
+		return
+	}
+
+	ops := []*store.TxnOp{}
+	for _, k := range names {
+		ops = append(ops, &store.TxnOp{Op: store.TxnSetLabel, Group: name, Label: k, Value: set[k]})
+	}
+	for _, k := range removed {
+		ops = append(ops, &store.TxnOp{Op: store.TxnDeleteLabel, Group: name, Label: k})
+	}
+	tg, err := applyGroupOps(r, name, ops)
+	if err != nil {
+		metricTargetGroupLabelsUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not update labels of target group", zap.String("target_group", name))
+		return
+	}
+	metricTargetGroupLabelsUpdates.Inc()
+
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{name: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, &v2Labels{Labels: newV2Group(name, tg, nil).Labels, EffectiveLabels: effective[name]})
+}
+
+// V2RemoveLabelHandler removes a label from a target group
+var V2RemoveLabelHandler = func(w http.ResponseWriter, r *http.Request) {
+	name, label := mux.Vars(r)["group"], mux.Vars(r)["label"]
+	audit.Record(r.Context(), &store.GroupDiff{Name: name, RemovedLabels: []string{label}})
+	tg, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	if _, ok := tg.Labels[label]; !ok {
+		WriteError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Label %s not found in target group %s", label, name))
+		return
+	}
+	r, ok = checkLabels(w, r, name, nil, []string{label}, false)
+	if !ok {
+		return
+	}
+	if err := store.StoreInstance.RemoveLabelFromGroup(r.Context(), name, label); err != nil {
+		metricTargetGroupLabelsUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not remove label from target group", zap.String("target_group", name), zap.String("label", label))
+		return
+	}
+	metricTargetGroupLabelsUpdates.Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// V2RenameGroupHandler atomically renames a target group to the name of the body, which must not
+// exist, and returns the renamed group
+var V2RenameGroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	body := &v2GroupName{}
+	if !decodeBody(w, r, body) {
+		return
+	}
+	if body.Name == "" {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "The new name of the target group is required")
+		return
+	}
+	recordBody(r, body, name, body.Name)
+	src, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	// The group is created under the new name with its labels and templates
+	if r, ok = checkCopyLabels(w, r, name, body.Name, src); !ok {
+		return
+	}
+
+	if err := store.StoreInstance.RenameTargetGroup(r.Context(), name, body.Name); err != nil {
+		metricTargetGroupUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not rename target group", zap.String("target_group", name), zap.String("new_name", body.Name))
+		return
+	}
+	metricTargetGroupUpdates.Inc()
+
+	tg, ok := getGroup(w, r, body.Name)
+	if !ok {
+		return
+	}
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{body.Name: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, newV2Group(body.Name, tg, effective[body.Name]))
+}
+
+// V2CopyGroupHandler creates a target group named after the body, which must not exist, with
+// the targets and labels of the target group, and returns the new group
+var V2CopyGroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	body := &v2GroupName{}
+	if !decodeBody(w, r, body) {
+		return
+	}
+	if body.Name == "" {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "The name of the copy is required")
+		return
+	}
+	recordBody(r, body, name, body.Name)
+	src, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	// The copy is created with the labels and templates of the group
+	if r, ok = checkCopyLabels(w, r, name, body.Name, src); !ok {
+		return
+	}
+
+	if err := store.StoreInstance.CopyTargetGroup(r.Context(), name, body.Name); err != nil {
+		metricTargetGroupUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not copy target group", zap.String("target_group", name), zap.String("new_name", body.Name))
+		return
+	}
+	metricTargetGroupUpdates.Inc()
+
+	tg, ok := getGroup(w, r, body.Name)
+	if !ok {
+		return
+	}
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{body.Name: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusCreated, newV2Group(body.Name, tg, effective[body.Name]))
+}
+
+// V2MergeGroupHandler atomically adds the targets and labels of a target group to the group
+// named by into, optionally removing the merged group, and returns the resulting group
+var V2MergeGroupHandler = func(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	body := &v2Merge{}
+	if !decodeBody(w, r, body) {
+		return
+	}
+	if body.LabelConflict == "" {
+		body.LabelConflict = store.LabelConflictFail
+	}
+	problems := []string{}
+	if body.Into == "" {
+		problems = append(problems, "The target group to merge into is required")
+	} else if body.Into == name {
+		problems = append(problems, "A target group can't be merged into itself")
+	}
+	if !lib.Contains(store.LabelConflictPolicies, body.LabelConflict) {
+		problems = append(problems, fmt.Sprintf("Label conflict policy '%s' must be one of %s",
+			body.LabelConflict, strings.Join(store.LabelConflictPolicies, ", ")))
+	}
+	if len(problems) > 0 {
+		WriteError(w, http.StatusBadRequest, ErrCodeInvalidArgument, "Invalid merge", problems...)
+		return
+	}
+	recordBody(r, body, name, body.Into)
+	src, ok := getGroup(w, r, name)
+	if !ok {
+		return
+	}
+	dst, ok := getGroup(w, r, body.Into)
+	if !ok {
+		return
+	}
+	r, ok = checkLabelChanges(w, r, &policy.Change{Group: body.Into, Set: mergedLabels(dst, src, body.LabelConflict),
+		Conditions: []*store.TxnCondition{store.UnchangedCondition(body.Into, dst), store.UnchangedCondition(name, src)}})
+	if !ok {
+		return
+	}
+
+	err := store.StoreInstance.MergeTargetGroup(r.Context(), name, body.Into, body.LabelConflict, body.RemoveSource)
+	if err != nil {
+		metricTargetGroupUpdatesFailed.Inc()
+		writeStoreError(w, r, err, "Could not merge target groups", zap.String("target_group", name), zap.String("into", body.Into))
+		return
+	}
+	metricTargetGroupUpdates.Inc()
+
+	tg, ok := getGroup(w, r, body.Into)
+	if !ok {
+		return
+	}
+	effective, ok := effectiveLabels(w, r, map[string]*store.TargetGroup{body.Into: tg})
+	if !ok {
+		return
+	}
+	setETag(w, tg)
+	writeJSON(w, http.StatusOK, newV2Group(body.Into, tg, effective[body.Into]))
+}
+
+// mergedLabels returns the labels of src which merging it into dst with the label conflict
+// policy sets on dst
+func mergedLabels(dst, src *store.TargetGroup, conflictPolicy string) map[string]string {
+	set := map[string]string{}
+	for k, v := range src.Labels {
+		dv, ok := dst.Labels[k]
+		if !ok || (dv != v && conflictPolicy == store.LabelConflictOverwrite) {
+			set[k] = v
+		}
+	}
+	return set
+}
+
+// NotFoundHandler returns an error object for the unknown v2 API paths, and the default
+// plain text response otherwise
+var NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+		WriteError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("Unknown path %s", r.URL.Path))
+		return
+	}
+	http.NotFound(w, r)
+})
+
+// MethodNotAllowedHandler returns an error object for the v2 API paths, and the default
+// plain text response otherwise
+var MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, APIv2Prefix) {
+		WriteError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, fmt.Sprintf("Method %s not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+})
+
+// Deprecated marks the responses of a v1 route as deprecated, pointing to the v2 API
+func Deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</api/openapi.json>; rel="service-desc", </api/v2/groups>; rel="successor-version"`)
+		next(w, r)
+	}
+}
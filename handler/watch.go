@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+// watchKeepAliveInterval is how often a comment is sent on idle streams, so that proxies don't
+// close them
+var watchKeepAliveInterval = 30 * time.Second
+
+// WatchHandler streams the changes of the target groups as Server-Sent Events.  The stream can be
+// restricted to some groups with group=<name> and to groups with some labels with
+// label=<name>=<value>.  Clients resume from a revision with revision=<revision> or the
+// Last-Event-ID header.
+var WatchHandler = func(w http.ResponseWriter, r *http.Request) {
+	qsargs := r.URL.Query()
+
+	filter := &store.EventFilter{Groups: qsargs["group"], Labels: map[string]string{}}
+	for _, lvpair := range qsargs["label"] {
+		parts := strings.SplitN(lvpair, "=", 2)
+		if len(parts) != 2 {
+			msg := fmt.Sprintf("ERROR: Label '%s' must be in the form <label>=<value>", lvpair)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		filter.Labels[parts[0]] = parts[1]
+	}
+
+	rawRevision := qsargs.Get("revision")
+	if rawRevision == "" {
+		rawRevision = r.Header.Get("Last-Event-ID")
+	}
+	var revision uint64
+	resume := rawRevision != ""
+	if resume {
+		var err error
+		if revision, err = strconv.ParseUint(rawRevision, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("ERROR: Revision '%s' is invalid", rawRevision), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, sub, err := store.WatcherInstance.Subscribe(revision, resume)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERROR: %s", err), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	// The stream is kept open past the write timeout of the server
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		requestLogger(r).Warn("Could not disable the write deadline of the stream", zap.Error(err))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(watchKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		for _, e := range events {
+			if !filter.Match(e) {
+				continue
+			}
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Revision, e.Type, b)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case events = <-sub.C:
+			if events == nil {
+				// The watcher stopped or dropped the subscription
+				return
+			}
+		case <-keepAlive.C:
+			events = nil
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
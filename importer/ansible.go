@@ -0,0 +1,510 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"gopkg.in/yaml.v2"
+)
+
+// inventory is an Ansible inventory, read from either an INI or a YAML file
+type inventory struct {
+	groups   map[string]*inventoryGroup
+	hostVars map[string]map[string]string
+	warnings []string
+}
+
+type inventoryGroup struct {
+	hosts    []string
+	vars     map[string]string
+	children []string
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		groups:   map[string]*inventoryGroup{},
+		hostVars: map[string]map[string]string{},
+	}
+}
+
+func (inv *inventory) group(name string) *inventoryGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &inventoryGroup{vars: map[string]string{}}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+func (inv *inventory) addHost(group, host string, vars map[string]string) {
+	g := inv.group(group)
+	if !lib.Contains(g.hosts, host) {
+		g.hosts = append(g.hosts, host)
+	}
+	if _, ok := inv.hostVars[host]; !ok {
+		inv.hostVars[host] = map[string]string{}
+	}
+	for k, v := range vars {
+		inv.hostVars[host][k] = v
+	}
+}
+
+func (inv *inventory) addChild(group, child string) {
+	g := inv.group(group)
+	inv.group(child)
+	if !lib.Contains(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+func (inv *inventory) warnf(format string, args ...interface{}) {
+	inv.warnings = append(inv.warnings, fmt.Sprintf(format, args...))
+}
+
+// AnsibleInventory reads the target groups of an Ansible inventory, in INI or YAML.  Every
+// host group with hosts becomes a target group, the hosts of the group being its targets
+// and the variables of the group and of its parent groups its labels.  Host variables
+// become labels of a group when every host of the group has the same value.  Variables
+// starting with ansible_ are connection settings and are skipped, apart from ansible_host
+// which is used as the address of the target instead of the host name.  When port isn't
+// zero, it's appended to the address of every target.
+//
+// The returned warnings list the variables which couldn't be imported.
+func AnsibleInventory(b []byte, format string, port int) (map[string]*store.TargetGroup, []string, error) {
+	var inv *inventory
+	var err error
+	switch format {
+	case "ini":
+		inv, err = parseInventoryINI(b)
+	case "yaml":
+		inv, err = parseInventoryYAML(b)
+	default:
+		return nil, nil, fmt.Errorf("Unsupported inventory format '%s'", format)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not parse inventory: %s", err)
+	}
+
+	groups, err := inv.targetGroups(port)
+	if err != nil {
+		return nil, nil, err
+	}
+	return groups, inv.warnings, nil
+}
+
+// targetGroups turns the host groups of the inventory into target groups
+func (inv *inventory) targetGroups(port int) (map[string]*store.TargetGroup, error) {
+	// Hosts listed directly under all are ungrouped
+	if all, ok := inv.groups["all"]; ok {
+		for _, h := range all.hosts {
+			inv.addHost("ungrouped", h, nil)
+		}
+		all.hosts = nil
+	}
+
+	parents := map[string][]string{}
+	for _, name := range sortedGroupNames(inv.groups) {
+		for _, child := range inv.groups[name].children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+
+	groups := map[string]*store.TargetGroup{}
+	for _, name := range sortedGroupNames(inv.groups) {
+		g := inv.groups[name]
+		if len(g.hosts) == 0 {
+			continue
+		}
+		tgName := groupName(name)
+		if _, ok := groups[tgName]; ok {
+			return nil, fmt.Errorf("Target group %s is defined more than once", tgName)
+		}
+
+		tg := newGroup(tgName)
+		for _, h := range g.hosts {
+			target := inventoryTarget(h, inv.hostVars[h], port)
+			if !lib.Contains(tg.Targets, target) {
+				tg.Targets = append(tg.Targets, target)
+			}
+		}
+		for k, v := range inv.groupVars(name, parents, map[string]bool{}) {
+			tg.Labels[k] = v
+		}
+		inv.addHostVarLabels(name, tg)
+		groups[tgName] = tg
+	}
+	return groups, nil
+}
+
+// groupVars returns the variables of the group merged with those of its parent groups, the
+// variables of the group taking precedence, and those of all having the lowest precedence
+func (inv *inventory) groupVars(name string, parents map[string][]string, visiting map[string]bool) map[string]string {
+	vars := map[string]string{}
+	if visiting[name] {
+		return vars
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	groupParents := parents[name]
+	if len(groupParents) == 0 && name != "all" {
+		groupParents = []string{"all"}
+	}
+	for _, p := range groupParents {
+		for k, v := range inv.groupVars(p, parents, visiting) {
+			vars[k] = v
+		}
+	}
+	if g, ok := inv.groups[name]; ok {
+		for k, v := range g.vars {
+			if !strings.HasPrefix(k, "ansible_") {
+				vars[k] = v
+			}
+		}
+	}
+	return vars
+}
+
+// addHostVarLabels sets the host variables for which every host of the group has the same
+// value, either its own or the one of the group, as labels of the target group
+func (inv *inventory) addHostVarLabels(name string, tg *store.TargetGroup) {
+	hosts := inv.groups[name].hosts
+	names := map[string]bool{}
+	for _, h := range hosts {
+		for k := range inv.hostVars[h] {
+			if !strings.HasPrefix(k, "ansible_") {
+				names[k] = true
+			}
+		}
+	}
+
+	for _, k := range sortedSet(names) {
+		groupValue, inGroup := tg.Labels[k]
+		values := map[string]bool{}
+		for _, h := range hosts {
+			if v, ok := inv.hostVars[h][k]; ok {
+				values[v] = true
+			} else if inGroup {
+				values[groupValue] = true
+			} else {
+				values[""] = true
+			}
+		}
+		if len(values) == 1 {
+			for v := range values {
+				tg.Labels[k] = v
+			}
+			continue
+		}
+		delete(tg.Labels, k)
+		inv.warnf("Variable %s has different values for the hosts of group %s, it isn't imported as a label", k, name)
+	}
+}
+
+// inventoryTarget returns the target of a host, the ansible_host variable or the host name
+// without its SSH port, followed by port when it isn't zero
+func inventoryTarget(host string, vars map[string]string, port int) string {
+	address := host
+	if h, ok := vars["ansible_host"]; ok && h != "" {
+		address = h
+	} else if i := strings.LastIndex(host, ":"); i != -1 {
+		address = host[:i]
+	}
+	address = strings.ToLower(address)
+	if port != 0 {
+		address = fmt.Sprintf("%s:%d", address, port)
+	}
+	return address
+}
+
+// parseInventoryINI reads an INI inventory made of [group], [group:vars] and [group:children]
+// sections.  Hosts listed before the first section are ungrouped.
+func parseInventoryINI(b []byte) (*inventory, error) {
+	inv := newInventory()
+	section, kind := "ungrouped", "hosts"
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: invalid section header '%s'", line, text)
+			}
+			section, kind = strings.TrimSpace(text[1:len(text)-1]), "hosts"
+			if i := strings.LastIndex(section, ":"); i != -1 {
+				section, kind = section[:i], section[i+1:]
+			}
+			if section == "" || (kind != "hosts" && kind != "vars" && kind != "children") {
+				return nil, fmt.Errorf("line %d: invalid section header '%s'", line, text)
+			}
+			inv.group(section)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			parts := strings.SplitN(text, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("line %d: variable '%s' must be in the form <name>=<value>", line, text)
+			}
+			inv.group(section).vars[strings.TrimSpace(parts[0])] = unquote(strings.TrimSpace(parts[1]))
+		case "children":
+			inv.addChild(section, text)
+		default:
+			fields, err := splitFields(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			vars := map[string]string{}
+			for _, f := range fields[1:] {
+				parts := strings.SplitN(f, "=", 2)
+				if len(parts) != 2 {
+					return nil, fmt.Errorf("line %d: host variable '%s' must be in the form <name>=<value>", line, f)
+				}
+				vars[parts[0]] = parts[1]
+			}
+			hosts, err := expandHostPattern(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			for _, h := range hosts {
+				inv.addHost(section, h, vars)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// splitFields splits a line on spaces, keeping quoted strings together and removing their quotes
+func splitFields(s string) ([]string, error) {
+	fields := []string{}
+	var cur strings.Builder
+	var quote rune
+	inField := false
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in '%s'", s)
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// expandHostPattern expands the ranges of a host pattern, such as web[01:10:2].example.com or
+// db-[a:c], into the list of hosts
+func expandHostPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start == -1 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end == -1 {
+		return nil, fmt.Errorf("invalid host range in '%s'", pattern)
+	}
+	end += start
+	prefix, spec, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("invalid host range '[%s]' in '%s'", spec, pattern)
+	}
+	step := 1
+	if len(parts) == 3 {
+		s, err := strconv.Atoi(parts[2])
+		if err != nil || s < 1 {
+			return nil, fmt.Errorf("invalid host range step '%s' in '%s'", parts[2], pattern)
+		}
+		step = s
+	}
+
+	values := []string{}
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	switch {
+	case errFrom == nil && errTo == nil && from <= to:
+		width := 0
+		if strings.HasPrefix(parts[0], "0") {
+			width = len(parts[0])
+		}
+		for i := from; i <= to; i += step {
+			values = append(values, fmt.Sprintf("%0*d", width, i))
+		}
+	case len(parts[0]) == 1 && len(parts[1]) == 1 && parts[0][0] <= parts[1][0] &&
+		isLetter(parts[0][0]) && isLetter(parts[1][0]):
+		for c := int(parts[0][0]); c <= int(parts[1][0]); c += step {
+			values = append(values, string(rune(c)))
+		}
+	default:
+		return nil, fmt.Errorf("invalid host range '[%s]' in '%s'", spec, pattern)
+	}
+
+	rest, err := expandHostPattern(suffix)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{}
+	for _, v := range values {
+		for _, r := range rest {
+			hosts = append(hosts, prefix+v+r)
+		}
+	}
+	return hosts, nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// yamlInventoryGroup is a group of a YAML inventory
+type yamlInventoryGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlInventoryGroup    `yaml:"children"`
+}
+
+// parseInventoryYAML reads a YAML inventory, whose top-level keys are groups, usually only all
+func parseInventoryYAML(b []byte) (*inventory, error) {
+	top := map[string]*yamlInventoryGroup{}
+	if err := yaml.Unmarshal(b, &top); err != nil {
+		return nil, err
+	}
+	inv := newInventory()
+	for _, name := range sortedYAMLGroupNames(top) {
+		inv.addYAMLGroup(name, top[name])
+	}
+	return inv, nil
+}
+
+func (inv *inventory) addYAMLGroup(name string, yg *yamlInventoryGroup) {
+	g := inv.group(name)
+	if yg == nil {
+		return
+	}
+	for _, k := range sortedYAMLKeys(yg.Vars) {
+		if v, ok := inv.scalar(yg.Vars[k], k, name); ok {
+			g.vars[k] = v
+		}
+	}
+	hostNames := make([]string, 0, len(yg.Hosts))
+	for h := range yg.Hosts {
+		hostNames = append(hostNames, h)
+	}
+	sort.Strings(hostNames)
+	for _, h := range hostNames {
+		hv := yg.Hosts[h]
+		vars := map[string]string{}
+		for _, k := range sortedYAMLKeys(hv) {
+			if s, ok := inv.scalar(hv[k], k, h); ok {
+				vars[k] = s
+			}
+		}
+		hosts, err := expandHostPattern(h)
+		if err != nil {
+			inv.warnf("Host %s of group %s is skipped: %s", h, name, err)
+			continue
+		}
+		for _, host := range hosts {
+			inv.addHost(name, host, vars)
+		}
+	}
+	for _, child := range sortedYAMLGroupNames(yg.Children) {
+		inv.addChild(name, child)
+		inv.addYAMLGroup(child, yg.Children[child])
+	}
+}
+
+// scalar returns the value of a variable as a string, or false with a warning when it's a
+// list or a map, which can't be turned into a label value
+func (inv *inventory) scalar(v interface{}, name, owner string) (string, bool) {
+	switch val := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return val, true
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(val), true
+	default:
+		if !strings.HasPrefix(name, "ansible_") {
+			inv.warnf("Variable %s of %s isn't a scalar value, it isn't imported as a label", name, owner)
+		}
+		return "", false
+	}
+}
+
+func sortedGroupNames(groups map[string]*inventoryGroup) []string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedYAMLGroupNames(groups map[string]*yamlInventoryGroup) []string {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedYAMLKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
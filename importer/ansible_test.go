@@ -0,0 +1,131 @@
+package importer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+func TestAnsibleInventory(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		format    string
+		inventory string
+		port      int
+		want      map[string]*store.TargetGroup
+		warnings  []string
+		err       string
+	}{
+		{
+			name:   "ini",
+			format: "ini",
+			port:   9100,
+			inventory: `
+lonely.example.com
+
+[all:vars]
+env=prod
+dc=paris
+ansible_user=deploy
+
+[web]
+web[1:2].example.com ansible_port=22 role=frontend
+[web:vars]
+dc="london"
+
+[db]
+db1:2222 ansible_host=10.0.0.5 tier=1
+db2 tier=2
+
+[servers:children]
+web
+db
+[servers:vars]
+team=ops
+`,
+			want: map[string]*store.TargetGroup{
+				"ungrouped": {Name: "ungrouped", Targets: []string{"lonely.example.com:9100"}, Labels: map[string]string{"env": "prod", "dc": "paris"}},
+				"web": {Name: "web", Targets: []string{"web1.example.com:9100", "web2.example.com:9100"},
+					Labels: map[string]string{"env": "prod", "dc": "london", "team": "ops", "role": "frontend"}},
+				"db": {Name: "db", Targets: []string{"10.0.0.5:9100", "db2:9100"},
+					Labels: map[string]string{"env": "prod", "dc": "paris", "team": "ops"}},
+			},
+			warnings: []string{"Variable tier has different values for the hosts of group db, it isn't imported as a label"},
+		},
+		{
+			name:   "yaml",
+			format: "yaml",
+			inventory: `
+all:
+  vars:
+    env: prod
+  hosts:
+    lonely:
+  children:
+    web:
+      hosts:
+        web1:
+          ansible_host: 10.0.0.1
+          rack: r1
+        web2:
+          rack: r1
+      vars:
+        replicas: 2
+        tags: [a, b]
+      children:
+        canary:
+          hosts:
+            web3:
+          vars:
+            env: staging
+`,
+			want: map[string]*store.TargetGroup{
+				"ungrouped": {Name: "ungrouped", Targets: []string{"lonely"}, Labels: map[string]string{"env": "prod"}},
+				"web":       {Name: "web", Targets: []string{"10.0.0.1", "web2"}, Labels: map[string]string{"env": "prod", "replicas": "2", "rack": "r1"}},
+				"canary":    {Name: "canary", Targets: []string{"web3"}, Labels: map[string]string{"env": "staging", "replicas": "2"}},
+			},
+			warnings: []string{"Variable tags of web isn't a scalar value, it isn't imported as a label"},
+		},
+		{
+			name:   "cycle",
+			format: "ini",
+			inventory: `
+[a]
+h1
+[a:vars]
+x=1
+[a:children]
+b
+[b:children]
+a
+[b:vars]
+x=2
+y=2
+`,
+			want: map[string]*store.TargetGroup{
+				"a": {Name: "a", Targets: []string{"h1"}, Labels: map[string]string{"x": "1", "y": "2"}},
+			},
+		},
+		{
+			name:      "invalid section",
+			format:    "ini",
+			inventory: "[web:hostvars]\n",
+			err:       "Could not parse inventory: line 1: invalid section header '[web:hostvars]'",
+		},
+		{
+			name:      "unsupported format",
+			format:    "json",
+			inventory: "{}",
+			err:       "Unsupported inventory format 'json'",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, warnings, err := AnsibleInventory([]byte(tc.inventory), tc.format, tc.port)
+			checkImported(t, groups, err, tc.want, tc.err)
+			if !reflect.DeepEqual(warnings, tc.warnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tc.warnings)
+			}
+		})
+	}
+}
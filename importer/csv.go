@@ -0,0 +1,64 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// CSV reads target groups from rows of group,target[,label=value...].  Rows of the same group
+// add their targets and labels to it, a row with an empty target only sets labels, and lines
+// starting with # are ignored.
+func CSV(r io.Reader) (map[string]*store.TargetGroup, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	groups := map[string]*store.TargetGroup{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected at least a target group and a target", line)
+		}
+
+		name := strings.TrimSpace(record[0])
+		if name == "" {
+			return nil, fmt.Errorf("line %d: target group name must not be empty", line)
+		}
+		tg, ok := groups[name]
+		if !ok {
+			tg = newGroup(name)
+			groups[name] = tg
+		}
+		if target := strings.TrimSpace(record[1]); target != "" && !lib.Contains(tg.Targets, target) {
+			tg.Targets = append(tg.Targets, target)
+		}
+		for _, lvpair := range record[2:] {
+			if strings.TrimSpace(lvpair) == "" {
+				continue
+			}
+			parts := strings.SplitN(lvpair, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("line %d: label '%s' must be in the form <label>=<value>", line, lvpair)
+			}
+			label := strings.TrimSpace(parts[0])
+			if v, ok := tg.Labels[label]; ok && v != parts[1] {
+				return nil, fmt.Errorf("line %d: label %s of target group %s is already set to '%s'", line, label, name, v)
+			}
+			tg.Labels[label] = parts[1]
+		}
+	}
+	return groups, nil
+}
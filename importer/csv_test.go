@@ -0,0 +1,61 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+func TestCSV(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		csv  string
+		want map[string]*store.TargetGroup
+		err  string
+	}{
+		{
+			name: "targets and labels",
+			csv: `# group,target,labels
+web,a:80,env=prod
+web, b:80
+# web,c:80
+web,,team=ops
+db,d:5432,env=prod,env=prod
+cache,,env=dev
+`,
+			want: map[string]*store.TargetGroup{
+				"web":   {Name: "web", Targets: []string{"a:80", "b:80"}, Labels: map[string]string{"env": "prod", "team": "ops"}},
+				"db":    {Name: "db", Targets: []string{"d:5432"}, Labels: map[string]string{"env": "prod"}},
+				"cache": {Name: "cache", Targets: []string{}, Labels: map[string]string{"env": "dev"}},
+			},
+		},
+		{
+			name: "conflicting label values",
+			csv: `web,a:80,env=prod
+web,b:80,env=dev
+`,
+			err: "line 2: label env of target group web is already set to 'prod'",
+		},
+		{
+			name: "missing target",
+			csv:  "web\n",
+			err:  "line 1: expected at least a target group and a target",
+		},
+		{
+			name: "empty group name",
+			csv:  ",a:80\n",
+			err:  "line 1: target group name must not be empty",
+		},
+		{
+			name: "invalid label",
+			csv:  "web,a:80,env\n",
+			err:  "line 1: label 'env' must be in the form <label>=<value>",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, err := CSV(strings.NewReader(tc.csv))
+			checkImported(t, groups, err, tc.want, tc.err)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// Result describes the changes made, or which would be made in dry-run mode, by an import
+type Result struct {
+	DryRun  bool               `json:"dry_run" yaml:"dry_run"`
+	Changes []*store.GroupDiff `json:"changes" yaml:"changes"`
+	// Warnings lists the parts of the imported file which were skipped
+	Warnings []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+}
+
+// ValidationError lists every problem found in the imported target groups
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0]
+	}
+	return fmt.Sprintf("%d problems found:\n- %s", len(e.Problems), strings.Join(e.Problems, "\n- "))
+}
+
+// Validate checks the names of the target groups, targets and labels, returning a
+// *ValidationError listing every invalid one
+func Validate(groups map[string]*store.TargetGroup) error {
+	problems := []string{}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tg := groups[name]
+		if name == "" {
+			problems = append(problems, "Target group name must not be empty")
+		}
+		for _, t := range tg.Targets {
+			if !lib.IsValidTargetName(t) {
+				problems = append(problems, fmt.Sprintf("Target '%s' of target group %s is invalid", t, name))
+			}
+		}
+		for _, k := range sortedKeys(tg.Labels) {
+			if !lib.IsValidLabelName(k) {
+				problems = append(problems, fmt.Sprintf("Label name '%s' of target group %s is invalid", k, name))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Plan returns the changes required to bring the imported target groups from their current
+// to their imported state.  Target groups which aren't imported are left alone and, unless
+// prune is set, so are the targets and labels of imported groups which aren't imported.  The
+// templates of the groups are left untouched, as the imported formats have none.
+func Plan(current, imported map[string]*store.TargetGroup, prune bool) []*store.GroupDiff {
+	diffs := []*store.GroupDiff{}
+	for _, d := range store.DiffTargetGroups(current, imported) {
+		if _, ok := imported[d.Name]; !ok {
+			continue
+		}
+		d.SetTemplates, d.RemovedTemplates = nil, nil
+		if !prune {
+			d.RemovedTargets = nil
+			d.RemovedLabels = nil
+		}
+		if !d.Empty() {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// PlanTxn returns a transaction applying all the changes of the plan at once, conditioned on the
+// target groups being unchanged since current, from which the plan was computed: the groups
+// created by the plan must still not exist, and the others must still be at the version of their
+// metadata, or just exist when it isn't known.  It returns nil when the plan has no changes.
+func PlanTxn(current map[string]*store.TargetGroup, diffs []*store.GroupDiff, prune bool) *store.Txn {
+	txn := &store.Txn{}
+	for _, d := range diffs {
+		txn.Conditions = append(txn.Conditions, store.UnchangedCondition(d.Name, current[d.Name]))
+		txn.Operations = append(txn.Operations, d.Operations(prune)...)
+	}
+	if len(txn.Operations) == 0 {
+		return nil
+	}
+	return txn
+}
+
+// Current returns the imported target groups as they are currently stored, along with their
+// metadata, so that the import can be conditioned on their versions.  Groups which don't exist
+// yet are left out.
+func Current(ctx context.Context, ds store.DataStore, groups map[string]*store.TargetGroup) (map[string]*store.TargetGroup, error) {
+	current := map[string]*store.TargetGroup{}
+	for name := range groups {
+		tg, err := ds.GetTargetGroup(ctx, name)
+		if errors.Is(err, store.ErrTargetGroupNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		current[name] = tg
+	}
+	return current, nil
+}
+
+// Import applies the changes of the plan computed from current to the data store, unless dryRun
+// is set, in a single transaction conditioned on current as described by PlanTxn.  Nothing is
+// applied, and store.ErrTxnConditionFailed is returned, when one of the imported target groups
+// was changed since current was read.
+func Import(ctx context.Context, ds store.DataStore, current map[string]*store.TargetGroup, diffs []*store.GroupDiff, prune, dryRun bool) (*Result, error) {
+	res := &Result{DryRun: dryRun, Changes: diffs}
+	if dryRun {
+		return res, nil
+	}
+	if txn := PlanTxn(current, diffs, prune); txn != nil {
+		if _, err := ds.ApplyTxn(ctx, txn); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newGroup returns an empty target group
+func newGroup(name string) *store.TargetGroup {
+	return &store.TargetGroup{Name: name, Targets: []string{}, Labels: map[string]string{}}
+}
@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+)
+
+func TestImportIsConditionedOnCurrent(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	ctx := context.Background()
+	ds, err := store.NewBoltDBDataStore(filepath.Join(t.TempDir(), "store.db"), make(chan bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Shutdown()
+	if err := ds.AddTargetToGroup(ctx, "web", "a:80"); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := map[string]*store.TargetGroup{
+		"web": {Name: "web", Targets: []string{"b:80"}, Labels: map[string]string{"env": "prod"}},
+		"db":  {Name: "db", Targets: []string{"c:80"}, Labels: map[string]string{}},
+	}
+	current, err := Current(ctx, ds, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 1 || current["web"].Metadata == nil {
+		t.Fatalf("got current %v", current)
+	}
+	diffs := Plan(current, imported, true)
+
+	// Once web changes, nothing is applied, not even the creation of db
+	if err := ds.AddTargetToGroup(ctx, "web", "d:80"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(ctx, ds, current, diffs, true, false); !errors.Is(err, store.ErrTxnConditionFailed) {
+		t.Fatalf("got error %v, want %v", err, store.ErrTxnConditionFailed)
+	}
+	if _, err := ds.GetTargetGroup(ctx, "db"); !errors.Is(err, store.ErrTargetGroupNotFound) {
+		t.Errorf("db was created: %v", err)
+	}
+
+	current, err = Current(ctx, ds, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Import(ctx, ds, current, Plan(current, imported, true), true, false); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := ds.GetTargetGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tg := groups["web"]; len(tg.Targets) != 1 || tg.Targets[0] != "b:80" || tg.Labels["env"] != "prod" {
+		t.Errorf("got web %+v", tg)
+	}
+	if tg := groups["db"]; tg == nil || len(tg.Targets) != 1 {
+		t.Errorf("got db %+v", tg)
+	}
+}
@@ -0,0 +1,105 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"gopkg.in/yaml.v2"
+)
+
+// staticConfig is a target group as found in file_sd files and static_configs blocks
+type staticConfig struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+type prometheusConfig struct {
+	ScrapeConfigs []struct {
+		JobName       string         `yaml:"job_name"`
+		StaticConfigs []staticConfig `yaml:"static_configs"`
+	} `yaml:"scrape_configs"`
+}
+
+// FileSD reads the target groups of a Prometheus file_sd file, in JSON or YAML.  A file with
+// a single target group is imported as a group named name, while the groups of a file with
+// several are named name-0, name-1...
+func FileSD(b []byte, format, name string) (map[string]*store.TargetGroup, error) {
+	configs := []staticConfig{}
+	switch format {
+	case "json":
+		if err := json.Unmarshal(b, &configs); err != nil {
+			return nil, fmt.Errorf("Could not parse file_sd file: %s", err)
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(b, &configs); err != nil {
+			return nil, fmt.Errorf("Could not parse file_sd file: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported file_sd format '%s'", format)
+	}
+
+	groups := map[string]*store.TargetGroup{}
+	if err := addStaticConfigs(groups, name, configs); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// StaticConfigs reads the target groups of the static_configs blocks of a prometheus.yml
+// configuration file.  Jobs with a single static config are imported as a group named after
+// the job, while the groups of a job with several are named job-0, job-1...
+func StaticConfigs(b []byte) (map[string]*store.TargetGroup, error) {
+	c := &prometheusConfig{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("Could not parse Prometheus configuration: %s", err)
+	}
+
+	groups := map[string]*store.TargetGroup{}
+	for _, sc := range c.ScrapeConfigs {
+		if len(sc.StaticConfigs) == 0 {
+			continue
+		}
+		if sc.JobName == "" {
+			return nil, fmt.Errorf("Scrape config without a job_name")
+		}
+		if err := addStaticConfigs(groups, sc.JobName, sc.StaticConfigs); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// addStaticConfigs adds the static configs to the groups, naming them after name alone when
+// there's a single one, or after name and their index otherwise
+func addStaticConfigs(groups map[string]*store.TargetGroup, name string, configs []staticConfig) error {
+	name = groupName(name)
+	for i, sc := range configs {
+		groupName := name
+		if len(configs) > 1 {
+			groupName = fmt.Sprintf("%s-%d", name, i)
+		}
+		if _, ok := groups[groupName]; ok {
+			return fmt.Errorf("Target group %s is defined more than once", groupName)
+		}
+
+		tg := newGroup(groupName)
+		for _, t := range sc.Targets {
+			if !lib.Contains(tg.Targets, t) {
+				tg.Targets = append(tg.Targets, t)
+			}
+		}
+		for k, v := range sc.Labels {
+			tg.Labels[k] = v
+		}
+		groups[groupName] = tg
+	}
+	return nil
+}
+
+// groupName turns a job or file name into a target group name which can be used in the API paths
+func groupName(name string) string {
+	return strings.NewReplacer("/", "_", " ", "_").Replace(strings.TrimSpace(name))
+}
@@ -0,0 +1,164 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+func TestFileSD(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		format string
+		file   string
+		want   map[string]*store.TargetGroup
+		err    string
+	}{
+		{
+			name:   "json",
+			format: "json",
+			file:   `[{"targets": ["a:80", "b:80", "a:80"], "labels": {"env": "prod"}}]`,
+			want: map[string]*store.TargetGroup{
+				"web": {Name: "web", Targets: []string{"a:80", "b:80"}, Labels: map[string]string{"env": "prod"}},
+			},
+		},
+		{
+			name:   "yaml",
+			format: "yaml",
+			file: `
+- targets: [a:80]
+  labels:
+    env: prod
+- targets: [b:80]
+`,
+			want: map[string]*store.TargetGroup{
+				"web-0": {Name: "web-0", Targets: []string{"a:80"}, Labels: map[string]string{"env": "prod"}},
+				"web-1": {Name: "web-1", Targets: []string{"b:80"}, Labels: map[string]string{}},
+			},
+		},
+		{
+			name:   "invalid json",
+			format: "json",
+			file:   `{"targets": ["a:80"]}`,
+			err:    "Could not parse file_sd file",
+		},
+		{
+			name:   "unsupported format",
+			format: "toml",
+			file:   `targets = ["a:80"]`,
+			err:    "Unsupported file_sd format 'toml'",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, err := FileSD([]byte(tc.file), tc.format, "web")
+			checkImported(t, groups, err, tc.want, tc.err)
+		})
+	}
+}
+
+func TestStaticConfigs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		want   map[string]*store.TargetGroup
+		err    string
+	}{
+		{
+			name: "single static config",
+			config: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: [a:9100]
+        labels:
+          env: prod
+  - job_name: consul
+    consul_sd_configs:
+      - server: localhost:8500
+`,
+			want: map[string]*store.TargetGroup{
+				"node": {Name: "node", Targets: []string{"a:9100"}, Labels: map[string]string{"env": "prod"}},
+			},
+		},
+		{
+			name: "several static configs",
+			config: `
+scrape_configs:
+  - job_name: blackbox exporter
+    static_configs:
+      - targets: [a:9115]
+      - targets: [b:9115]
+        labels:
+          dc: london
+`,
+			want: map[string]*store.TargetGroup{
+				"blackbox_exporter-0": {Name: "blackbox_exporter-0", Targets: []string{"a:9115"}, Labels: map[string]string{}},
+				"blackbox_exporter-1": {Name: "blackbox_exporter-1", Targets: []string{"b:9115"}, Labels: map[string]string{"dc": "london"}},
+			},
+		},
+		{
+			name: "duplicate names",
+			config: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: [a:9100]
+  - job_name: node
+    static_configs:
+      - targets: [b:9100]
+`,
+			err: "Target group node is defined more than once",
+		},
+		{
+			name: "duplicate indexed names",
+			config: `
+scrape_configs:
+  - job_name: node
+    static_configs:
+      - targets: [a:9100]
+      - targets: [b:9100]
+  - job_name: node-1
+    static_configs:
+      - targets: [c:9100]
+`,
+			err: "Target group node-1 is defined more than once",
+		},
+		{
+			name: "missing job_name",
+			config: `
+scrape_configs:
+  - static_configs:
+      - targets: [a:9100]
+`,
+			err: "Scrape config without a job_name",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, err := StaticConfigs([]byte(tc.config))
+			checkImported(t, groups, err, tc.want, tc.err)
+		})
+	}
+}
+
+// checkImported checks that the imported groups are the expected ones, or that the import
+// failed with an error containing wantErr when it's set
+func checkImported(t *testing.T, groups map[string]*store.TargetGroup, err error, want map[string]*store.TargetGroup, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("got error %v, want %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, want) {
+		for name, tg := range groups {
+			t.Logf("%s: %+v", name, tg)
+		}
+		t.Errorf("got %d groups, want %v", len(groups), want)
+	}
+}
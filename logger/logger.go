@@ -1,17 +1,73 @@
 package logger
 
 import (
+	"os"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var Logger *zap.Logger
 
-// func init() {
-// 	Logger, err := zap.NewDevelopment() // or NewExample, NewProduction, or NewDevelopment
-// 	if err != nil {
-// 		fmt.Errorf("Could not initialize logger: %v", err)
-// 		os.Exit(1)
-// 	}
-// 	defer Logger.Sync()
+// Level is the level of Logger, which can be changed while the server is running
+var Level = zap.NewAtomicLevel()
+
+// SetLevel changes the level of Logger to the given level name (debug, info, warn, error...)
+func SetLevel(level string) error {
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	Level.SetLevel(lvl)
+	return nil
+}
+
+// New builds a logger from the logging configuration, with its level controlled by Level.  In
+// debug mode, the level is set to debug and entries are written to stderr in a human readable
+// format, without sampling.
+func New(c *config.LoggingConfig, debug bool) (*zap.Logger, error) {
+	if debug {
+		Level.SetLevel(zapcore.DebugLevel)
+		enc := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+		core := zapcore.NewCore(enc, zapcore.Lock(os.Stderr), Level)
+		return zap.New(core, zap.Development(), zap.AddCaller(), zap.AddStacktrace(zapcore.WarnLevel)), nil
+	}
+
+	if err := SetLevel(c.Level); err != nil {
+		return nil, err
+	}
+
+	encConf := zap.NewProductionEncoderConfig()
+	var enc zapcore.Encoder
+	if c.Encoding == "console" {
+		encConf.EncodeTime = zapcore.ISO8601TimeEncoder
+		enc = zapcore.NewConsoleEncoder(encConf)
+	} else {
+		enc = zapcore.NewJSONEncoder(encConf)
+	}
+
+	var out zapcore.WriteSyncer
+	switch c.Output {
+	case "stdout":
+		out = zapcore.Lock(os.Stdout)
+	case "file":
+		out = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   c.File.Path,
+			MaxSize:    c.File.MaxSize,
+			MaxBackups: c.File.MaxBackups,
+			MaxAge:     c.File.MaxAge,
+			Compress:   c.File.Compress,
+		})
+	default:
+		out = zapcore.Lock(os.Stderr)
+	}
 
-// }
+	core := zapcore.NewCore(enc, out, Level)
+	if c.Sampling.Initial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, c.Sampling.Initial, c.Sampling.Thereafter)
+	}
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,20 +13,29 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/hartfordfive/prom-http-sd-server/accesslog"
+	"github.com/hartfordfive/prom-http-sd-server/audit"
+	"github.com/hartfordfive/prom-http-sd-server/auth"
 	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/filesd"
+	"github.com/hartfordfive/prom-http-sd-server/grpcapi"
 	"github.com/hartfordfive/prom-http-sd-server/handler"
 	"github.com/hartfordfive/prom-http-sd-server/lib"
 	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/ratelimit"
 	"github.com/hartfordfive/prom-http-sd-server/store"
+	"github.com/hartfordfive/prom-http-sd-server/tracing"
+	"github.com/hartfordfive/prom-http-sd-server/ui"
 	"github.com/hartfordfive/prom-http-sd-server/version"
+	"github.com/hartfordfive/prom-http-sd-server/webhook"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-var dataStore store.DataStore
-
 var (
 	metricHttpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "httpsdserver_req_duration_seconds",
@@ -32,14 +43,20 @@ var (
 	}, []string{"path"})
 )
 
+// watchHistorySize is the number of events kept so that watchers can resume from a revision
+const watchHistorySize = 10000
+
 var (
 	flagConfPath  *string
 	flagDebug     *bool
 	flagVersion   *bool
+	flagOverrides *config.FlagOverrides
 	log           *zap.Logger
 	conf          *config.Config
+	tlsCerts      *certReloader
 	shutdownChan  chan bool
 	interruptChan chan os.Signal
+	reloadChan    chan bool
 )
 
 func init() {
@@ -47,8 +64,10 @@ func init() {
 	flagConfPath = flag.String(
 		"conf",
 		"/etc/prom-http-sd-server/prom-http-sd-server.conf",
-		"When using local storage, path to storage dir.",
+		"Path to the configuration file.  Set to an empty string to only use environment variables and flags.",
 	)
+	flag.StringVar(flagConfPath, "conf-path", *flagConfPath, "Alias of -conf")
+	flagOverrides = config.RegisterFlags(flag.CommandLine)
 	flagVersion = flag.Bool("version", false, "Show version and exit")
 	flagDebug = flag.Bool("debug", false, "Enable debug mode")
 	flag.Parse()
@@ -56,12 +75,14 @@ func init() {
 	var log *zap.Logger
 	var loggerErr error
 
-	log, loggerErr = zap.NewProduction() // or NewExample, NewProduction, or NewDevelopment
-
+	logConf := zap.NewProductionConfig()
 	if *flagDebug {
 		// If we're in debug mode, then create a dev logger instead
-		log, loggerErr = zap.NewDevelopment() // or NewExample, NewProduction, or NewDevelopment
+		logConf = zap.NewDevelopmentConfig()
 	}
+	logger.Level.SetLevel(logConf.Level.Level())
+	logConf.Level = logger.Level
+	log, loggerErr = logConf.Build()
 	if loggerErr != nil {
 		fmt.Printf("Could not initialize logger: %s\n", loggerErr)
 		os.Exit(1)
@@ -75,41 +96,127 @@ func init() {
 		os.Exit(0)
 	}
 
-	if !lib.FileExists(*flagConfPath) {
-		logger.Logger.Error(fmt.Sprintf("Error: Configuration '%s' not found\n", *flagConfPath))
-		os.Exit(1)
-	}
-
 	interruptChan = make(chan os.Signal, 1)
 	shutdownChan = make(chan bool, 1)
-	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+	reloadChan = make(chan bool, 1)
+	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+}
+
+// loadConfig loads the configuration file specified by the -conf flag, exiting if it can't be loaded.
+func loadConfig() {
+	if *flagConfPath != "" && !lib.FileExists(*flagConfPath) {
+		logger.Logger.Error("Configuration not found", zap.String("config_path", *flagConfPath))
+		os.Exit(1)
+	}
 
-	cnf, err := config.NewConfig(*flagConfPath)
+	cnf, err := newConfig()
 	if err != nil {
-		logger.Logger.Error(fmt.Sprintf("%s", err))
+		if verr, ok := err.(*config.ValidationError); ok {
+			for _, fe := range verr.Errors {
+				logger.Logger.Error("Invalid configuration",
+					zap.String("field", fe.Field),
+					zap.String("error", fe.Message),
+				)
+			}
+		} else {
+			logger.Logger.Error("Could not load configuration", zap.Error(err))
+		}
 		os.Exit(1)
 	}
 	conf = cnf
 }
 
+// newConfig loads the configuration file and applies the overrides, with command line flags
+// taking precedence over environment variables, which take precedence over the file.
+func newConfig() (*config.Config, error) {
+	return config.NewConfig(*flagConfPath, config.EnvOverrides(), flagOverrides.Overrides())
+}
+
 // initDataStore creates a DataStore of any type supported by conf.StoreType
 // Once, created the datastore is properly initialised.
 // Finally, the store.StoreInstance gloabl variable is set to the newly created DataStore instance.
-func initDataStore(storeType string) (err error) {
-	switch storeType {
+func initDataStore(storeType string) error {
+	ds, err := newDataStore(conf, shutdownChan)
+	if err != nil {
+		return err
+	}
+
+	// Backends such as BoltDB export their own statistics
+	if collector, ok := ds.(prometheus.Collector); ok {
+		prometheus.MustRegister(collector)
+	}
+
+	instrumented := store.NewInstrumentedStore(ds, storeType)
+	prometheus.MustRegister(instrumented)
+	store.StoreInstance = instrumented
+	return nil
+}
+
+// newDataStore creates a DataStore of the type specified by cnf.StoreType
+func newDataStore(cnf *config.Config, shutdown chan bool) (ds store.DataStore, err error) {
+	switch cnf.StoreType {
 	case "local":
-		store.StoreInstance, err = store.NewBoltDBDataStore(conf.LocalDBConfig.TargetStorePath, shutdownChan)
+		ds, err = store.NewBoltDBDataStore(cnf.LocalDBConfig.TargetStorePath, shutdown)
 
 	case "consul":
-		store.StoreInstance, err = store.NewConsulDataStore(conf.ConsulConfig.Host, conf.ConsulConfig.AllowStale, shutdownChan)
+		ds, err = store.NewConsulDataStore(cnf.ConsulConfig.Host, cnf.ConsulConfig.AllowStale, shutdown)
 
 	default:
-		err = fmt.Errorf("%s data store not implemented.", conf.StoreType)
+		err = fmt.Errorf("%s data store not implemented.", cnf.StoreType)
 	}
 
 	return
 }
 
+// startWebhooks starts delivering the changes of the target groups to the webhook endpoints,
+// until the context is done.  The returned function waits for the deliveries in progress.
+func startWebhooks(ctx context.Context) (func(), error) {
+	if !conf.Webhooks.Enabled() {
+		return func() {}, nil
+	}
+	dispatcher, err := webhook.NewDispatcher(conf.Webhooks.OutboxPath)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Run(ctx, store.WatcherInstance)
+	}()
+	return func() {
+		<-done
+		dispatcher.Close()
+	}, nil
+}
+
+// startGRPCServer serves the gRPC API on its own port, with the TLS certificate of the REST API.
+// It returns nil when the gRPC API is disabled.
+func startGRPCServer() *grpc.Server {
+	if !conf.GRPC.Enabled() {
+		return nil
+	}
+
+	var opts []grpc.ServerOption
+	if conf.TLS.Enabled() {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: tlsCerts.GetCertificate})))
+	}
+	srv := grpcapi.NewServer(conf.GRPC, opts...)
+
+	listenAddr := fmt.Sprintf("%s:%d", conf.Host, conf.GRPC.Port)
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		logger.Logger.Fatal("Error starting gRPC server", zap.Error(err))
+	}
+	logger.Logger.Info("gRPC API is now ready for connections", zap.String("address", listenAddr))
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			logger.Logger.Fatal("Error starting gRPC server", zap.Error(err))
+		}
+	}()
+	return srv
+}
+
 // prometheusMiddleware implements mux.MiddlewareFunc.
 func prometheusMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -123,6 +230,20 @@ func prometheusMiddleware(next http.Handler) http.Handler {
 
 func main() {
 
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Arg(0), flag.Args()[1:]))
+	}
+
+	loadConfig()
+
+	log, err := logger.New(conf.Logging, *flagDebug)
+	if err != nil {
+		logger.Logger.Error("Could not initialize logger", zap.Error(err))
+		os.Exit(1)
+	}
+	logger.Logger = log
+	defer logger.Logger.Sync()
+
 	logger.Logger.Info("Starting prom-http-sd-server")
 
 	// Should probably be changed too, we want to know about data store inits
@@ -132,29 +253,99 @@ func main() {
 		)
 	}
 
-	config.GlobalConfig = conf
+	config.SetCurrent(conf)
+
+	shutdownTracing, err := tracing.Init(conf.Tracing)
+	if err != nil {
+		logger.Logger.Error(err.Error())
+		os.Exit(1)
+	}
 
 	// Init datastore
-	logger.Logger.Sugar().Infof("Starting datastore (type: %s)...", conf.StoreType)
-	err := initDataStore(conf.StoreType)
+	logger.Logger.Info("Starting datastore", zap.String("store_type", conf.StoreType))
+	err = initDataStore(conf.StoreType)
+	if err != nil {
+		logger.Logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	watchCtx, stopWatcher := context.WithCancel(context.Background())
+	store.WatcherInstance = store.NewWatcher(store.StoreInstance, watchHistorySize)
+	go store.WatcherInstance.Run(watchCtx)
+
+	go filesd.Run(watchCtx, store.StoreInstance)
+
+	stopWebhooks, err := startWebhooks(watchCtx)
 	if err != nil {
 		logger.Logger.Error(err.Error())
 		os.Exit(1)
 	}
 
+	if conf.Audit.Persistent() {
+		audit.DefaultLog, err = audit.OpenLog(conf.Audit.Path, conf.Audit.MaxEntries)
+		if err != nil {
+			logger.Logger.Error(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		audit.DefaultLog = audit.NewLog(conf.Audit.MaxEntries)
+	}
+
 	// Init web server
 	r := mux.NewRouter()
-	r.HandleFunc("/api/target/{targetGroup}/{target}", handler.AddTargetHandler).Methods("POST")
-	r.HandleFunc("/api/target/{targetGroup}/{target}", handler.RemoveTargetHandler).Methods("DELETE")
-	r.HandleFunc("/api/target/{targetGroup}", handler.RemoveTargetGroupHandler).Methods("DELETE")
-	r.HandleFunc("/api/labels/{targetGroup}", handler.GetTargetGroupLabelsHandler).Methods("GET")
-	r.HandleFunc("/api/labels/update/{targetGroup}", handler.AddTargetGroupLabelsHandler).Methods("POST")
-	r.HandleFunc("/api/labels/update/{targetGroup}/{label}", handler.RemoveTargetGroupLabelHandler).Methods("DELETE")
+	r.Use(accesslog.Middleware)
+	r.Use(tracing.Middleware)
+	r.Use(prometheusMiddleware)
+	r.Use(auth.Middleware)
+	r.Use(ratelimit.Middleware)
+	r.Use(audit.Middleware)
+	r.NotFoundHandler = handler.NotFoundHandler
+	r.MethodNotAllowedHandler = handler.MethodNotAllowedHandler
+
+	// v1 routes, deprecated in favour of /api/v2
+	r.HandleFunc("/api/target/{targetGroup}/{target}", handler.Deprecated(handler.IfMatch(handler.AddTargetHandler))).Methods("POST")
+	r.HandleFunc("/api/target/{targetGroup}/{target}", handler.Deprecated(handler.IfMatch(handler.RemoveTargetHandler))).Methods("DELETE")
+	r.HandleFunc("/api/target/{targetGroup}", handler.Deprecated(handler.IfMatch(handler.RemoveTargetGroupHandler))).Methods("DELETE")
+	r.HandleFunc("/api/labels/{targetGroup}", handler.Deprecated(handler.GetTargetGroupLabelsHandler)).Methods("GET")
+	r.HandleFunc("/api/labels/update/{targetGroup}", handler.Deprecated(handler.IfMatch(handler.AddTargetGroupLabelsHandler))).Methods("POST")
+	r.HandleFunc("/api/labels/update/{targetGroup}/{label}", handler.Deprecated(handler.IfMatch(handler.RemoveTargetGroupLabelHandler))).Methods("DELETE")
+
+	v2 := r.PathPrefix("/api/v2").Subrouter()
+	v2.HandleFunc("/groups", handler.V2ListGroupsHandler).Methods("GET")
+	v2.HandleFunc("/groups/{group}", handler.V2GetGroupHandler).Methods("GET")
+	v2.HandleFunc("/groups/{group}", handler.IfMatch(handler.V2PutGroupHandler)).Methods("PUT")
+	v2.HandleFunc("/groups/{group}", handler.IfMatch(handler.V2DeleteGroupHandler)).Methods("DELETE")
+	v2.HandleFunc("/groups/{group}/rename", handler.IfMatch(handler.V2RenameGroupHandler)).Methods("POST")
+	v2.HandleFunc("/groups/{group}/copy", handler.IfMatch(handler.V2CopyGroupHandler)).Methods("POST")
+	v2.HandleFunc("/groups/{group}/merge", handler.IfMatch(handler.V2MergeGroupHandler)).Methods("POST")
+	v2.HandleFunc("/groups/{group}/targets", handler.V2GetTargetsHandler).Methods("GET")
+	v2.HandleFunc("/groups/{group}/targets", handler.IfMatch(handler.V2AddTargetsHandler)).Methods("POST")
+	v2.HandleFunc("/groups/{group}/targets/{target}", handler.IfMatch(handler.V2RemoveTargetHandler)).Methods("DELETE")
+	v2.HandleFunc("/groups/{group}/labels", handler.V2GetLabelsHandler).Methods("GET")
+	v2.HandleFunc("/groups/{group}/labels", handler.IfMatch(handler.V2PatchLabelsHandler)).Methods("PATCH")
+	v2.HandleFunc("/groups/{group}/labels/{label}", handler.IfMatch(handler.V2RemoveLabelHandler)).Methods("DELETE")
+	v2.NotFoundHandler = handler.NotFoundHandler
+	v2.MethodNotAllowedHandler = handler.MethodNotAllowedHandler
+
+	r.HandleFunc("/api/openapi.json", handler.OpenAPIHandler).Methods("GET")
+	r.HandleFunc("/api/import/file_sd", handler.ImportFileSDHandler).Methods("POST")
+	r.HandleFunc("/api/import/static_configs", handler.ImportStaticConfigsHandler).Methods("POST")
+	r.HandleFunc("/api/import/csv", handler.ImportCSVHandler).Methods("POST")
+	r.HandleFunc("/api/import/ansible", handler.ImportAnsibleHandler).Methods("POST")
+	r.HandleFunc("/api/txn", handler.TxnHandler).Methods("POST")
+	r.HandleFunc("/api/groups", handler.GroupsHandler).Methods("GET")
+	r.HandleFunc("/api/groups/{name}", handler.GroupHandler).Methods("GET")
 	r.HandleFunc("/api/targets", handler.ShowTargetsHandler).Methods("GET")
+	r.HandleFunc("/api/targets/{endpoint}", handler.ShowTargetsHandler).Methods("GET")
+	r.HandleFunc("/api/watch", handler.WatchHandler).Methods("GET")
+	r.Handle("/api/audit", auth.RequireAdmin(http.HandlerFunc(handler.AuditHandler))).Methods("GET")
 	r.HandleFunc("/debug_targets", handler.ShowDebugTargetsHandler).Methods("GET")
 	r.HandleFunc("/debug_config", handler.ShowDebugConfigHandler).Methods("GET")
+	r.Handle("/api/admin/log_level", auth.RequireAdmin(logger.Level)).Methods("GET", "PUT")
 	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	r.HandleFunc("/health", handler.HealthHandler).Methods("GET")
+	r.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently)).Methods("GET")
+	r.PathPrefix("/ui/").Handler(ui.Handler("/ui/")).Methods("GET", "HEAD")
 
 	listenAddr := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
 	logger.Logger.Info("prom-http-sd-server is now ready for connections",
@@ -168,22 +359,65 @@ func main() {
 		ReadTimeout:  10 * time.Second,
 	}
 
+	if conf.TLS.Enabled() {
+		tlsCerts, err = newCertReloader(conf.TLS.CertFile, conf.TLS.KeyFile)
+		if err != nil {
+			logger.Logger.Error("Could not load TLS certificate", zap.Error(err))
+			os.Exit(1)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: tlsCerts.GetCertificate}
+	}
+
 	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			logger.Logger.Fatal(fmt.Sprintf("Error starting server: %v", err))
+		var err error
+		if conf.TLS.Enabled() {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			logger.Logger.Fatal("Error starting server", zap.Error(err))
 		}
 	}()
 
-	killSig := <-interruptChan
-	switch killSig {
-	case os.Interrupt, syscall.SIGTERM:
-		close(shutdownChan)
+	grpcSrv := startGRPCServer()
+
+	if conf.Reload.WatchFile {
+		go watchConfigFile(conf.Reload.Interval, shutdownChan)
 	}
 
-	dataStore.Shutdown()
+	for running := true; running; {
+		select {
+		case killSig := <-interruptChan:
+			switch killSig {
+			case syscall.SIGHUP:
+				reloadConfig()
+			case os.Interrupt, syscall.SIGTERM:
+				close(shutdownChan)
+				running = false
+			}
+		case <-reloadChan:
+			reloadConfig()
+		}
+	}
 
+	// Ends the watch streams, which would otherwise prevent the servers from shutting down
+	stopWatcher()
+
+	// The requests in progress complete before the data store is closed
 	if err := srv.Shutdown(context.TODO()); err != nil {
 		panic(err)
 	}
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	stopWebhooks()
+	store.StoreInstance.Shutdown()
+
+	// Closed once the requests in progress have been recorded
+	audit.DefaultLog.Close()
+	if err := shutdownTracing(context.TODO()); err != nil {
+		logger.Logger.Error("Could not flush traces", zap.Error(err))
+	}
 	logger.Logger.Info("prom-http-sd-server shutdown complete")
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// runMigrate copies every target group from the data store described by the -from
+// configuration into the data store described by the -to configuration.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fromConf := fs.String("from", "", "Path to the configuration file of the source data store")
+	toConf := fs.String("to", "", "Path to the configuration file of the destination data store")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes which would be applied to the destination data store")
+	prune := fs.Bool("prune", false, "Remove target groups, targets and labels from the destination which don't exist in the source")
+	verify := fs.Bool("verify", true, "Verify the destination data store matches the source once the migration is complete")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *fromConf == "" || *toConf == "" {
+		fmt.Fprintln(os.Stderr, "Both -from and -to must be specified")
+		fs.Usage()
+		return 2
+	}
+
+	shutdown := make(chan bool)
+	defer close(shutdown)
+
+	src, err := openMigrationStore(*fromConf, shutdown)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open source data store: %s\n", err)
+		return 1
+	}
+	defer src.Shutdown()
+
+	dst, err := openMigrationStore(*toConf, shutdown)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open destination data store: %s\n", err)
+		return 1
+	}
+	defer dst.Shutdown()
+
+	ctx := context.Background()
+	srcGroups, err := src.GetTargetGroups(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read target groups from source data store: %s\n", err)
+		return 1
+	}
+	dstGroups, err := dst.GetTargetGroups(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read target groups from destination data store: %s\n", err)
+		return 1
+	}
+
+	diffs := migrationDiffs(dstGroups, srcGroups, *prune)
+	fmt.Printf("Found %d target groups in source, %d require changes in destination\n", len(srcGroups), len(diffs))
+
+	if *dryRun {
+		for _, d := range diffs {
+			fmt.Print(d.String())
+		}
+		return 0
+	}
+
+	// The groups which only exist in the destination are deleted last, in a single transaction
+	// since they may be the templates of each other
+	deletes := &store.Txn{}
+	for _, d := range diffs {
+		if d.Deleted {
+			deletes.Operations = append(deletes.Operations, d.Operations(*prune)...)
+			continue
+		}
+		if err := store.ApplyGroupDiff(ctx, dst, d, *prune); err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %s\n", err)
+			return 1
+		}
+		fmt.Printf("Migrated target group %s\n", d.Name)
+	}
+	if len(deletes.Operations) > 0 {
+		if _, err := dst.ApplyTxn(ctx, deletes); err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed, could not delete target groups: %s\n", err)
+			return 1
+		}
+		for _, op := range deletes.Operations {
+			fmt.Printf("Deleted target group %s\n", op.Group)
+		}
+	}
+
+	if !*verify {
+		return 0
+	}
+
+	dstGroups, err = dst.GetTargetGroups(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read target groups from destination data store for verification: %s\n", err)
+		return 1
+	}
+	if remaining := migrationDiffs(dstGroups, srcGroups, *prune); len(remaining) > 0 {
+		fmt.Fprintf(os.Stderr, "Verification failed, %d target groups differ between source and destination:\n", len(remaining))
+		for _, d := range remaining {
+			fmt.Fprint(os.Stderr, d.String())
+		}
+		return 1
+	}
+	fmt.Println("Verification successful, destination data store matches the source")
+	return 0
+}
+
+// openMigrationStore loads the configuration at confPath and opens the data store it describes.
+func openMigrationStore(confPath string, shutdown chan bool) (store.DataStore, error) {
+	cnf, err := config.NewConfig(confPath)
+	if err != nil {
+		return nil, err
+	}
+	return newDataStore(cnf, shutdown)
+}
+
+// migrationDiffs returns the changes required for the destination to contain the source
+// target groups, including those without targets or labels.  Unless prune is set, removals and
+// the deletion of the groups which only exist in the destination are discarded as they won't be
+// applied.
+func migrationDiffs(dst, src map[string]*store.TargetGroup, prune bool) []*store.GroupDiff {
+	diffs := []*store.GroupDiff{}
+	for _, d := range store.DiffTargetGroups(dst, src) {
+		if !prune {
+			d.RemovedTargets = nil
+			d.RemovedLabels = nil
+			d.RemovedTemplates = nil
+			d.Deleted = false
+		}
+		if !d.Empty() {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,237 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/auth"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// Change is a change of the labels of a target group, checked against the label policy
+type Change struct {
+	Group string
+	// Created is set when the change creates the group
+	Created bool
+	// Labels are the effective labels of the group after the change, including those it inherits
+	// from its templates, and Previous those before it, nil when the change creates the group.
+	// They're set by SetLabels when the policy requires labels.
+	Labels   map[string]string
+	Previous map[string]string
+	// Set are the labels set by the change, and Removed the names of the labels it removes
+	Set     map[string]string
+	Removed []string
+	// Conditions are the states of the target groups read to compute the change, which the change
+	// must be conditioned on for the check to still hold when it's applied
+	Conditions []*store.TxnCondition
+}
+
+// Error lists the violations of the label policy by some changes
+type Error struct {
+	Violations []string
+	// PermissionDenied is set when a change is only allowed to admin tokens
+	PermissionDenied bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Label policy violation: %s", strings.Join(e.Violations, "; "))
+}
+
+// NewChange returns the change setting and removing labels of the target group.  When the
+// policy requires labels, the group is read from the data store to know whether the change
+// creates it, if creates tells that it creates the group when it doesn't exist, and which
+// labels it has before and after the change, and the change is conditioned on the group being
+// unchanged since.
+func NewChange(ctx context.Context, ds store.DataStore, group string, set map[string]string, removed []string, creates bool) (*Change, error) {
+	c := &Change{Group: group, Set: set, Removed: removed}
+	if len(config.Current().LabelPolicy.Required) == 0 {
+		return c, nil
+	}
+	tg, err := ds.GetTargetGroup(ctx, group)
+	if errors.Is(err, store.ErrTargetGroupNotFound) {
+		if !creates {
+			// The change fails since the group doesn't exist
+			return c, nil
+		}
+		c.Created = true
+		tg, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]*store.TargetGroup{}
+	if tg != nil {
+		before[group] = tg
+	}
+	after := map[string]*store.TargetGroup{group: Changed(group, tg, set, removed)}
+	if err := c.SetLabels(ctx, ds, before, after); err != nil {
+		return nil, err
+	}
+	c.Conditions = []*store.TxnCondition{store.UnchangedCondition(group, tg)}
+	return c, nil
+}
+
+// Changed returns a copy of the target group, or of an empty group when tg is nil, with the
+// labels set and removed
+func Changed(name string, tg *store.TargetGroup, set map[string]string, removed []string) *store.TargetGroup {
+	res := &store.TargetGroup{Name: name, Labels: map[string]string{}}
+	if tg != nil {
+		res.Targets, res.Templates, res.Labels = tg.Targets, tg.Templates, maps.Clone(tg.Labels)
+		if res.Labels == nil {
+			res.Labels = map[string]string{}
+		}
+	}
+	maps.Copy(res.Labels, set)
+	for _, k := range removed {
+		delete(res.Labels, k)
+	}
+	return res
+}
+
+// SetLabels sets Labels and Previous, the effective labels of the group after and before the
+// change, from the target groups after and before it, keyed by name, which don't include the
+// group when it doesn't exist.  The templates missing from the groups are read from the data
+// store.  It does nothing when the policy doesn't require labels.
+func (c *Change) SetLabels(ctx context.Context, ds store.DataStore, before, after map[string]*store.TargetGroup) error {
+	if len(config.Current().LabelPolicy.Required) == 0 {
+		return nil
+	}
+	var err error
+	if c.Previous, err = effectiveLabels(ctx, ds, before, c.Group); err != nil {
+		return err
+	}
+	c.Labels, err = effectiveLabels(ctx, ds, after, c.Group)
+	return err
+}
+
+// effectiveLabels returns the effective labels of the named group of groups, reading the
+// templates missing from groups from the data store, or nil when the group doesn't exist
+func effectiveLabels(ctx context.Context, ds store.DataStore, groups map[string]*store.TargetGroup, name string) (map[string]string, error) {
+	if groups[name] == nil {
+		return nil, nil
+	}
+	named := []*store.TargetGroup{}
+	for n, tg := range groups {
+		if tg != nil {
+			c := *tg
+			c.Name = n
+			named = append(named, &c)
+		}
+	}
+	all, err := store.LoadTemplates(ctx, ds, named...)
+	if err != nil {
+		return nil, err
+	}
+	labels, _ := store.EffectiveLabels(all, name)
+	return labels, nil
+}
+
+// Conditions returns the conditions of the changes, to be set on the context of the data store
+// methods applying them with store.WithConditions
+func Conditions(changes ...*Change) []*store.TxnCondition {
+	conditions := []*store.TxnCondition{}
+	for _, c := range changes {
+		conditions = append(conditions, c.Conditions...)
+	}
+	return conditions
+}
+
+// Check returns an *Error when the changes violate the label policy of the current
+// configuration.  The labels of the admin_only rules can only be set and removed by the admin
+// identity of ctx, or by anyone when authentication is disabled.
+func Check(ctx context.Context, changes ...*Change) error {
+	conf := config.Current()
+	pol := conf.LabelPolicy
+	admin := !conf.Auth.Enabled()
+	if id := auth.FromContext(ctx); id != nil && id.Admin {
+		admin = true
+	}
+
+	e := &Error{}
+	for _, c := range changes {
+		for _, k := range sortedKeys(c.Set) {
+			r := pol.Rule(k)
+			switch {
+			case r == nil:
+			case r.Forbidden:
+				e.Violations = append(e.Violations, fmt.Sprintf("Label %s of target group %s is forbidden", k, c.Group))
+			case r.AdminOnly && !admin:
+				e.Violations = append(e.Violations, fmt.Sprintf("Label %s of target group %s can only be set by admin tokens", k, c.Group))
+				e.PermissionDenied = true
+			case !r.AllowsValue(c.Set[k]):
+				e.Violations = append(e.Violations, fmt.Sprintf("Value '%s' of label %s of target group %s isn't allowed%s", c.Set[k], k, c.Group, allowedValues(r)))
+			}
+		}
+		for _, k := range c.Removed {
+			if r := pol.Rule(k); r != nil && r.AdminOnly && !admin {
+				e.Violations = append(e.Violations, fmt.Sprintf("Label %s of target group %s can only be removed by admin tokens", k, c.Group))
+				e.PermissionDenied = true
+			}
+		}
+		e.Violations = append(e.Violations, requiredViolations(c, pol.Required)...)
+	}
+	if len(e.Violations) > 0 {
+		return e
+	}
+	return nil
+}
+
+// requiredViolations returns the violations of the required labels by the change: the group must
+// be created with them and can't lose them afterwards, whether they're its own labels or
+// inherited from its templates.  When the effective labels of the change aren't known, only
+// the labels it removes are checked.
+func requiredViolations(c *Change, required []string) []string {
+	missing := []string{}
+	for _, k := range required {
+		if _, ok := c.Labels[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	violations := []string{}
+	switch {
+	case len(missing) == 0:
+	case c.Created && len(c.Labels) == 0:
+		// Adding a target to a group which doesn't exist yet doesn't set any label
+		violations = append(violations, fmt.Sprintf("Target group %s doesn't exist and can't be created without the required labels %s, create it with its labels first",
+			c.Group, strings.Join(missing, ", ")))
+	case c.Created:
+		for _, k := range missing {
+			violations = append(violations, fmt.Sprintf("Target group %s must be created with label %s", c.Group, k))
+		}
+	default:
+		for _, k := range missing {
+			_, had := c.Previous[k]
+			if (c.Labels == nil && lib.Contains(c.Removed, k)) || (c.Labels != nil && had) {
+				violations = append(violations, fmt.Sprintf("Label %s of target group %s is required and can't be removed", k, c.Group))
+			}
+		}
+	}
+	return violations
+}
+
+// allowedValues describes the values allowed by the rule
+func allowedValues(r *config.LabelRule) string {
+	parts := []string{}
+	if len(r.Values) > 0 {
+		parts = append(parts, "be one of "+strings.Join(r.Values, ", "))
+	}
+	if r.ValueRegex != "" {
+		parts = append(parts, "match "+r.ValueRegex)
+	}
+	return ", must " + strings.Join(parts, " and ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/auth"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+)
+
+// sweepInterval is how often the buckets of the callers which are back to a full burst are removed
+const sweepInterval = time.Minute
+
+// bucket is the token bucket of a caller
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter limits the rate of the requests of every caller with a token bucket.  The rate and the
+// burst are passed on every call, so that they can change while the buckets are kept.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter returns a limiter without any caller
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// Allow takes a token from the bucket of the caller, returning false along with the time until
+// the next token when there's none left
+func (l *Limiter) Allow(key string, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now, rate, burst)
+	}
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets which have refilled, as they're the same as new ones
+func (l *Limiter) sweep(now time.Time, rate float64, burst int) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// DefaultLimiter is the limiter used by Middleware
+var DefaultLimiter = NewLimiter()
+
+// callerKey identifies the caller of the request by the name of its auth token, or else by its
+// IP address
+func callerKey(r *http.Request) string {
+	if id := auth.RequestIdentity(r); id != nil {
+		return "token:" + id.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Middleware implements mux.MiddlewareFunc.  It rejects the requests of the callers exceeding the
+// rate_limit settings of the current configuration with a 429 status and a Retry-After header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitConf := config.Current().RateLimit
+		if !limitConf.Enabled() || limitConf.Excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, wait := DefaultLimiter.Allow(callerKey(r), limitConf.RequestsPerSecond, limitConf.BurstSize())
+		if !allowed {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "ERROR: Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,166 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+)
+
+// AddressLabel holds the target while its labels are relabeled, like in Prometheus
+const AddressLabel = "__address__"
+
+// Process applies the relabel configs in order to the labels, which are left unchanged, and
+// returns the resulting labels, or nil when a keep or drop action drops them.  As in
+// Prometheus, the labels with an empty value are removed.
+func Process(labels map[string]string, configs []config.RelabelConfig) map[string]string {
+	res := make(map[string]string, len(labels))
+	for k, v := range labels {
+		res[k] = v
+	}
+	for i := range configs {
+		if !apply(res, &configs[i]) {
+			return nil
+		}
+	}
+	for k, v := range res {
+		if v == "" {
+			delete(res, k)
+		}
+	}
+	return res
+}
+
+// apply applies the relabel config to the labels, returning false when they're dropped
+func apply(labels map[string]string, c *config.RelabelConfig) bool {
+	re := c.CompiledRegex()
+	values := make([]string, 0, len(c.SourceLabels))
+	for _, l := range c.SourceLabels {
+		values = append(values, labels[l])
+	}
+	val := strings.Join(values, c.Separator)
+
+	switch c.Action {
+	case config.RelabelKeep:
+		return re.MatchString(val)
+	case config.RelabelDrop:
+		return !re.MatchString(val)
+	case config.RelabelKeepEqual:
+		return labels[c.TargetLabel] == val
+	case config.RelabelDropEqual:
+		return labels[c.TargetLabel] != val
+	case config.RelabelReplace:
+		indexes := re.FindStringSubmatchIndex(val)
+		if indexes == nil {
+			break
+		}
+		target := string(re.ExpandString([]byte{}, c.TargetLabel, val, indexes))
+		if !lib.IsValidLabelName(target) {
+			break
+		}
+		res := string(re.ExpandString([]byte{}, c.Replacement, val, indexes))
+		if res == "" {
+			delete(labels, target)
+			break
+		}
+		labels[target] = res
+	case config.RelabelLowercase:
+		labels[c.TargetLabel] = strings.ToLower(val)
+	case config.RelabelUppercase:
+		labels[c.TargetLabel] = strings.ToUpper(val)
+	case config.RelabelHashMod:
+		sum := md5.Sum([]byte(val))
+		labels[c.TargetLabel] = fmt.Sprintf("%d", binary.BigEndian.Uint64(sum[8:])%c.Modulus)
+	case config.RelabelLabelMap:
+		// The labels are mapped from their names before the action, in order
+		mapped := map[string]string{}
+		for _, name := range sortedNames(labels) {
+			if re.MatchString(name) {
+				mapped[re.ReplaceAllString(name, c.Replacement)] = labels[name]
+			}
+		}
+		for k, v := range mapped {
+			labels[k] = v
+		}
+	case config.RelabelLabelDrop, config.RelabelLabelKeep:
+		for _, name := range sortedNames(labels) {
+			if re.MatchString(name) == (c.Action == config.RelabelLabelDrop) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
+
+// TargetGroups applies the relabel configs to every target of the target groups, as Prometheus
+// does, with the target in the __address__ label.  The targets which are dropped, or left
+// without an address, are removed.  The targets of a group which end up with different labels
+// are split into several groups, keyed by the name of the group followed by a number.  Without
+// relabel configs, the target groups are returned unchanged.
+func TargetGroups(groups map[string]*store.TargetGroup, configs []config.RelabelConfig) map[string]*store.TargetGroup {
+	if len(configs) == 0 {
+		return groups
+	}
+	res := map[string]*store.TargetGroup{}
+	for name, tg := range groups {
+		split := []*store.TargetGroup{}
+		for _, t := range tg.Targets {
+			labels := map[string]string{}
+			for k, v := range tg.Labels {
+				labels[k] = v
+			}
+			labels[AddressLabel] = t
+			labels = Process(labels, configs)
+			if labels == nil || labels[AddressLabel] == "" {
+				continue
+			}
+			target := labels[AddressLabel]
+			delete(labels, AddressLabel)
+
+			var g *store.TargetGroup
+			for _, s := range split {
+				if equalLabels(s.Labels, labels) {
+					g = s
+					break
+				}
+			}
+			if g == nil {
+				g = &store.TargetGroup{Name: name, Labels: labels}
+				split = append(split, g)
+			}
+			if !lib.Contains(g.Targets, target) {
+				g.Targets = append(g.Targets, target)
+			}
+		}
+		for i, g := range split {
+			res[fmt.Sprintf("%s/%d", name, i)] = g
+		}
+	}
+	return res
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
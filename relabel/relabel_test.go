@@ -0,0 +1,255 @@
+package relabel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"gopkg.in/yaml.v2"
+)
+
+// The cases follow the semantics of the relabel package of Prometheus
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs string
+		labels  map[string]string
+		want    map[string]string
+	}{
+		{
+			name: "replace",
+			configs: `
+- source_labels: [a]
+  regex: f(.*)
+  target_label: d
+  separator: ";"
+  replacement: ch${1}-ch${1}`,
+			labels: map[string]string{"a": "foo", "b": "bar"},
+			want:   map[string]string{"a": "foo", "b": "bar", "d": "choo-choo"},
+		},
+		{
+			name: "replace joining the source labels",
+			configs: `
+- source_labels: [a, b]
+  regex: f(.*);(.*)r
+  target_label: a
+  replacement: b${1}${2}m`,
+			labels: map[string]string{"a": "foo", "b": "bar"},
+			want:   map[string]string{"a": "boobam", "b": "bar"},
+		},
+		{
+			name: "replace without match",
+			configs: `
+- source_labels: [a]
+  regex: x(.*)
+  target_label: d`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "replace with the regex anchored",
+			configs: `
+- source_labels: [a]
+  regex: o
+  target_label: d
+  replacement: matched`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "replace with an empty value deletes the label",
+			configs: `
+- source_labels: [a]
+  regex: (.*)
+  target_label: b
+  replacement: ${2}`,
+			labels: map[string]string{"a": "foo", "b": "bar"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "replace with an invalid target label",
+			configs: `
+- source_labels: [a]
+  regex: some-(.*)
+  target_label: ${1}`,
+			labels: map[string]string{"a": "some-name-value"},
+			want:   map[string]string{"a": "some-name-value"},
+		},
+		{
+			name: "replace with the target label from the regex",
+			configs: `
+- source_labels: [a]
+  regex: some-([^-]+)-([^,]+)
+  target_label: ${1}
+  replacement: ${2}`,
+			labels: map[string]string{"a": "some-name-value"},
+			want:   map[string]string{"a": "some-name-value", "name": "value"},
+		},
+		{
+			name: "keep",
+			configs: `
+- source_labels: [a]
+  regex: f.*
+  action: keep`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "keep without match",
+			configs: `
+- source_labels: [a]
+  regex: b.*
+  action: keep`,
+			labels: map[string]string{"a": "foo"},
+		},
+		{
+			name: "keep without source labels matches the empty value",
+			configs: `
+- regex: ""
+  action: keep`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "drop",
+			configs: `
+- source_labels: [a]
+  regex: f.*
+  action: drop`,
+			labels: map[string]string{"a": "foo"},
+		},
+		{
+			name: "drop of a missing label",
+			configs: `
+- source_labels: [c]
+  regex: .+
+  action: drop`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "drop without source labels matches the empty value",
+			configs: `
+- action: drop`,
+			labels: map[string]string{"a": "foo"},
+		},
+		{
+			name: "hashmod",
+			configs: `
+- source_labels: [c]
+  target_label: d
+  modulus: 1000
+  action: hashmod`,
+			labels: map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			want:   map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "976"},
+		},
+		{
+			name: "labelmap",
+			configs: `
+- regex: (b.*)
+  replacement: ${1}_mapped
+  action: labelmap`,
+			labels: map[string]string{"a": "foo", "b": "bar", "bc": "baz"},
+			want:   map[string]string{"a": "foo", "b": "bar", "bc": "baz", "b_mapped": "bar", "bc_mapped": "baz"},
+		},
+		{
+			name: "labelmap of meta labels",
+			configs: `
+- regex: __meta_(.+)
+  action: labelmap`,
+			labels: map[string]string{"__meta_datacenter": "london", "a": "foo"},
+			want:   map[string]string{"__meta_datacenter": "london", "datacenter": "london", "a": "foo"},
+		},
+		{
+			name: "labeldrop",
+			configs: `
+- regex: b.*
+  action: labeldrop`,
+			labels: map[string]string{"a": "foo", "b": "bar", "bc": "baz"},
+			want:   map[string]string{"a": "foo"},
+		},
+		{
+			name: "labelkeep",
+			configs: `
+- regex: b.*
+  action: labelkeep`,
+			labels: map[string]string{"a": "foo", "b": "bar", "bc": "baz"},
+			want:   map[string]string{"b": "bar", "bc": "baz"},
+		},
+		{
+			name: "lowercase",
+			configs: `
+- source_labels: [a, b]
+  target_label: c
+  separator: "-"
+  action: lowercase`,
+			labels: map[string]string{"a": "FoO", "b": "BaR"},
+			want:   map[string]string{"a": "FoO", "b": "BaR", "c": "foo-bar"},
+		},
+		{
+			name: "uppercase",
+			configs: `
+- source_labels: [a]
+  target_label: a
+  action: uppercase`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "FOO"},
+		},
+		{
+			name: "keepequal",
+			configs: `
+- source_labels: [a]
+  target_label: b
+  action: keepequal`,
+			labels: map[string]string{"a": "foo", "b": "foo"},
+			want:   map[string]string{"a": "foo", "b": "foo"},
+		},
+		{
+			name: "keepequal with different values",
+			configs: `
+- source_labels: [a]
+  target_label: b
+  action: keepequal`,
+			labels: map[string]string{"a": "foo", "b": "bar"},
+		},
+		{
+			name: "dropequal",
+			configs: `
+- source_labels: [a]
+  target_label: b
+  action: dropequal`,
+			labels: map[string]string{"a": "foo", "b": "foo"},
+		},
+		{
+			name: "dropequal with different values",
+			configs: `
+- source_labels: [a]
+  target_label: b
+  action: dropequal`,
+			labels: map[string]string{"a": "foo", "b": "bar"},
+			want:   map[string]string{"a": "foo", "b": "bar"},
+		},
+		{
+			name: "invalid regex never matches",
+			configs: `
+- source_labels: [a]
+  regex: "("
+  action: drop`,
+			labels: map[string]string{"a": "foo"},
+			want:   map[string]string{"a": "foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := []config.RelabelConfig{}
+			if err := yaml.Unmarshal([]byte(tt.configs), &configs); err != nil {
+				t.Fatal(err)
+			}
+			// The configs aren't validated, so that their regular expressions are compiled lazily
+			got := Process(tt.labels, configs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
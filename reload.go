@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"go.uber.org/zap"
+)
+
+// reloadConfig reloads the configuration file and applies the settings which can be changed
+// while the server is running.  Settings which require a restart keep their current value.
+func reloadConfig() {
+	logger.Logger.Info("Reloading configuration", zap.String("config_path", *flagConfPath))
+
+	newConf, err := newConfig()
+	if err != nil {
+		logger.Logger.Error("Could not reload configuration, keeping the current configuration",
+			zap.String("error", err.Error()),
+		)
+		return
+	}
+
+	oldConf := config.Current()
+	for _, setting := range oldConf.RestartRequiredChanges(newConf) {
+		logger.Logger.Warn("Refusing to change setting which requires a restart, keeping the current value",
+			zap.String("setting", setting),
+		)
+	}
+	oldConf.KeepRestartRequiredSettings(newConf)
+
+	if newConf.TLS.Enabled() && tlsCerts != nil {
+		if err := tlsCerts.reload(newConf.TLS.CertFile, newConf.TLS.KeyFile); err != nil {
+			logger.Logger.Error("Could not reload TLS certificate, keeping the current certificate",
+				zap.String("error", err.Error()),
+			)
+			newConf.TLS = oldConf.TLS
+		}
+	}
+
+	applyLogLevel(newConf)
+
+	config.SetCurrent(newConf)
+	conf = newConf
+	logger.Logger.Info("Configuration reloaded")
+}
+
+// applyLogLevel sets the log level from the configuration, unless the -debug flag is set
+func applyLogLevel(cnf *config.Config) {
+	if *flagDebug {
+		return
+	}
+	if err := logger.SetLevel(cnf.Logging.Level); err != nil {
+		logger.Logger.Error("Could not set log level", zap.String("error", err.Error()))
+	}
+}
+
+// watchConfigFile reloads the configuration whenever the modification time or size of the
+// configuration file changes.
+func watchConfigFile(interval time.Duration, shutdown chan bool) {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(*flagConfPath)
+		if err != nil {
+			return time.Time{}, 0
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	lastMod, lastSize := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			mod, size := stat()
+			if mod.IsZero() || (mod.Equal(lastMod) && size == lastSize) {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			select {
+			case reloadChan <- true:
+			default:
+				// A reload is already pending
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// boltDBMetric describes a metric exported from the bolt.Stats of the database
+type boltDBMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stats bolt.Stats) float64
+}
+
+func newBoltDBMetric(name, help string, valueType prometheus.ValueType, value func(stats bolt.Stats) float64) boltDBMetric {
+	return boltDBMetric{
+		desc:      prometheus.NewDesc("httpsdserver_boltdb_"+name, help, nil, nil),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+var boltDBMetrics = []boltDBMetric{
+	newBoltDBMetric("freelist_free_pages", "Number of free pages on the freelist.", prometheus.GaugeValue,
+		func(stats bolt.Stats) float64 { return float64(stats.FreePageN) }),
+	newBoltDBMetric("freelist_pending_pages", "Number of pending pages on the freelist.", prometheus.GaugeValue,
+		func(stats bolt.Stats) float64 { return float64(stats.PendingPageN) }),
+	newBoltDBMetric("freelist_free_alloc_bytes", "Bytes allocated in free pages.", prometheus.GaugeValue,
+		func(stats bolt.Stats) float64 { return float64(stats.FreeAlloc) }),
+	newBoltDBMetric("freelist_inuse_bytes", "Bytes used by the freelist.", prometheus.GaugeValue,
+		func(stats bolt.Stats) float64 { return float64(stats.FreelistInuse) }),
+	newBoltDBMetric("read_tx_total", "Total number of started read transactions.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxN) }),
+	newBoltDBMetric("open_read_tx", "Number of currently open read transactions.", prometheus.GaugeValue,
+		func(stats bolt.Stats) float64 { return float64(stats.OpenTxN) }),
+	newBoltDBMetric("tx_page_allocations_total", "Total number of page allocations.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.PageCount) }),
+	newBoltDBMetric("tx_page_alloc_bytes_total", "Total bytes allocated for pages.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.PageAlloc) }),
+	newBoltDBMetric("tx_cursors_total", "Total number of cursors created.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.CursorCount) }),
+	newBoltDBMetric("tx_node_allocations_total", "Total number of node allocations.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.NodeCount) }),
+	newBoltDBMetric("tx_node_dereferences_total", "Total number of node dereferences.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.NodeDeref) }),
+	newBoltDBMetric("tx_rebalances_total", "Total number of node rebalances.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.Rebalance) }),
+	newBoltDBMetric("tx_rebalance_seconds_total", "Total time spent rebalancing.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return stats.TxStats.RebalanceTime.Seconds() }),
+	newBoltDBMetric("tx_splits_total", "Total number of nodes split.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.Split) }),
+	newBoltDBMetric("tx_spills_total", "Total number of nodes spilled.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.Spill) }),
+	newBoltDBMetric("tx_spill_seconds_total", "Total time spent spilling.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return stats.TxStats.SpillTime.Seconds() }),
+	newBoltDBMetric("tx_writes_total", "Total number of writes performed.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return float64(stats.TxStats.Write) }),
+	newBoltDBMetric("tx_write_seconds_total", "Total time spent writing to disk.", prometheus.CounterValue,
+		func(stats bolt.Stats) float64 { return stats.TxStats.WriteTime.Seconds() }),
+}
+
+// Describe implements prometheus.Collector
+func (s *BoltDBStore) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range boltDBMetrics {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector, exporting the statistics of the BoltDB database
+func (s *BoltDBStore) Collect(ch chan<- prometheus.Metric) {
+	stats := s.db.Stats()
+	for _, m := range boltDBMetrics {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, m.value(stats))
+	}
+}
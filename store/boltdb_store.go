@@ -1,10 +1,12 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -12,8 +14,19 @@ import (
 	"go.uber.org/zap"
 )
 
+// metadataBucket holds the metadata of every target group, keyed by target group name
+const metadataBucket = "metadata"
+
+// templatesBucket holds the templates of the target groups which have some, as JSON lists keyed
+// by target group name
+const templatesBucket = "templates"
+
 type BoltDBStore struct {
 	db *bolt.DB
+
+	// changed is closed, and replaced, every time a write transaction is committed
+	changedMu sync.Mutex
+	changed   chan struct{}
 }
 
 func NewBoltDBDataStore(filePath string, shutdownNotify chan bool) (*BoltDBStore, error) {
@@ -22,12 +35,10 @@ func NewBoltDBDataStore(filePath string, shutdownNotify chan bool) (*BoltDBStore
 		return nil, err
 	}
 	s := &BoltDBStore{
-		db: db,
+		db:      db,
+		changed: make(chan struct{}),
 	}
 
-	if _, ok := os.LookupEnv("DEBUG"); ok {
-		s.collectMetrics(shutdownNotify)
-	}
 	go func() {
 		for {
 			select {
@@ -51,9 +62,29 @@ func (s *BoltDBStore) GetDB() *bolt.DB {
 	return s.db
 }
 
-func (s *BoltDBStore) AddTargetToGroup(targetGroup, target string) error {
+// update runs fn in a read-write transaction, traced as a child span of ctx.  The watchers
+// of the store are notified once the transaction is committed.
+func (s *BoltDBStore) update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	_, span := tracer.Start(ctx, "boltdb.update")
+	return endSpan(span, s.db.Update(func(tx *bolt.Tx) error {
+		tx.OnCommit(s.notifyChange)
+		return fn(tx)
+	}))
+}
+
+// view runs fn in a read-only transaction, traced as a child span of ctx
+func (s *BoltDBStore) view(ctx context.Context, fn func(*bolt.Tx) error) error {
+	_, span := tracer.Start(ctx, "boltdb.view")
+	return endSpan(span, s.db.View(fn))
+}
+
+func (s *BoltDBStore) AddTargetToGroup(ctx context.Context, targetGroup, target string) error {
 	bucketName := fmt.Sprintf("targets:%s", targetGroup)
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		created := !groupExists(tx, targetGroup)
+		if err := checkGroupConditions(ctx, tx, targetGroup); err != nil {
+			return err
+		}
 		b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 		if err != nil {
 			return fmt.Errorf("Could not create bucket for targets: %s", err)
@@ -62,81 +93,195 @@ func (s *BoltDBStore) AddTargetToGroup(targetGroup, target string) error {
 		if err := b.Put([]byte(target), []byte(nil)); err != nil {
 			return fmt.Errorf("Could put item into bucket for targets: %s", err)
 		}
-		return nil
+		return touchGroup(tx, targetGroup, created)
 	})
 	return err
 }
 
-func (s *BoltDBStore) RemoveTargetFromGroup(targetGroup, target string) error {
+func (s *BoltDBStore) RemoveTargetFromGroup(ctx context.Context, targetGroup, target string) error {
 	bucketName := fmt.Sprintf("targets:%s", targetGroup)
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		if err := checkGroupConditions(ctx, tx, targetGroup); err != nil {
+			return err
+		}
 		bucket := tx.Bucket([]byte(bucketName))
-		return bucket.Delete([]byte(target))
+		if bucket == nil {
+			return ErrTargetGroupNotFound
+		}
+		if err := bucket.Delete([]byte(target)); err != nil {
+			return err
+		}
+		return touchGroup(tx, targetGroup, false)
 	})
 }
 
-func (s *BoltDBStore) RemoveTargetGroup(targetGroup string) error {
-	bucketName := fmt.Sprintf("targets:%s", targetGroup)
-	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(bucketName))
-		return bucket.DeleteBucket([]byte(bucketName))
+func (s *BoltDBStore) RemoveTargetGroup(ctx context.Context, targetGroup string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		if err := checkGroupConditions(ctx, tx, targetGroup); err != nil {
+			return err
+		}
+		if err := checkTemplatesNotDeleted(tx, map[string]*TargetGroup{targetGroup: nil}); err != nil {
+			return err
+		}
+		return deleteTargetGroup(tx, targetGroup)
+	})
+}
+
+// RenameTargetGroup moves the buckets of the target group to newName in a single transaction
+func (s *BoltDBStore) RenameTargetGroup(ctx context.Context, targetGroup, newName string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		tg, err := readTargetGroup(tx, targetGroup)
+		if err != nil {
+			return err
+		}
+		if tg == nil {
+			return fmt.Errorf("%w: %s", ErrTargetGroupNotFound, targetGroup)
+		}
+		if groupExists(tx, newName) {
+			return fmt.Errorf("%w: %s", ErrTargetGroupExists, newName)
+		}
+		if err := checkGroupConditions(ctx, tx, targetGroup, newName); err != nil {
+			return err
+		}
+		if err := checkTemplatesNotDeleted(tx, map[string]*TargetGroup{targetGroup: nil, newName: tg}); err != nil {
+			return err
+		}
+		if err := putTargetGroup(tx, newName, tg); err != nil {
+			return err
+		}
+		tg.Metadata.touch(time.Now().UTC(), false, uint64(tx.ID()))
+		if err := putGroupMetadata(tx, newName, tg.Metadata); err != nil {
+			return err
+		}
+		return deleteTargetGroup(tx, targetGroup)
 	})
 }
 
-func (s *BoltDBStore) AddLabelsToGroup(targetGroup string, labels map[string]string) error {
+func (s *BoltDBStore) CopyTargetGroup(ctx context.Context, targetGroup, newName string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		tg, err := readTargetGroup(tx, targetGroup)
+		if err != nil {
+			return err
+		}
+		if tg == nil {
+			return fmt.Errorf("%w: %s", ErrTargetGroupNotFound, targetGroup)
+		}
+		if groupExists(tx, newName) {
+			return fmt.Errorf("%w: %s", ErrTargetGroupExists, newName)
+		}
+		if err := checkGroupConditions(ctx, tx, targetGroup, newName); err != nil {
+			return err
+		}
+		if err := putTargetGroup(tx, newName, tg); err != nil {
+			return err
+		}
+		return touchGroup(tx, newName, true)
+	})
+}
+
+// MergeTargetGroup merges the target groups, and removes the source when requested, in a single
+// transaction
+func (s *BoltDBStore) MergeTargetGroup(ctx context.Context, targetGroup, into, policy string, removeSource bool) error {
+	if targetGroup == into {
+		return fmt.Errorf("Cannot merge target group %s into itself", into)
+	}
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		src, err := readTargetGroup(tx, targetGroup)
+		if err != nil {
+			return err
+		}
+		if src == nil {
+			return fmt.Errorf("%w: %s", ErrTargetGroupNotFound, targetGroup)
+		}
+		dst, err := readTargetGroup(tx, into)
+		if err != nil {
+			return err
+		}
+		if dst == nil {
+			return fmt.Errorf("%w: %s", ErrTargetGroupNotFound, into)
+		}
+		if err := checkGroupConditions(ctx, tx, targetGroup, into); err != nil {
+			return err
+		}
+		if err := mergeTargetGroup(dst, src, policy); err != nil {
+			return err
+		}
+		if removeSource {
+			if err := checkTemplatesNotDeleted(tx, map[string]*TargetGroup{targetGroup: nil, into: dst}); err != nil {
+				return err
+			}
+		}
+		if err := putTargetGroup(tx, into, dst); err != nil {
+			return err
+		}
+		if err := touchGroup(tx, into, false); err != nil {
+			return err
+		}
+		if removeSource {
+			return deleteTargetGroup(tx, targetGroup)
+		}
+		return nil
+	})
+}
+
+func (s *BoltDBStore) AddLabelsToGroup(ctx context.Context, targetGroup string, labels map[string]string) error {
 	bucketName := fmt.Sprintf("labels:%s", targetGroup)
-	err := s.db.Update(func(tx *bolt.Tx) error {
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		created := !groupExists(tx, targetGroup)
+		if err := checkGroupConditions(ctx, tx, targetGroup); err != nil {
+			return err
+		}
 		b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 		if err != nil {
-			logger.Logger.Error("Could not create bukcet")
+			logger.Logger.Error("Could not create bucket", zap.String("bucket", bucketName), zap.Error(err))
 			return fmt.Errorf("Could not create bucket for target group labels: %s", err)
 		}
 
 		for k, v := range labels {
 			if err := b.Put([]byte(k), []byte(v)); err != nil {
-				logger.Logger.Error(fmt.Sprintf("Could not add label %s=%s : %s", k, v, err))
+				logger.Logger.Error("Could not add label",
+					zap.String("target_group", targetGroup),
+					zap.String("label", k),
+					zap.String("value", v),
+					zap.Error(err),
+				)
 				return fmt.Errorf("Could put item into bucket for target group labels: %s", err)
 			}
-			logger.Logger.Debug(fmt.Sprintf("Adding label %s=%s to bucket %s", k, v, bucketName))
+			logger.Logger.Debug("Adding label",
+				zap.String("target_group", targetGroup),
+				zap.String("label", k),
+				zap.String("value", v),
+			)
 		}
-		return nil
+		return touchGroup(tx, targetGroup, created)
 	})
 	return err
 }
 
-func (s *BoltDBStore) RemoveLabelFromGroup(targetGroup, label string) error {
+func (s *BoltDBStore) RemoveLabelFromGroup(ctx context.Context, targetGroup, label string) error {
 	bucketName := fmt.Sprintf("labels:%s", targetGroup)
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		if err := checkGroupConditions(ctx, tx, targetGroup); err != nil {
+			return err
+		}
 		bucket := tx.Bucket([]byte(bucketName))
-		return bucket.Delete([]byte(label))
-	})
-}
-
-func (s *BoltDBStore) getTargetGroups() ([]string, error) {
-
-	buckets := []string{}
-
-	err := s.db.View(func(tx *bolt.Tx) error {
-		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
-			if strings.HasPrefix(string(name), "targets:") {
-				buckets = append(buckets, string(name))
-			}
-			return nil
-		})
+		if bucket == nil {
+			return ErrTargetGroupNotFound
+		}
+		if err := bucket.Delete([]byte(label)); err != nil {
+			return err
+		}
+		return touchGroup(tx, targetGroup, false)
 	})
-	if err != nil {
-		logger.Logger.Error("Could not get target groups", zap.String("message", fmt.Sprintf("%s", err)))
-		return nil, err
-	}
-	return buckets, nil
 }
 
-func (s *BoltDBStore) GetTargetGroupLabels(targetGroup string) (*map[string]string, error) {
+func (s *BoltDBStore) GetTargetGroupLabels(ctx context.Context, targetGroup string) (*map[string]string, error) {
 
+	bucketName := fmt.Sprintf("labels:%s", targetGroup)
 	labels := map[string]string{}
-	err := s.db.View(func(tx *bolt.Tx) error {
-		logger.Logger.Debug(fmt.Sprintf("Bucket=%s", targetGroup))
-		b := tx.Bucket([]byte(targetGroup))
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		logger.Logger.Debug("Reading target group labels", zap.String("bucket", bucketName))
+		b := tx.Bucket([]byte(bucketName))
 		if b == nil {
 			return nil
 		}
@@ -164,110 +309,480 @@ func (s *BoltDBStore) getTargetLabelsByGroup() (map[string]bool, error) {
 		})
 	})
 	if err != nil {
-		logger.Logger.Error("Could not get target group labels", zap.String("message", fmt.Sprintf("%s", err)))
+		logger.Logger.Error("Could not get target group labels", zap.Error(err))
 		return nil, err
 	}
 	return data, nil
 }
 
-func (s *BoltDBStore) collectMetrics(cancel chan bool) {
-	go func(cancel chan bool, s *BoltDBStore) {
-		// Get the current state
-		prev := s.db.Stats()
-		ticker := time.NewTicker(60 * time.Second)
+// GetTargetGroups returns every target group in the store, keyed by target group name.
+// Groups which only have labels defined are included with an empty list of targets.
+func (s *BoltDBStore) GetTargetGroups(ctx context.Context) (map[string]*TargetGroup, error) {
+	var groups map[string]*TargetGroup
+	err := s.view(ctx, func(tx *bolt.Tx) (err error) {
+		groups, err = readTargetGroups(tx)
+		return err
+	})
+	if err != nil {
+		logger.Logger.Error("Could not get target groups", zap.Error(err))
+		return nil, err
+	}
+	return groups, nil
+}
 
-		for {
-			select {
-			case <-cancel:
-				logger.Logger.Debug("Stopping boltDB stats collection")
-				return
-			case _ = <-ticker.C:
-				stats := s.db.Stats()
-				diff := stats.Sub(&prev)
-				json.NewEncoder(os.Stderr).Encode(diff)
-				prev = stats
+// ApplyTxn applies the transaction, along with the conditions of ctx, in a single BoltDB
+// transaction, which is rolled back when a condition or an operation fails
+func (s *BoltDBStore) ApplyTxn(ctx context.Context, txn *Txn) (map[string]*TargetGroup, error) {
+	txn = txn.withContext(ctx)
+	res := map[string]*TargetGroup{}
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		groups := map[string]*TargetGroup{}
+		for _, name := range txn.Groups() {
+			tg, err := readTargetGroup(tx, name)
+			if err != nil {
+				return err
 			}
+			groups[name] = tg
+		}
+		existed := map[string]bool{}
+		for name, tg := range groups {
+			existed[name] = tg != nil
+		}
 
+		changed, err := txn.apply(groups)
+		if err != nil {
+			return err
+		}
+		changedGroups := map[string]*TargetGroup{}
+		deletes := false
+		for name := range changed {
+			changedGroups[name] = groups[name]
+			deletes = deletes || groups[name] == nil
+		}
+		if deletes {
+			if err := checkTemplatesNotDeleted(tx, changedGroups); err != nil {
+				return err
+			}
+		}
+		for name := range changed {
+			// The changed groups are replaced, rather than updated
+			if existed[name] {
+				if err := deleteTargetGroup(tx, name); err != nil {
+					return err
+				}
+			}
+			tg := groups[name]
+			res[name] = tg
+			if tg == nil {
+				continue
+			}
+			if err := putTargetGroup(tx, name, tg); err != nil {
+				return err
+			}
+			// Groups created by the transaction, even if they replace a deleted one, have no metadata
+			created := tg.Metadata == nil
+			if created {
+				tg.Metadata = &GroupMetadata{}
+			}
+			tg.Metadata.touch(time.Now().UTC(), created, uint64(tx.ID()))
+			if err := putGroupMetadata(tx, name, tg.Metadata); err != nil {
+				return err
+			}
 		}
-	}(cancel, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
-func (s *BoltDBStore) Serialize(debug bool) (string, error) {
-	/*
-		[
-			{
-				"targets": ["10.0.10.2:9100", "10.0.10.3:9100", "10.0.10.4:9100", "10.0.10.5:9100"],
-				"labels": {
-					"__meta_datacenter": "london",
-					"__meta_prometheus_job": "node"
-				}
-			},
-			...
-		]
-	*/
+// GetTargetGroup returns the target group along with its metadata, only reading its own buckets
+func (s *BoltDBStore) GetTargetGroup(ctx context.Context, targetGroup string) (*TargetGroup, error) {
+	var tg *TargetGroup
+	err := s.view(ctx, func(tx *bolt.Tx) (err error) {
+		tg, err = readTargetGroup(tx, targetGroup)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tg == nil {
+		return nil, ErrTargetGroupNotFound
+	}
+	return tg, nil
+}
 
-	targetGroupsBuckets, err := s.getTargetGroups()
+// ListTargetGroups returns the target groups matching the query.  Only the buckets of the groups
+// whose name matches are read, until the limit is reached.
+func (s *BoltDBStore) ListTargetGroups(ctx context.Context, query *GroupQuery) ([]*TargetGroup, error) {
+	res := []*TargetGroup{}
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		forEachGroupName(tx, query.Prefix, query.After, func(name string) bool {
+			var tg *TargetGroup
+			if tg, err = readTargetGroup(tx, name); err != nil {
+				return false
+			}
+			if query.matchLabels(tg.Labels) {
+				res = append(res, tg)
+			}
+			return query.Limit == 0 || len(res) < query.Limit
+		})
+		return err
+	})
 	if err != nil {
-		logger.Logger.Debug("Could not get target groups")
-		return "", err
+		return nil, err
 	}
+	return res, nil
+}
 
-	if !debug {
-		data := []TargetGroup{}
+// WatchTargetGroups waits for a write transaction to be committed when revision is the ID of
+// the last committed transaction.  The ID of the transaction is used as the revision.
+func (s *BoltDBStore) WatchTargetGroups(ctx context.Context, revision uint64) (map[string]*TargetGroup, uint64, error) {
+	for {
+		changed := s.changes()
+
+		var groups map[string]*TargetGroup
+		var current uint64
+		err := s.db.View(func(tx *bolt.Tx) (err error) {
+			current = uint64(tx.ID())
+			groups, err = readTargetGroups(tx)
+			return err
+		})
+		if err != nil || revision == 0 || current != revision {
+			return groups, current, err
+		}
 
-		s.db.View(func(tx *bolt.Tx) error {
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, revision, ctx.Err()
+		}
+	}
+}
 
-			// Itterate over each target group, which are the bucket names, to get the target
-			for _, tgName := range targetGroupsBuckets {
-				tg := TargetGroup{Name: tgName}
-				b := tx.Bucket([]byte(tgName))
-				c := b.Cursor()
-				targets := []string{}
-				for k, _ := c.First(); k != nil; k, _ = c.Next() {
-					targets = append(targets, string(k))
-				}
-				tg.Targets = targets
-				logger.Logger.Debug(fmt.Sprintf("Getting labels for target group: labels:%s", strings.Split(tgName, ":")[1]))
-				labels, _ := s.GetTargetGroupLabels(fmt.Sprintf("labels:%s", strings.Split(tgName, ":")[1]))
-				tg.Labels = *labels
+func (s *BoltDBStore) changes() <-chan struct{} {
+	s.changedMu.Lock()
+	defer s.changedMu.Unlock()
+	return s.changed
+}
+
+func (s *BoltDBStore) notifyChange() {
+	s.changedMu.Lock()
+	defer s.changedMu.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// readTargetGroups reads every target group from the buckets of the transaction
+func readTargetGroups(tx *bolt.Tx) (map[string]*TargetGroup, error) {
+	groups := map[string]*TargetGroup{}
+
+	getGroup := func(name string) *TargetGroup {
+		tg, ok := groups[name]
+		if !ok {
+			tg = &TargetGroup{Name: name, Targets: []string{}, Labels: map[string]string{}}
+			groups[name] = tg
+		}
+		return tg
+	}
+
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		bucketName := string(name)
+		switch {
+		case strings.HasPrefix(bucketName, "targets:"):
+			tg := getGroup(strings.TrimPrefix(bucketName, "targets:"))
+			return b.ForEach(func(k, _ []byte) error {
+				tg.Targets = append(tg.Targets, string(k))
+				return nil
+			})
+		case strings.HasPrefix(bucketName, "labels:"):
+			tg := getGroup(strings.TrimPrefix(bucketName, "labels:"))
+			return b.ForEach(func(k, v []byte) error {
+				tg.Labels[string(k)] = string(v)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, tg := range groups {
+		if tg.Templates, err = readGroupTemplates(tx, name); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// groupExists returns true when the target group has a bucket of targets or labels
+func groupExists(tx *bolt.Tx, targetGroup string) bool {
+	return tx.Bucket([]byte("targets:"+targetGroup)) != nil || tx.Bucket([]byte("labels:"+targetGroup)) != nil
+}
+
+// groupCursor iterates over the names of the target groups having a bucket of targets, or of
+// labels, whose name starts with prefix
+type groupCursor struct {
+	c            *bolt.Cursor
+	bucketPrefix string
+	prefix       []byte
+	name         string
+	ok           bool
+}
+
+// set moves to the group of the bucket k, if it's one of the buckets of the cursor
+func (g *groupCursor) set(k []byte) {
+	g.ok = k != nil && bytes.HasPrefix(k, g.prefix)
+	if g.ok {
+		g.name = string(k[len(g.bucketPrefix):])
+	}
+}
 
-				data = append(data, tg)
+// forEachGroupName calls fn with the names of the target groups starting with prefix and sorted
+// after after, in order, until it returns false.  It seeks to the first of these names in the
+// buckets of targets and of labels and merges both, rather than iterating over every bucket.
+func forEachGroupName(tx *bolt.Tx, prefix, after string, fn func(name string) bool) {
+	start := prefix
+	if after > start {
+		start = after
+	}
+	cursors := []*groupCursor{}
+	for _, bucketPrefix := range []string{"targets:", "labels:"} {
+		g := &groupCursor{c: tx.Cursor(), bucketPrefix: bucketPrefix, prefix: []byte(bucketPrefix + prefix)}
+		k, _ := g.c.Seek([]byte(bucketPrefix + start))
+		g.set(k)
+		if g.ok && after != "" && g.name == after {
+			k, _ = g.c.Next()
+			g.set(k)
+		}
+		cursors = append(cursors, g)
+	}
+
+	for {
+		var next *groupCursor
+		for _, g := range cursors {
+			if g.ok && (next == nil || g.name < next.name) {
+				next = g
 			}
+		}
+		if next == nil {
+			return
+		}
+		name := next.name
+		// A group having both targets and labels is found by both cursors
+		for _, g := range cursors {
+			if g.ok && g.name == name {
+				k, _ := g.c.Next()
+				g.set(k)
+			}
+		}
+		if !fn(name) {
+			return
+		}
+	}
+}
 
-			return nil
+// readTargetGroup reads a target group and its metadata, returning nil if it doesn't exist
+func readTargetGroup(tx *bolt.Tx, name string) (*TargetGroup, error) {
+	targets := tx.Bucket([]byte("targets:" + name))
+	labels := tx.Bucket([]byte("labels:" + name))
+	if targets == nil && labels == nil {
+		return nil, nil
+	}
 
+	tg := &TargetGroup{Name: name, Targets: []string{}, Labels: map[string]string{}}
+	if targets != nil {
+		targets.ForEach(func(k, _ []byte) error {
+			tg.Targets = append(tg.Targets, string(k))
+			return nil
 		})
+	}
+	if labels != nil {
+		labels.ForEach(func(k, v []byte) error {
+			tg.Labels[string(k)] = string(v)
+			return nil
+		})
+	}
+	templates, err := readGroupTemplates(tx, name)
+	if err != nil {
+		return nil, err
+	}
+	tg.Templates = templates
+	meta, err := readGroupMetadata(tx, name)
+	if err != nil {
+		return nil, err
+	}
+	tg.Metadata = meta
+	return tg, nil
+}
 
-		res, _ := json.MarshalIndent(data, "", "    ")
-		return string(res), nil
+// readGroupTemplates returns the templates of the target group, nil when it has none
+func readGroupTemplates(tx *bolt.Tx, targetGroup string) ([]string, error) {
+	b := tx.Bucket([]byte(templatesBucket))
+	if b == nil {
+		return nil, nil
+	}
+	v := b.Get([]byte(targetGroup))
+	if v == nil {
+		return nil, nil
 	}
+	templates := []string{}
+	if err := json.Unmarshal(v, &templates); err != nil {
+		return nil, fmt.Errorf("Could not decode templates of target group %s: %s", targetGroup, err)
+	}
+	return templates, nil
+}
 
-	// in this case, return a debug view of the data which shows the target group names
-	dataDebug := map[string]map[string]interface{}{}
-	dataDebug["targets"] = map[string]interface{}{}
+// checkTemplatesNotDeleted returns ErrTemplateInUse when a target group deleted by the change is
+// still a template of another group, changed being the groups changed by it, nil when deleted
+func checkTemplatesNotDeleted(tx *bolt.Tx, changed map[string]*TargetGroup) error {
+	templates := map[string][]string{}
+	if b := tx.Bucket([]byte(templatesBucket)); b != nil {
+		err := b.ForEach(func(k, v []byte) error {
+			ts := []string{}
+			if err := json.Unmarshal(v, &ts); err != nil {
+				return fmt.Errorf("Could not decode templates of target group %s: %s", k, err)
+			}
+			templates[string(k)] = ts
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return checkTemplatesInUse(templates, changed)
+}
 
-	s.db.View(func(tx *bolt.Tx) error {
+// readGroupMetadata returns the metadata of the target group, empty when it wasn't recorded
+func readGroupMetadata(tx *bolt.Tx, targetGroup string) (*GroupMetadata, error) {
+	meta := &GroupMetadata{}
+	b := tx.Bucket([]byte(metadataBucket))
+	if b == nil {
+		return meta, nil
+	}
+	if v := b.Get([]byte(targetGroup)); v != nil {
+		if err := json.Unmarshal(v, meta); err != nil {
+			return nil, fmt.Errorf("Could not decode metadata of target group %s: %s", targetGroup, err)
+		}
+	}
+	return meta, nil
+}
 
-		// Itterate over each target group, which are the bucket names, to get the target
-		for _, tgName := range targetGroupsBuckets {
-			tg := TargetGroup{Name: tgName}
-			b := tx.Bucket([]byte(tgName))
-			c := b.Cursor()
-			targets := []string{}
-			for k, _ := c.First(); k != nil; k, _ = c.Next() {
-				targets = append(targets, string(k))
+// putTargetGroup adds the targets and labels of tg to the target group, creating its buckets,
+// and replaces its templates when tg has some
+func putTargetGroup(tx *bolt.Tx, name string, tg *TargetGroup) error {
+	targets, err := tx.CreateBucketIfNotExists([]byte("targets:" + name))
+	if err != nil {
+		return fmt.Errorf("Could not create bucket for targets: %s", err)
+	}
+	for _, t := range tg.Targets {
+		if err := targets.Put([]byte(t), []byte(nil)); err != nil {
+			return fmt.Errorf("Could put item into bucket for targets: %s", err)
+		}
+	}
+	labels, err := tx.CreateBucketIfNotExists([]byte("labels:" + name))
+	if err != nil {
+		return fmt.Errorf("Could not create bucket for target group labels: %s", err)
+	}
+	for k, v := range tg.Labels {
+		if err := labels.Put([]byte(k), []byte(v)); err != nil {
+			return fmt.Errorf("Could put item into bucket for target group labels: %s", err)
+		}
+	}
+	if len(tg.Templates) == 0 {
+		return nil
+	}
+	v, err := json.Marshal(tg.Templates)
+	if err != nil {
+		return err
+	}
+	templates, err := tx.CreateBucketIfNotExists([]byte(templatesBucket))
+	if err != nil {
+		return fmt.Errorf("Could not create bucket for target group templates: %s", err)
+	}
+	return templates.Put([]byte(name), v)
+}
+
+// deleteTargetGroup deletes the buckets, the templates and the metadata of the target group
+func deleteTargetGroup(tx *bolt.Tx, targetGroup string) error {
+	found := false
+	for _, bucketName := range []string{fmt.Sprintf("targets:%s", targetGroup), fmt.Sprintf("labels:%s", targetGroup)} {
+		if tx.Bucket([]byte(bucketName)) == nil {
+			continue
+		}
+		if err := tx.DeleteBucket([]byte(bucketName)); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return ErrTargetGroupNotFound
+	}
+	for _, bucketName := range []string{templatesBucket, metadataBucket} {
+		if b := tx.Bucket([]byte(bucketName)); b != nil {
+			if err := b.Delete([]byte(targetGroup)); err != nil {
+				return err
 			}
-			tg.Targets = targets
-			logger.Logger.Debug(fmt.Sprintf("Getting labels for target group: labels:%s", strings.Split(tgName, ":")[1]))
-			labels, _ := s.GetTargetGroupLabels(fmt.Sprintf("labels:%s", strings.Split(tgName, ":")[1]))
-			tg.Labels = *labels
+		}
+	}
+	return nil
+}
 
-			dataDebug["targets"][strings.Split(tgName, ":")[1]] = tg
+// checkGroupConditions returns ErrTxnConditionFailed when a condition of ctx on one of the target
+// groups doesn't hold, reading them in the transaction
+func checkGroupConditions(ctx context.Context, tx *bolt.Tx, names ...string) error {
+	for _, name := range names {
+		if err := checkConditions(ctx, name, func() (*TargetGroup, error) { return readTargetGroup(tx, name) }); err != nil {
+			return err
 		}
-		return nil
+	}
+	return nil
+}
 
-	})
+// touchGroup records a modification of the target group in its metadata, which created the
+// group when created is set.  The ID of the write transaction, which increases with every
+// transaction, is the revision of the change.
+func touchGroup(tx *bolt.Tx, targetGroup string, created bool) error {
+	meta, err := readGroupMetadata(tx, targetGroup)
+	if err != nil {
+		return err
+	}
+	meta.touch(time.Now().UTC(), created, uint64(tx.ID()))
+	return putGroupMetadata(tx, targetGroup, meta)
+}
 
-	res, _ := json.MarshalIndent(dataDebug, "", "    ")
-	return string(res), nil
+func putGroupMetadata(tx *bolt.Tx, targetGroup string, meta *GroupMetadata) error {
+	v, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(metadataBucket))
+	if err != nil {
+		return fmt.Errorf("Could not create bucket for target group metadata: %s", err)
+	}
+	return b.Put([]byte(targetGroup), v)
+}
+
+func (s *BoltDBStore) Serialize(ctx context.Context, debug bool) (string, error) {
+	/*
+		[
+			{
+				"targets": ["10.0.10.2:9100", "10.0.10.3:9100", "10.0.10.4:9100", "10.0.10.5:9100"],
+				"labels": {
+					"__meta_datacenter": "london",
+					"__meta_prometheus_job": "node"
+				}
+			},
+			...
+		]
+	*/
+
+	groups, err := s.GetTargetGroups(ctx)
+	if err != nil {
+		logger.Logger.Debug("Could not get target groups")
+		return "", err
+	}
+	return serializeTargetGroups(groups, debug)
 }
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"go.uber.org/zap"
+)
+
+func newTestBoltDBStore(t *testing.T) *BoltDBStore {
+	t.Helper()
+	if logger.Logger == nil {
+		logger.Logger = zap.NewNop()
+	}
+	s, err := NewBoltDBDataStore(filepath.Join(t.TempDir(), "store.db"), make(chan bool))
+	if err != nil {
+		t.Fatalf("NewBoltDBDataStore: %s", err)
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func groupNamesOf(groups []*TargetGroup) string {
+	names := []string{}
+	for _, tg := range groups {
+		names = append(names, tg.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestBoltDBStoreListTargetGroupsPages(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltDBStore(t)
+	// Groups with only targets, only labels, or both
+	for _, name := range []string{"api", "api-canary", "db", "web"} {
+		if err := s.AddTargetToGroup(ctx, name, name+":80"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"api", "cache", "web", "web-eu"} {
+		if err := s.AddLabelsToGroup(ctx, name, map[string]string{"env": "prod"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query *GroupQuery
+		want  string
+	}{
+		{&GroupQuery{}, "api,api-canary,cache,db,web,web-eu"},
+		{&GroupQuery{Limit: 2}, "api,api-canary"},
+		{&GroupQuery{After: "api-canary", Limit: 2}, "cache,db"},
+		{&GroupQuery{After: "b"}, "cache,db,web,web-eu"},
+		{&GroupQuery{After: "web-eu"}, ""},
+		{&GroupQuery{Prefix: "web"}, "web,web-eu"},
+		{&GroupQuery{Prefix: "api", After: "api"}, "api-canary"},
+		{&GroupQuery{Prefix: "api", After: "c"}, ""},
+		{&GroupQuery{Prefix: "db", After: "a"}, "db"},
+		{&GroupQuery{Labels: map[string]string{"env": "prod"}, Limit: 3}, "api,cache,web"},
+		{&GroupQuery{Labels: map[string]string{"env": "prod"}, After: "cache"}, "web,web-eu"},
+	}
+	for _, tt := range tests {
+		groups, err := s.ListTargetGroups(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("ListTargetGroups(%+v): %s", tt.query, err)
+		}
+		if got := groupNamesOf(groups); got != tt.want {
+			t.Errorf("ListTargetGroups(%+v) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	// Paging through every group returns each of them once
+	all, after := []*TargetGroup{}, ""
+	for {
+		page, err := s.ListTargetGroups(ctx, &GroupQuery{After: after, Limit: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		all = append(all, page...)
+		after = page[len(page)-1].Name
+	}
+	if got := groupNamesOf(all); got != "api,api-canary,cache,db,web,web-eu" {
+		t.Errorf("got %s when paging", got)
+	}
+}
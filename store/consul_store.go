@@ -1,15 +1,19 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/hartfordfive/prom-http-sd-server/lib"
 	"github.com/hartfordfive/prom-http-sd-server/logger"
 	consul "github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -20,6 +24,9 @@ type ConsulStore struct {
 
 var consulKVPrefix string = "prom-http-sd-server"
 
+// consulMaxTxnOps is the maximum number of operations of a consul transaction
+const consulMaxTxnOps = 64
+
 /*******************************************/
 
 // Lock stores data for a consul lock
@@ -55,20 +62,30 @@ func newLock(consulClient *consul.Client, lockPath, lockContents string) (*Consu
 	return l, nil
 }
 
-func (l *ConsulLock) lock() (err error) {
+func (l *ConsulLock) lock(ctx context.Context) (err error) {
+	_, span := tracer.Start(ctx, "consul.lock", trace.WithAttributes(attribute.String("consul.key", l.path)))
+	defer func() { endSpan(span, err) }()
+
 	logger.Logger.Debug("Trying to acquire lock",
 		zap.String("lock_path", l.path),
 	)
-	if l.c, err = l.consulLock.Lock(nil); err != nil {
+	if l.c, err = l.consulLock.Lock(ctx.Done()); err != nil {
 		return err
 	}
+	if l.c == nil {
+		// The lock is only abandoned without an error when the context is done
+		return ctx.Err()
+	}
 	logger.Logger.Debug("Lock acquired",
 		zap.String("lock_path", l.path),
 	)
 	return
 }
 
-func (l *ConsulLock) unlock() {
+func (l *ConsulLock) unlock(ctx context.Context) {
+	_, span := tracer.Start(ctx, "consul.unlock", trace.WithAttributes(attribute.String("consul.key", l.path)))
+	defer span.End()
+
 	logger.Logger.Debug("Releasing lock",
 		zap.String("lock_path", l.path),
 	)
@@ -85,6 +102,21 @@ func (l *ConsulLock) unlock() {
 
 /*****************************************/
 
+// consulTargetGroup is the value of the key of a target group in the consul KV store
+type consulTargetGroup struct {
+	TargetGroup
+	GroupMetadata
+}
+
+// targetGroup returns the target group along with its metadata
+func (g *consulTargetGroup) targetGroup(name string) *TargetGroup {
+	tg := g.TargetGroup
+	tg.Name = name
+	meta := g.GroupMetadata
+	tg.Metadata = &meta
+	return &tg
+}
+
 func NewConsulDataStore(consulHost string, allowStale bool, shutdownNotify chan bool) (*ConsulStore, error) {
 
 	host, _, _ := lib.ParseURL(consulHost)
@@ -115,7 +147,101 @@ func (s *ConsulStore) getLockKey(targetGroup string) string {
 	return strings.TrimPrefix(fmt.Sprintf("%s-lock/targetGroup/%s", consulKVPrefix, targetGroup), "/")
 }
 
-func (s *ConsulStore) getLock(targetGroup, lockContents string) (*ConsulLock, error) {
+// kvGet reads a key from the consul KV store, traced as a child span of ctx
+func (s *ConsulStore) kvGet(ctx context.Context, key string) (pair *consul.KVPair, err error) {
+	pair, _, err = s.kvGetIndex(ctx, key)
+	return
+}
+
+// kvGetIndex reads a key from the consul KV store along with the index of the KV store, which
+// is at least the index of the last change of the key, including its deletion
+func (s *ConsulStore) kvGetIndex(ctx context.Context, key string) (pair *consul.KVPair, index uint64, err error) {
+	ctx, span := tracer.Start(ctx, "consul.kv.get", trace.WithAttributes(attribute.String("consul.key", key)))
+	defer func() { endSpan(span, err) }()
+	pair, meta, err := s.client.KV().Get(key, (&consul.QueryOptions{AllowStale: s.allowStale}).WithContext(ctx))
+	if meta != nil {
+		index = meta.LastIndex
+	}
+	return
+}
+
+// kvList reads every key under the prefix from the consul KV store, traced as a child span of ctx
+func (s *ConsulStore) kvList(ctx context.Context, prefix string) (pairs consul.KVPairs, err error) {
+	ctx, span := tracer.Start(ctx, "consul.kv.list", trace.WithAttributes(attribute.String("consul.prefix", prefix)))
+	defer func() { endSpan(span, err) }()
+	pairs, _, err = s.client.KV().List(prefix, (&consul.QueryOptions{AllowStale: s.allowStale}).WithContext(ctx))
+	return
+}
+
+// kvCAS writes a key to the consul KV store, traced as a child span of ctx.  It fails with
+// ErrConcurrentModification when the key was modified since it was read at pair.ModifyIndex,
+// which is 0 for a key which didn't exist.
+func (s *ConsulStore) kvCAS(ctx context.Context, pair *consul.KVPair) (err error) {
+	ctx, span := tracer.Start(ctx, "consul.kv.cas", trace.WithAttributes(attribute.String("consul.key", pair.Key)))
+	defer func() { endSpan(span, err) }()
+	ok, _, err := s.client.KV().CAS(pair, (&consul.WriteOptions{}).WithContext(ctx))
+	if err == nil && !ok {
+		err = fmt.Errorf("%w: %s", ErrConcurrentModification, pair.Key)
+	}
+	return
+}
+
+// kvTxn applies the operations atomically to the consul KV store, traced as a child span of ctx
+func (s *ConsulStore) kvTxn(ctx context.Context, ops consul.KVTxnOps) (err error) {
+	ctx, span := tracer.Start(ctx, "consul.kv.txn", trace.WithAttributes(attribute.Int("consul.operations", len(ops))))
+	defer func() { endSpan(span, err) }()
+	ok, resp, _, err := s.client.KV().Txn(ops, (&consul.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msgs := []string{}
+		for _, e := range resp.Errors {
+			msgs = append(msgs, e.What)
+		}
+		// The operations are CAS operations, which only fail when a key was modified since it was read
+		return fmt.Errorf("%w: consul transaction rolled back: %s", ErrConcurrentModification, strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+// kvDeleteCAS deletes a key from the consul KV store, traced as a child span of ctx.  It fails
+// with ErrConcurrentModification when the key was modified since it was read as pair.
+func (s *ConsulStore) kvDeleteCAS(ctx context.Context, pair *consul.KVPair) (err error) {
+	ctx, span := tracer.Start(ctx, "consul.kv.delete_cas", trace.WithAttributes(attribute.String("consul.key", pair.Key)))
+	defer func() { endSpan(span, err) }()
+	ok, _, err := s.client.KV().DeleteCAS(pair, (&consul.WriteOptions{}).WithContext(ctx))
+	if err == nil && !ok {
+		err = fmt.Errorf("%w: %s", ErrConcurrentModification, pair.Key)
+	}
+	return
+}
+
+// modifyIndex returns the index at which the key was read, 0 when it doesn't exist as the CAS
+// operations require
+func modifyIndex(pair *consul.KVPair) uint64 {
+	if pair == nil {
+		return 0
+	}
+	return pair.ModifyIndex
+}
+
+// checkPairConditions returns ErrTxnConditionFailed when a condition of ctx on the target group,
+// read as pair, doesn't hold
+func checkPairConditions(ctx context.Context, targetGroup string, pair *consul.KVPair) error {
+	return checkConditions(ctx, targetGroup, func() (*TargetGroup, error) {
+		if pair == nil {
+			return nil, nil
+		}
+		tg := &consulTargetGroup{}
+		if err := json.Unmarshal(pair.Value, tg); err != nil {
+			return nil, fmt.Errorf("Could not unserialize target group data from consul KV store: %s", err)
+		}
+		return tg.targetGroup(targetGroup), nil
+	})
+}
+
+func (s *ConsulStore) getLock(ctx context.Context, targetGroup, lockContents string) (*ConsulLock, error) {
 	lKey := s.getLockKey(targetGroup)
 
 	logger.Logger.Debug("Getting lock key",
@@ -129,7 +255,7 @@ func (s *ConsulStore) getLock(targetGroup, lockContents string) (*ConsulLock, er
 		)
 		return nil, err
 	}
-	if err := l.lock(); err != nil {
+	if err := l.lock(ctx); err != nil {
 		logger.Logger.Error("Could not acquire lock key",
 			zap.String("key", lKey),
 			zap.String("error", fmt.Sprintf("%s", err.Error())),
@@ -139,23 +265,29 @@ func (s *ConsulStore) getLock(targetGroup, lockContents string) (*ConsulLock, er
 	return l, nil
 }
 
-func (s *ConsulStore) AddTargetToGroup(targetGroup, target string) error {
+func (s *ConsulStore) AddTargetToGroup(ctx context.Context, targetGroup, target string) error {
 
-	l, err := s.getLock(targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
-	defer l.unlock() // if not defered, lock acquision will wait indefinitely
+	l, err := s.getLock(ctx, targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+	if err != nil {
+		return err
+	}
+	defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
 
 	key := s.getTargetKey(targetGroup)
 
-	pair, _, err := s.client.KV().Get(key, &consul.QueryOptions{AllowStale: s.allowStale})
+	pair, index, err := s.kvGetIndex(ctx, key)
 	if err != nil {
 		logger.Logger.Error("Could not get target group key",
 			zap.String("key", key),
 			zap.String("error", fmt.Sprintf("%s", err.Error())),
 		)
-		panic(err)
+		return err
+	}
+	if err := checkPairConditions(ctx, targetGroup, pair); err != nil {
+		return err
 	}
 
-	tg := &TargetGroup{}
+	tg := &consulTargetGroup{}
 
 	if pair != nil {
 
@@ -180,28 +312,31 @@ func (s *ConsulStore) AddTargetToGroup(targetGroup, target string) error {
 	if tg.Labels == nil {
 		tg.Labels = map[string]string{}
 	}
+	tg.touch(time.Now().UTC(), pair == nil, index+1)
 	b, err := json.Marshal(tg)
 
 	logger.Logger.Debug("Adding target to consul kv ",
 		zap.String("target", target),
 		zap.String("key", key),
 	)
-	p := &consul.KVPair{Key: key, Value: b}
-	_, err = s.client.KV().Put(p, nil)
-	if err != nil {
+	p := &consul.KVPair{Key: key, Value: b, ModifyIndex: modifyIndex(pair)}
+	if err = s.kvCAS(ctx, p); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *ConsulStore) RemoveTargetFromGroup(targetGroup, target string) error {
+func (s *ConsulStore) RemoveTargetFromGroup(ctx context.Context, targetGroup, target string) error {
 
-	l, err := s.getLock(targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
-	defer l.unlock() // if not defered, lock acquision will wait indefinitely
+	l, err := s.getLock(ctx, targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+	if err != nil {
+		return err
+	}
+	defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
 
 	key := s.getTargetKey(targetGroup)
 
-	pair, _, err := s.client.KV().Get(key, &consul.QueryOptions{AllowStale: s.allowStale})
+	pair, index, err := s.kvGetIndex(ctx, key)
 	if err != nil {
 		logger.Logger.Error("Could not get target group key",
 			zap.String("key", key),
@@ -209,14 +344,17 @@ func (s *ConsulStore) RemoveTargetFromGroup(targetGroup, target string) error {
 		)
 		return err
 	}
+	if err := checkPairConditions(ctx, targetGroup, pair); err != nil {
+		return err
+	}
 
-	tg := &TargetGroup{}
+	tg := &consulTargetGroup{}
 
 	if pair == nil {
 		logger.Logger.Warn("Target group doesn't exist",
 			zap.String("target_group", targetGroup),
 		)
-		return nil
+		return ErrTargetGroupNotFound
 	}
 
 	if err := json.Unmarshal(pair.Value, tg); err != nil {
@@ -242,24 +380,42 @@ func (s *ConsulStore) RemoveTargetFromGroup(targetGroup, target string) error {
 		tg.Labels = map[string]string{}
 	}
 
+	tg.touch(time.Now().UTC(), pair == nil, index+1)
 	b, err := json.Marshal(tg)
 
-	p := &consul.KVPair{Key: key, Value: b}
-	if _, err = s.client.KV().Put(p, nil); err != nil {
+	p := &consul.KVPair{Key: key, Value: b, ModifyIndex: pair.ModifyIndex}
+	if err = s.kvCAS(ctx, p); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *ConsulStore) RemoveTargetGroup(targetGroup string) error {
+func (s *ConsulStore) RemoveTargetGroup(ctx context.Context, targetGroup string) error {
 
-	l, err := s.getLock(targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
-	defer l.unlock() // if not defered, lock acquision will wait indefinitely
+	l, err := s.getLock(ctx, targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+	if err != nil {
+		return err
+	}
+	defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
 
 	key := s.getTargetKey(targetGroup)
 
-	_, err = s.client.KV().Delete(key, &consul.WriteOptions{})
+	pair, err := s.kvGet(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := checkPairConditions(ctx, targetGroup, pair); err != nil {
+		return err
+	}
+	if pair == nil {
+		return ErrTargetGroupNotFound
+	}
+	if err := s.checkTemplatesNotDeleted(ctx, map[string]*TargetGroup{targetGroup: nil}); err != nil {
+		return err
+	}
+
+	err = s.kvDeleteCAS(ctx, pair)
 	if err != nil {
 		logger.Logger.Error("Could note delete target group",
 			zap.String("key", key),
@@ -272,14 +428,17 @@ func (s *ConsulStore) RemoveTargetGroup(targetGroup string) error {
 
 }
 
-func (s *ConsulStore) GetTargetGroupLabels(targetGroup string) (*map[string]string, error) {
+func (s *ConsulStore) GetTargetGroupLabels(ctx context.Context, targetGroup string) (*map[string]string, error) {
 
-	l, err := s.getLock(targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
-	defer l.unlock() // if not defered, lock acquision will wait indefinitely
+	l, err := s.getLock(ctx, targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+	if err != nil {
+		return nil, err
+	}
+	defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
 
 	key := s.getTargetKey(targetGroup)
 
-	pair, _, err := s.client.KV().Get(key, &consul.QueryOptions{AllowStale: s.allowStale})
+	pair, err := s.kvGet(ctx, key)
 	if err != nil {
 		logger.Logger.Error("Could not get target group key",
 			zap.String("key", key),
@@ -288,7 +447,7 @@ func (s *ConsulStore) GetTargetGroupLabels(targetGroup string) (*map[string]stri
 		return nil, err
 	}
 
-	tg := &TargetGroup{}
+	tg := &consulTargetGroup{}
 
 	if pair != nil {
 		if err := json.Unmarshal(pair.Value, tg); err != nil {
@@ -304,23 +463,29 @@ func (s *ConsulStore) GetTargetGroupLabels(targetGroup string) (*map[string]stri
 
 }
 
-func (s *ConsulStore) AddLabelsToGroup(targetGroup string, labels map[string]string) error {
+func (s *ConsulStore) AddLabelsToGroup(ctx context.Context, targetGroup string, labels map[string]string) error {
 
-	l, err := s.getLock(targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
-	defer l.unlock() // if not defered, lock acquision will wait indefinitely
+	l, err := s.getLock(ctx, targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+	if err != nil {
+		return err
+	}
+	defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
 
 	key := s.getTargetKey(targetGroup)
 
-	pair, _, err := s.client.KV().Get(key, &consul.QueryOptions{AllowStale: s.allowStale})
+	pair, index, err := s.kvGetIndex(ctx, key)
 	if err != nil {
 		logger.Logger.Error("Could not get target group key",
 			zap.String("key", key),
 			zap.String("error", fmt.Sprintf("%s", err.Error())),
 		)
-		panic(err)
+		return err
+	}
+	if err := checkPairConditions(ctx, targetGroup, pair); err != nil {
+		return err
 	}
 
-	tg := &TargetGroup{}
+	tg := &consulTargetGroup{}
 
 	if pair != nil {
 		if err := json.Unmarshal(pair.Value, tg); err != nil {
@@ -330,32 +495,38 @@ func (s *ConsulStore) AddLabelsToGroup(targetGroup string, labels map[string]str
 		}
 	}
 
+	if tg.Labels == nil {
+		tg.Labels = map[string]string{}
+	}
 	for k, v := range labels {
 		tg.Labels[k] = v
 	}
 
+	tg.touch(time.Now().UTC(), pair == nil, index+1)
 	b, err := json.Marshal(tg)
 
 	logger.Logger.Debug("Adding target group labels to consul kv ",
 		zap.String("target", targetGroup),
 		zap.String("labels", fmt.Sprintf("%v", labels)),
 	)
-	p := &consul.KVPair{Key: key, Value: b}
-	_, err = s.client.KV().Put(p, nil)
-	if err != nil {
-		panic(err)
+	p := &consul.KVPair{Key: key, Value: b, ModifyIndex: modifyIndex(pair)}
+	if err = s.kvCAS(ctx, p); err != nil {
+		return err
 	}
 	return nil
 }
 
-func (s *ConsulStore) RemoveLabelFromGroup(targetGroup, label string) error {
+func (s *ConsulStore) RemoveLabelFromGroup(ctx context.Context, targetGroup, label string) error {
 
-	l, err := s.getLock(targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
-	defer l.unlock() // if not defered, lock acquision will wait indefinitely
+	l, err := s.getLock(ctx, targetGroup, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+	if err != nil {
+		return err
+	}
+	defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
 
 	key := s.getTargetKey(targetGroup)
 
-	pair, _, err := s.client.KV().Get(key, &consul.QueryOptions{AllowStale: s.allowStale})
+	pair, index, err := s.kvGetIndex(ctx, key)
 	if err != nil {
 		logger.Logger.Error("Could not get target group key",
 			zap.String("key", key),
@@ -363,11 +534,14 @@ func (s *ConsulStore) RemoveLabelFromGroup(targetGroup, label string) error {
 		)
 		return err
 	}
+	if err := checkPairConditions(ctx, targetGroup, pair); err != nil {
+		return err
+	}
 
-	tg := &TargetGroup{}
+	tg := &consulTargetGroup{}
 
 	if pair == nil {
-		return errors.New(fmt.Sprintf("Label %s doesn't exist in target group %s", label, targetGroup))
+		return ErrTargetGroupNotFound
 	}
 
 	if err := json.Unmarshal(pair.Value, tg); err != nil {
@@ -396,92 +570,344 @@ func (s *ConsulStore) RemoveLabelFromGroup(targetGroup, label string) error {
 		tg.Labels = map[string]string{}
 	}
 
+	tg.touch(time.Now().UTC(), pair == nil, index+1)
 	b, err := json.Marshal(tg)
 
-	p := &consul.KVPair{Key: key, Value: b}
-	if _, err = s.client.KV().Put(p, nil); err != nil {
+	p := &consul.KVPair{Key: key, Value: b, ModifyIndex: pair.ModifyIndex}
+	if err = s.kvCAS(ctx, p); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *ConsulStore) Serialize(debug bool) (string, error) {
+// RenameTargetGroup writes the target group under newName and deletes it in a single transaction
+func (s *ConsulStore) RenameTargetGroup(ctx context.Context, targetGroup, newName string) error {
+	return s.combineTargetGroups(ctx, targetGroup, newName, true, func(src, dst *consulTargetGroup, revision uint64) (*consulTargetGroup, error) {
+		if dst != nil {
+			return nil, fmt.Errorf("%w: %s", ErrTargetGroupExists, newName)
+		}
+		src.touch(time.Now().UTC(), false, revision)
+		return src, nil
+	})
+}
 
-	logger.Logger.Info("Getting keys with prefix ",
-		zap.String("prefix", consulKVPrefix),
-	)
-	keys, _, err := s.client.KV().Keys(consulKVPrefix, "", &consul.QueryOptions{AllowStale: s.allowStale})
+func (s *ConsulStore) CopyTargetGroup(ctx context.Context, targetGroup, newName string) error {
+	return s.combineTargetGroups(ctx, targetGroup, newName, false, func(src, dst *consulTargetGroup, revision uint64) (*consulTargetGroup, error) {
+		if dst != nil {
+			return nil, fmt.Errorf("%w: %s", ErrTargetGroupExists, newName)
+		}
+		src.touch(time.Now().UTC(), true, revision)
+		return src, nil
+	})
+}
+
+// MergeTargetGroup writes the merged target group, and deletes the source when requested, in a
+// single transaction
+func (s *ConsulStore) MergeTargetGroup(ctx context.Context, targetGroup, into, policy string, removeSource bool) error {
+	if targetGroup == into {
+		return fmt.Errorf("Cannot merge target group %s into itself", into)
+	}
+	return s.combineTargetGroups(ctx, targetGroup, into, removeSource, func(src, dst *consulTargetGroup, revision uint64) (*consulTargetGroup, error) {
+		if dst == nil {
+			return nil, fmt.Errorf("%w: %s", ErrTargetGroupNotFound, into)
+		}
+		if err := mergeTargetGroup(&dst.TargetGroup, &src.TargetGroup, policy); err != nil {
+			return nil, err
+		}
+		dst.touch(time.Now().UTC(), false, revision)
+		return dst, nil
+	})
+}
+
+// combineTargetGroups holds the locks of both target groups while it writes the group computed
+// by fn to dstName, and deletes srcName when removeSource is set, in a single transaction.  fn is
+// called with the current groups, dst being nil when it doesn't exist, and the revision of the
+// change.  The transaction fails if either key was modified since it was read.
+func (s *ConsulStore) combineTargetGroups(ctx context.Context, srcName, dstName string, removeSource bool, fn func(src, dst *consulTargetGroup, revision uint64) (*consulTargetGroup, error)) error {
+	// The locks are always acquired in the same order, so that concurrent operations on the same
+	// groups can't deadlock
+	names := []string{srcName, dstName}
+	sort.Strings(names)
+	for _, name := range names {
+		l, err := s.getLock(ctx, name, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+		if err != nil {
+			return err
+		}
+		defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
+	}
+
+	srcKey, dstKey := s.getTargetKey(srcName), s.getTargetKey(dstName)
+	srcPair, srcIndex, err := s.kvGetIndex(ctx, srcKey)
 	if err != nil {
-		return "", nil
+		return err
+	}
+	if srcPair == nil {
+		return fmt.Errorf("%w: %s", ErrTargetGroupNotFound, srcName)
+	}
+	dstPair, dstIndex, err := s.kvGetIndex(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+	if err := checkPairConditions(ctx, srcName, srcPair); err != nil {
+		return err
+	}
+	if err := checkPairConditions(ctx, dstName, dstPair); err != nil {
+		return err
 	}
 
-	if !debug {
-		targetGroupList := []TargetGroup{}
+	src := &consulTargetGroup{}
+	if err := json.Unmarshal(srcPair.Value, src); err != nil {
+		return fmt.Errorf("Could not unserialize target group data from consul KV store: %s", err)
+	}
+	var dst *consulTargetGroup
+	if dstPair != nil {
+		dst = &consulTargetGroup{}
+		if err := json.Unmarshal(dstPair.Value, dst); err != nil {
+			return fmt.Errorf("Could not unserialize target group data from consul KV store: %s", err)
+		}
+	}
 
-		var keyParts []string
+	res, err := fn(src, dst, max(srcIndex, dstIndex)+1)
+	if err != nil {
+		return err
+	}
+	if res.Labels == nil {
+		res.Labels = map[string]string{}
+	}
+	if removeSource {
+		if err := s.checkTemplatesNotDeleted(ctx, map[string]*TargetGroup{srcName: nil, dstName: &res.TargetGroup}); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
 
-		for _, k := range keys {
+	ops := consul.KVTxnOps{
+		&consul.KVTxnOp{Verb: consul.KVCAS, Key: dstKey, Value: b, Index: modifyIndex(dstPair)},
+	}
+	if removeSource {
+		ops = append(ops, &consul.KVTxnOp{Verb: consul.KVDeleteCAS, Key: srcKey, Index: srcPair.ModifyIndex})
+	}
+	logger.Logger.Debug("Combining target groups",
+		zap.String("source", srcName),
+		zap.String("destination", dstName),
+		zap.Bool("remove_source", removeSource),
+	)
+	return s.kvTxn(ctx, ops)
+}
 
-			logger.Logger.Info("Got key",
-				zap.String("key", k),
-			)
-			pair, _, err := s.client.KV().Get(k, &consul.QueryOptions{AllowStale: s.allowStale})
-			if err != nil {
-				panic(err)
+// ApplyTxn holds the locks of every target group of the transaction while it writes the changed
+// groups in a single consul transaction, which fails if any of them was modified since it was read
+func (s *ConsulStore) ApplyTxn(ctx context.Context, txn *Txn) (map[string]*TargetGroup, error) {
+	txn = txn.withContext(ctx)
+	names := txn.Groups()
+	if len(names) > consulMaxTxnOps {
+		return nil, fmt.Errorf("A transaction can't change more than %d target groups with the consul store", consulMaxTxnOps)
+	}
+	// names are sorted, so that concurrent transactions can't deadlock
+	for _, name := range names {
+		l, err := s.getLock(ctx, name, fmt.Sprintf("{\"set_at\": \"%s\"}", time.Now().String()))
+		if err != nil {
+			return nil, err
+		}
+		defer l.unlock(ctx) // if not defered, lock acquision will wait indefinitely
+	}
+
+	pairs := map[string]*consul.KVPair{}
+	groups := map[string]*TargetGroup{}
+	// The changes are made after every index read, which the versions of the groups exceed
+	var revision uint64
+	for _, name := range names {
+		pair, index, err := s.kvGetIndex(ctx, s.getTargetKey(name))
+		if err != nil {
+			return nil, err
+		}
+		revision = max(revision, index+1)
+		pairs[name] = pair
+		groups[name] = nil
+		if pair != nil {
+			tg := &consulTargetGroup{}
+			if err := json.Unmarshal(pair.Value, tg); err != nil {
+				return nil, fmt.Errorf("Could not unserialize target group data from consul KV store: %s", err)
 			}
+			groups[name] = tg.targetGroup(name)
+		}
+	}
+
+	changed, err := txn.apply(groups)
+	if err != nil {
+		return nil, err
+	}
+	changedGroups := map[string]*TargetGroup{}
+	deletes := false
+	for name := range changed {
+		changedGroups[name] = groups[name]
+		deletes = deletes || groups[name] == nil
+	}
+	if deletes {
+		if err := s.checkTemplatesNotDeleted(ctx, changedGroups); err != nil {
+			return nil, err
+		}
+	}
 
+	res := map[string]*TargetGroup{}
+	ops := consul.KVTxnOps{}
+	for name := range changed {
+		key, pair, tg := s.getTargetKey(name), pairs[name], groups[name]
+		res[name] = tg
+		if tg == nil {
 			if pair != nil {
-				keyParts = strings.Split(k, "/")
-				tg := &TargetGroup{Name: keyParts[len(keyParts)-1]}
-				if err := json.Unmarshal(pair.Value, tg); err != nil {
-					logger.Logger.Error("Could not unserialize target group data from consul KV store",
-						zap.String("error", err.Error()),
-					)
-					continue
-				}
-				targetGroupList = append(targetGroupList, *tg)
+				ops = append(ops, &consul.KVTxnOp{Verb: consul.KVDeleteCAS, Key: key, Index: pair.ModifyIndex})
 			}
+			continue
 		}
 
-		res, _ := json.MarshalIndent(targetGroupList, "", "    ")
-		return string(res), nil
+		ctg := &consulTargetGroup{TargetGroup: *tg}
+		// Groups created by the transaction, even if they replace a deleted one, have no metadata
+		created := tg.Metadata == nil
+		if !created {
+			ctg.GroupMetadata = *tg.Metadata
+		}
+		ctg.touch(time.Now().UTC(), created, revision)
+		tg.Metadata = &ctg.GroupMetadata
+		b, err := json.Marshal(ctg)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, &consul.KVTxnOp{Verb: consul.KVCAS, Key: key, Value: b, Index: modifyIndex(pair)})
+	}
+	if len(ops) == 0 {
+		return res, nil
 	}
+	if err := s.kvTxn(ctx, ops); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
 
-	targetGroupList := map[string][]TargetGroup{}
+// checkTemplatesNotDeleted returns ErrTemplateInUse when a target group deleted by the change is
+// still a template of another group, changed being the groups changed by it, nil when deleted.
+// Only the locks of the changed groups are held, so a group setting one of them as a template
+// at the same time isn't detected.
+func (s *ConsulStore) checkTemplatesNotDeleted(ctx context.Context, changed map[string]*TargetGroup) error {
+	groups, err := s.GetTargetGroups(ctx)
+	if err != nil {
+		return err
+	}
+	templates := map[string][]string{}
+	for name, tg := range groups {
+		if len(tg.Templates) > 0 {
+			templates[name] = tg.Templates
+		}
+	}
+	return checkTemplatesInUse(templates, changed)
+}
 
-	var keyParts []string
+// GetTargetGroups returns every target group in the store, keyed by target group name.
+func (s *ConsulStore) GetTargetGroups(ctx context.Context) (map[string]*TargetGroup, error) {
+	prefix := s.getTargetKey("")
 
-	tgList := []TargetGroup{}
+	logger.Logger.Debug("Listing keys with prefix",
+		zap.String("prefix", prefix),
+	)
+	pairs, err := s.kvList(ctx, prefix)
+	if err != nil {
+		logger.Logger.Error("Could not list target group keys",
+			zap.String("prefix", prefix),
+			zap.String("error", err.Error()),
+		)
+		return nil, err
+	}
+	return targetGroupsFromPairs(prefix, pairs), nil
+}
 
-	for _, k := range keys {
+// GetTargetGroup returns the target group along with its metadata
+func (s *ConsulStore) GetTargetGroup(ctx context.Context, targetGroup string) (*TargetGroup, error) {
+	key := s.getTargetKey(targetGroup)
+	pair, err := s.kvGet(ctx, key)
+	if err != nil {
+		logger.Logger.Error("Could not get target group key",
+			zap.String("key", key),
+			zap.String("error", err.Error()),
+		)
+		return nil, err
+	}
+	if pair == nil {
+		return nil, ErrTargetGroupNotFound
+	}
+	tg := &consulTargetGroup{}
+	if err := json.Unmarshal(pair.Value, tg); err != nil {
+		return nil, fmt.Errorf("Could not unserialize target group data from consul KV store: %s", err)
+	}
+	return tg.targetGroup(targetGroup), nil
+}
 
-		logger.Logger.Info("Got key",
-			zap.String("key", k),
+// ListTargetGroups returns the target groups matching the query.  Only the keys of the groups
+// starting with the prefix of the query are read.
+func (s *ConsulStore) ListTargetGroups(ctx context.Context, query *GroupQuery) ([]*TargetGroup, error) {
+	prefix := s.getTargetKey("")
+	pairs, err := s.kvList(ctx, prefix+query.Prefix)
+	if err != nil {
+		logger.Logger.Error("Could not list target group keys",
+			zap.String("prefix", prefix+query.Prefix),
+			zap.String("error", err.Error()),
 		)
-		pair, _, err := s.client.KV().Get(k, &consul.QueryOptions{AllowStale: s.allowStale})
+		return nil, err
+	}
+	return query.filter(targetGroupsFromPairs(prefix, pairs)), nil
+}
+
+// WatchTargetGroups runs blocking queries on the target group keys until their index differs
+// from revision.  The consul index is used as the revision.
+func (s *ConsulStore) WatchTargetGroups(ctx context.Context, revision uint64) (map[string]*TargetGroup, uint64, error) {
+	prefix := s.getTargetKey("")
+	for {
+		opts := &consul.QueryOptions{
+			AllowStale: s.allowStale,
+			WaitIndex:  revision,
+			WaitTime:   5 * time.Minute,
+		}
+		pairs, meta, err := s.client.KV().List(prefix, opts.WithContext(ctx))
 		if err != nil {
-			panic(err)
+			return nil, revision, err
 		}
-
-		if pair != nil {
-			keyParts = strings.Split(k, "/")
-			groupName := keyParts[len(keyParts)-1]
-			tg := &TargetGroup{Name: groupName}
-			if err := json.Unmarshal(pair.Value, tg); err != nil {
-				logger.Logger.Error("Could not unserialize target group data from consul KV store",
-					zap.String("error", err.Error()),
-				)
-				continue
-			}
-			tgList = append(tgList, *tg)
-			targetGroupList[groupName] = tgList
+		// The query returns the same index when it times out without any change
+		if meta.LastIndex != revision {
+			return targetGroupsFromPairs(prefix, pairs), meta.LastIndex, nil
 		}
 	}
+}
 
-	res, _ := json.MarshalIndent(targetGroupList, "", "    ")
-	return string(res), nil
+// targetGroupsFromPairs decodes the target groups stored in the KV pairs under the prefix
+func targetGroupsFromPairs(prefix string, pairs consul.KVPairs) map[string]*TargetGroup {
+	groups := map[string]*TargetGroup{}
+	for _, pair := range pairs {
+		groupName := strings.TrimPrefix(pair.Key, prefix)
+		if groupName == "" {
+			continue
+		}
+		tg := &consulTargetGroup{}
+		if err := json.Unmarshal(pair.Value, tg); err != nil {
+			logger.Logger.Error("Could not unserialize target group data from consul KV store",
+				zap.String("key", pair.Key),
+				zap.String("error", err.Error()),
+			)
+			continue
+		}
+		groups[groupName] = tg.targetGroup(groupName)
+	}
+	return groups
+}
 
+func (s *ConsulStore) Serialize(ctx context.Context, debug bool) (string, error) {
+	groups, err := s.GetTargetGroups(ctx)
+	if err != nil {
+		return "", err
+	}
+	return serializeTargetGroups(groups, debug)
 }
 
 func (s *ConsulStore) Shutdown() {
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+)
+
+// GroupDiff describes the changes required to bring a target group from its current
+// state to its desired state.
+type GroupDiff struct {
+	Name           string            `json:"name" yaml:"name"`
+	AddedTargets   []string          `json:"added_targets,omitempty" yaml:"added_targets,omitempty"`
+	RemovedTargets []string          `json:"removed_targets,omitempty" yaml:"removed_targets,omitempty"`
+	SetLabels      map[string]string `json:"set_labels,omitempty" yaml:"set_labels,omitempty"`
+	RemovedLabels  []string          `json:"removed_labels,omitempty" yaml:"removed_labels,omitempty"`
+	// SetTemplates replaces the templates of the group, while RemovedTemplates lists those
+	// removed when the desired group has none
+	SetTemplates     []string `json:"set_templates,omitempty" yaml:"set_templates,omitempty"`
+	RemovedTemplates []string `json:"removed_templates,omitempty" yaml:"removed_templates,omitempty"`
+	// Created is set when the group doesn't exist yet, and Deleted when it doesn't exist in the
+	// desired state
+	Created bool `json:"created,omitempty" yaml:"created,omitempty"`
+	Deleted bool `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+}
+
+// Empty returns true when the diff doesn't contain any changes.
+func (d *GroupDiff) Empty() bool {
+	return !d.Created && !d.Deleted && len(d.AddedTargets) == 0 && len(d.RemovedTargets) == 0 && len(d.SetLabels) == 0 && len(d.RemovedLabels) == 0 &&
+		len(d.SetTemplates) == 0 && len(d.RemovedTemplates) == 0
+}
+
+// String returns a human readable representation of the diff, one change per line.
+func (d *GroupDiff) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "target group %s\n", d.Name)
+	if d.Created {
+		sb.WriteString("  + group\n")
+	}
+	if d.Deleted {
+		sb.WriteString("  - group\n")
+	}
+	for _, t := range d.AddedTargets {
+		fmt.Fprintf(&sb, "  + target %s\n", t)
+	}
+	for _, t := range d.RemovedTargets {
+		fmt.Fprintf(&sb, "  - target %s\n", t)
+	}
+	labelNames := make([]string, 0, len(d.SetLabels))
+	for k := range d.SetLabels {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+	for _, k := range labelNames {
+		fmt.Fprintf(&sb, "  + label %s=%s\n", k, d.SetLabels[k])
+	}
+	for _, k := range d.RemovedLabels {
+		fmt.Fprintf(&sb, "  - label %s\n", k)
+	}
+	if len(d.SetTemplates) > 0 {
+		fmt.Fprintf(&sb, "  ~ templates %s\n", strings.Join(d.SetTemplates, ", "))
+	}
+	for _, t := range d.RemovedTemplates {
+		fmt.Fprintf(&sb, "  - template %s\n", t)
+	}
+	return sb.String()
+}
+
+// DiffTargetGroups compares the current target groups against the desired ones and
+// returns the non-empty differences, sorted by target group name.
+func DiffTargetGroups(current, desired map[string]*TargetGroup) []*GroupDiff {
+	names := map[string]bool{}
+	for name := range current {
+		names[name] = true
+	}
+	for name := range desired {
+		names[name] = true
+	}
+
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	diffs := []*GroupDiff{}
+	for _, name := range sortedNames {
+		cur, want := current[name], desired[name]
+		d := &GroupDiff{Name: name, SetLabels: map[string]string{}, Created: cur == nil, Deleted: want == nil}
+		if cur == nil {
+			cur = &TargetGroup{Name: name}
+		}
+		if want == nil {
+			want = &TargetGroup{Name: name}
+		}
+
+		for _, t := range want.Targets {
+			if !lib.Contains(cur.Targets, t) {
+				d.AddedTargets = append(d.AddedTargets, t)
+			}
+		}
+		for _, t := range cur.Targets {
+			if !lib.Contains(want.Targets, t) {
+				d.RemovedTargets = append(d.RemovedTargets, t)
+			}
+		}
+		for k, v := range want.Labels {
+			if curVal, ok := cur.Labels[k]; !ok || curVal != v {
+				d.SetLabels[k] = v
+			}
+		}
+		for k := range cur.Labels {
+			if _, ok := want.Labels[k]; !ok {
+				d.RemovedLabels = append(d.RemovedLabels, k)
+			}
+		}
+		if len(want.Templates) > 0 && !slices.Equal(cur.Templates, want.Templates) {
+			d.SetTemplates = want.Templates
+		} else if len(want.Templates) == 0 {
+			d.RemovedTemplates = cur.Templates
+		}
+		sort.Strings(d.AddedTargets)
+		sort.Strings(d.RemovedTargets)
+		sort.Strings(d.RemovedLabels)
+
+		if !d.Empty() {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// Operations returns the operations of a transaction applying the diff.  Removals, including
+// the removal of the templates and of the deleted group, are only included when prune is set.
+func (d *GroupDiff) Operations(prune bool) []*TxnOp {
+	ops := []*TxnOp{}
+	if d.Deleted {
+		if prune {
+			ops = append(ops, &TxnOp{Op: TxnDeleteGroup, Group: d.Name})
+		}
+		return ops
+	}
+	for _, t := range d.AddedTargets {
+		ops = append(ops, &TxnOp{Op: TxnAddTarget, Group: d.Name, Target: t})
+	}
+	labelNames := make([]string, 0, len(d.SetLabels))
+	for k := range d.SetLabels {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+	for _, k := range labelNames {
+		ops = append(ops, &TxnOp{Op: TxnSetLabel, Group: d.Name, Label: k, Value: d.SetLabels[k]})
+	}
+	if len(d.SetTemplates) > 0 {
+		ops = append(ops, &TxnOp{Op: TxnSetTemplates, Group: d.Name, Templates: d.SetTemplates})
+	}
+	if d.Created && len(ops) == 0 {
+		// Setting no templates creates the group without targets or labels
+		ops = append(ops, &TxnOp{Op: TxnSetTemplates, Group: d.Name, Templates: []string{}})
+	}
+	if !prune {
+		return ops
+	}
+	if len(d.RemovedTemplates) > 0 {
+		ops = append(ops, &TxnOp{Op: TxnSetTemplates, Group: d.Name, Templates: []string{}})
+	}
+	for _, t := range d.RemovedTargets {
+		ops = append(ops, &TxnOp{Op: TxnRemoveTarget, Group: d.Name, Target: t})
+	}
+	for _, k := range d.RemovedLabels {
+		ops = append(ops, &TxnOp{Op: TxnDeleteLabel, Group: d.Name, Label: k})
+	}
+	return ops
+}
+
+// ApplyGroupDiff applies the diff to the given data store.  Removals, including the deletion of
+// the group, are only applied when prune is set, otherwise existing target groups, targets and
+// labels are left untouched.
+func ApplyGroupDiff(ctx context.Context, ds DataStore, d *GroupDiff, prune bool) error {
+	if d.Deleted {
+		if !prune {
+			return nil
+		}
+		if err := ds.RemoveTargetGroup(ctx, d.Name); err != nil {
+			return fmt.Errorf("Could not delete group %s: %s", d.Name, err)
+		}
+		return nil
+	}
+	for _, t := range d.AddedTargets {
+		if err := ds.AddTargetToGroup(ctx, d.Name, t); err != nil {
+			return fmt.Errorf("Could not add target %s to group %s: %s", t, d.Name, err)
+		}
+	}
+	if len(d.SetLabels) > 0 {
+		if err := ds.AddLabelsToGroup(ctx, d.Name, d.SetLabels); err != nil {
+			return fmt.Errorf("Could not set labels on group %s: %s", d.Name, err)
+		}
+	}
+	// A group without targets or labels is created by setting its templates
+	created := d.Created && len(d.AddedTargets) == 0 && len(d.SetLabels) == 0
+	if len(d.SetTemplates) > 0 || (prune && len(d.RemovedTemplates) > 0) || created {
+		op := &TxnOp{Op: TxnSetTemplates, Group: d.Name, Templates: d.SetTemplates}
+		if _, err := ds.ApplyTxn(ctx, &Txn{Operations: []*TxnOp{op}}); err != nil {
+			return fmt.Errorf("Could not set templates of group %s: %s", d.Name, err)
+		}
+	}
+	if !prune {
+		return nil
+	}
+	for _, t := range d.RemovedTargets {
+		if err := ds.RemoveTargetFromGroup(ctx, d.Name, t); err != nil {
+			return fmt.Errorf("Could not remove target %s from group %s: %s", t, d.Name, err)
+		}
+	}
+	for _, l := range d.RemovedLabels {
+		if err := ds.RemoveLabelFromGroup(ctx, d.Name, l); err != nil {
+			return fmt.Errorf("Could not remove label %s from group %s: %s", l, d.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestApplyDiffCreatesAndDeletesGroups(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltDBStore(t)
+	_, err := s.ApplyTxn(ctx, &Txn{Operations: []*TxnOp{
+		{Op: TxnSetLabel, Group: "base", Label: "env", Value: "prod"},
+		{Op: TxnAddTarget, Group: "old", Target: "a:80"},
+		{Op: TxnSetTemplates, Group: "old", Templates: []string{"base"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := s.GetTargetGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := map[string]*TargetGroup{"empty": {Name: "empty"}}
+
+	diffs := DiffTargetGroups(current, desired)
+	if len(diffs) != 3 || !diffs[0].Deleted || !diffs[1].Created || !diffs[2].Deleted {
+		t.Fatalf("got diffs %v", diffs)
+	}
+	// The deletions are only applied when pruning
+	txn := &Txn{}
+	for _, d := range diffs {
+		txn.Operations = append(txn.Operations, d.Operations(false)...)
+	}
+	if len(txn.Operations) != 1 || txn.Operations[0].Group != "empty" {
+		t.Fatalf("got operations %v", txn.Operations)
+	}
+
+	txn = &Txn{}
+	for _, d := range diffs {
+		txn.Operations = append(txn.Operations, d.Operations(true)...)
+	}
+	if _, err := s.ApplyTxn(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := s.GetTargetGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups["empty"] == nil || len(groups["empty"].Targets) != 0 || len(groups["empty"].Labels) != 0 {
+		t.Errorf("got groups %v", groups)
+	}
+	if remaining := DiffTargetGroups(groups, desired); len(remaining) != 0 {
+		t.Errorf("got remaining diffs %v", remaining)
+	}
+
+	// ApplyGroupDiff creates the empty group likewise
+	if err := ApplyGroupDiff(ctx, s, &GroupDiff{Name: "empty-2", Created: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTargetGroup(ctx, "empty-2"); err != nil {
+		t.Error(err)
+	}
+	if err := ApplyGroupDiff(ctx, s, &GroupDiff{Name: "empty-2", Deleted: true}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTargetGroup(ctx, "empty-2"); !errors.Is(err, ErrTargetGroupNotFound) {
+		t.Errorf("empty-2 wasn't deleted: %v", err)
+	}
+}
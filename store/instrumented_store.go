@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	metricStoreOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "httpsdserver_store_operation_duration_seconds",
+		Help: "Duration of data store operations.",
+	}, []string{"operation", "backend"})
+	metricStoreOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpsdserver_store_operation_errors_total",
+		Help: "Number of data store operations which have failed.",
+	}, []string{"operation", "backend"})
+
+	descTargetGroups = prometheus.NewDesc(
+		"httpsdserver_target_groups",
+		"Number of target groups in the data store.",
+		[]string{"backend"}, nil,
+	)
+	descTargets = prometheus.NewDesc(
+		"httpsdserver_targets",
+		"Number of targets in the data store, across all target groups.",
+		[]string{"backend"}, nil,
+	)
+	descLabels = prometheus.NewDesc(
+		"httpsdserver_labels",
+		"Number of labels in the data store, across all target groups.",
+		[]string{"backend"}, nil,
+	)
+	descStoreUp = prometheus.NewDesc(
+		"httpsdserver_store_up",
+		"Whether the data store could be read the last time the counts were refreshed.",
+		[]string{"backend"}, nil,
+	)
+)
+
+// countsTTL is how long the counts of target groups, targets and labels are cached, so that
+// scrapes don't read the whole data store every time
+const countsTTL = 30 * time.Second
+
+// InstrumentedStore is a DataStore decorator which records the latency and errors of every
+// operation of the underlying store, and traces each operation as a span.  It's also a
+// prometheus.Collector exposing the number of target groups, targets and labels in the store.
+type InstrumentedStore struct {
+	next    DataStore
+	backend string
+
+	mu     sync.Mutex
+	counts storeCounts
+}
+
+// storeCounts are the counts exposed by Collect, read from the store at readAt
+type storeCounts struct {
+	readAt                  time.Time
+	up                      bool
+	groups, targets, labels int
+}
+
+// NewInstrumentedStore wraps the data store, labelling its metrics with the backend name
+func NewInstrumentedStore(ds DataStore, backend string) *InstrumentedStore {
+	return &InstrumentedStore{
+		next:    ds,
+		backend: backend,
+	}
+}
+
+// Unwrap returns the underlying data store
+func (s *InstrumentedStore) Unwrap() DataStore {
+	return s.next
+}
+
+// start starts a span for the operation and returns the function which must be called with
+// the result of the operation to record its metrics and end the span.
+func (s *InstrumentedStore) start(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "store."+operation, trace.WithAttributes(attribute.String("store.backend", s.backend)))
+	return ctx, func(err error) {
+		metricStoreOperationDuration.WithLabelValues(operation, s.backend).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metricStoreOperationErrors.WithLabelValues(operation, s.backend).Inc()
+		}
+		endSpan(span, err)
+	}
+}
+
+func (s *InstrumentedStore) AddTargetToGroup(ctx context.Context, targetGroup, target string) (err error) {
+	ctx, done := s.start(ctx, "add_target_to_group")
+	defer func() { done(err) }()
+	return s.next.AddTargetToGroup(ctx, targetGroup, target)
+}
+
+func (s *InstrumentedStore) RemoveTargetFromGroup(ctx context.Context, targetGroup, target string) (err error) {
+	ctx, done := s.start(ctx, "remove_target_from_group")
+	defer func() { done(err) }()
+	return s.next.RemoveTargetFromGroup(ctx, targetGroup, target)
+}
+
+func (s *InstrumentedStore) RemoveTargetGroup(ctx context.Context, targetGroup string) (err error) {
+	ctx, done := s.start(ctx, "remove_target_group")
+	defer func() { done(err) }()
+	return s.next.RemoveTargetGroup(ctx, targetGroup)
+}
+
+func (s *InstrumentedStore) GetTargetGroupLabels(ctx context.Context, targetGroup string) (labels *map[string]string, err error) {
+	ctx, done := s.start(ctx, "get_target_group_labels")
+	defer func() { done(err) }()
+	return s.next.GetTargetGroupLabels(ctx, targetGroup)
+}
+
+func (s *InstrumentedStore) GetTargetGroups(ctx context.Context) (groups map[string]*TargetGroup, err error) {
+	ctx, done := s.start(ctx, "get_target_groups")
+	defer func() { done(err) }()
+	return s.next.GetTargetGroups(ctx)
+}
+
+func (s *InstrumentedStore) GetTargetGroup(ctx context.Context, targetGroup string) (tg *TargetGroup, err error) {
+	ctx, done := s.start(ctx, "get_target_group")
+	defer func() { done(err) }()
+	return s.next.GetTargetGroup(ctx, targetGroup)
+}
+
+func (s *InstrumentedStore) ListTargetGroups(ctx context.Context, query *GroupQuery) (groups []*TargetGroup, err error) {
+	ctx, done := s.start(ctx, "list_target_groups")
+	defer func() { done(err) }()
+	return s.next.ListTargetGroups(ctx, query)
+}
+
+func (s *InstrumentedStore) AddLabelsToGroup(ctx context.Context, targetGroup string, labels map[string]string) (err error) {
+	ctx, done := s.start(ctx, "add_labels_to_group")
+	defer func() { done(err) }()
+	return s.next.AddLabelsToGroup(ctx, targetGroup, labels)
+}
+
+func (s *InstrumentedStore) RemoveLabelFromGroup(ctx context.Context, targetGroup, label string) (err error) {
+	ctx, done := s.start(ctx, "remove_label_from_group")
+	defer func() { done(err) }()
+	return s.next.RemoveLabelFromGroup(ctx, targetGroup, label)
+}
+
+func (s *InstrumentedStore) RenameTargetGroup(ctx context.Context, targetGroup, newName string) (err error) {
+	ctx, done := s.start(ctx, "rename_target_group")
+	defer func() { done(err) }()
+	return s.next.RenameTargetGroup(ctx, targetGroup, newName)
+}
+
+func (s *InstrumentedStore) CopyTargetGroup(ctx context.Context, targetGroup, newName string) (err error) {
+	ctx, done := s.start(ctx, "copy_target_group")
+	defer func() { done(err) }()
+	return s.next.CopyTargetGroup(ctx, targetGroup, newName)
+}
+
+func (s *InstrumentedStore) MergeTargetGroup(ctx context.Context, targetGroup, into, policy string, removeSource bool) (err error) {
+	ctx, done := s.start(ctx, "merge_target_group")
+	defer func() { done(err) }()
+	return s.next.MergeTargetGroup(ctx, targetGroup, into, policy, removeSource)
+}
+
+func (s *InstrumentedStore) ApplyTxn(ctx context.Context, txn *Txn) (groups map[string]*TargetGroup, err error) {
+	ctx, done := s.start(ctx, "apply_txn")
+	defer func() { done(err) }()
+	return s.next.ApplyTxn(ctx, txn)
+}
+
+func (s *InstrumentedStore) Serialize(ctx context.Context, debug bool) (res string, err error) {
+	ctx, done := s.start(ctx, "serialize")
+	defer func() { done(err) }()
+	return s.next.Serialize(ctx, debug)
+}
+
+// WatchTargetGroups isn't instrumented, as it blocks until the store changes
+func (s *InstrumentedStore) WatchTargetGroups(ctx context.Context, revision uint64) (map[string]*TargetGroup, uint64, error) {
+	return s.next.WatchTargetGroups(ctx, revision)
+}
+
+func (s *InstrumentedStore) Shutdown() {
+	s.next.Shutdown()
+}
+
+// Describe implements prometheus.Collector
+func (s *InstrumentedStore) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descTargetGroups
+	ch <- descTargets
+	ch <- descLabels
+	ch <- descStoreUp
+}
+
+// Collect implements prometheus.Collector.  The counts are read from the store when the cached
+// ones are older than countsTTL.
+func (s *InstrumentedStore) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	if time.Since(s.counts.readAt) >= countsTTL {
+		s.counts = s.readCounts()
+	}
+	counts := s.counts
+	s.mu.Unlock()
+
+	if !counts.up {
+		ch <- prometheus.MustNewConstMetric(descStoreUp, prometheus.GaugeValue, 0, s.backend)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(descStoreUp, prometheus.GaugeValue, 1, s.backend)
+	ch <- prometheus.MustNewConstMetric(descTargetGroups, prometheus.GaugeValue, float64(counts.groups), s.backend)
+	ch <- prometheus.MustNewConstMetric(descTargets, prometheus.GaugeValue, float64(counts.targets), s.backend)
+	ch <- prometheus.MustNewConstMetric(descLabels, prometheus.GaugeValue, float64(counts.labels), s.backend)
+}
+
+// readCounts counts the target groups, targets and labels of the store
+func (s *InstrumentedStore) readCounts() storeCounts {
+	counts := storeCounts{readAt: time.Now()}
+	groups, err := s.next.GetTargetGroups(context.Background())
+	if err != nil {
+		return counts
+	}
+	counts.up = true
+	counts.groups = len(groups)
+	for _, tg := range groups {
+		counts.targets += len(tg.Targets)
+		counts.labels += len(tg.Labels)
+	}
+	return counts
+}
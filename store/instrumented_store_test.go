@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeStore is a DataStore whose target groups and errors are set by the tests
+type fakeStore struct {
+	DataStore
+	groups map[string]*TargetGroup
+	err    error
+	reads  int
+}
+
+func (f *fakeStore) GetTargetGroups(ctx context.Context) (map[string]*TargetGroup, error) {
+	f.reads++
+	return f.groups, f.err
+}
+
+func (f *fakeStore) AddTargetToGroup(ctx context.Context, targetGroup, target string) error {
+	return f.err
+}
+
+func TestInstrumentedStoreCollectCachesCounts(t *testing.T) {
+	fake := &fakeStore{groups: map[string]*TargetGroup{
+		"a": {Targets: []string{"a:80", "b:80"}, Labels: map[string]string{"env": "prod"}},
+		"b": {Targets: []string{"c:80"}, Labels: map[string]string{}},
+	}}
+	s := NewInstrumentedStore(fake, "fake")
+
+	want := `
+# HELP httpsdserver_target_groups Number of target groups in the data store.
+# TYPE httpsdserver_target_groups gauge
+httpsdserver_target_groups{backend="fake"} 2
+# HELP httpsdserver_targets Number of targets in the data store, across all target groups.
+# TYPE httpsdserver_targets gauge
+httpsdserver_targets{backend="fake"} 3
+# HELP httpsdserver_store_up Whether the data store could be read the last time the counts were refreshed.
+# TYPE httpsdserver_store_up gauge
+httpsdserver_store_up{backend="fake"} 1
+`
+	names := []string{"httpsdserver_target_groups", "httpsdserver_targets", "httpsdserver_store_up"}
+	for i := 0; i < 3; i++ {
+		if err := testutil.CollectAndCompare(s, strings.NewReader(want), names...); err != nil {
+			t.Fatalf("scrape %d: %s", i, err)
+		}
+	}
+	if fake.reads != 1 {
+		t.Errorf("store read %d times for 3 scrapes, want 1", fake.reads)
+	}
+
+	// Once the counts expire, they're read again
+	fake.groups, fake.err = nil, errors.New("unavailable")
+	s.counts.readAt = time.Now().Add(-countsTTL)
+	want = `
+# HELP httpsdserver_store_up Whether the data store could be read the last time the counts were refreshed.
+# TYPE httpsdserver_store_up gauge
+httpsdserver_store_up{backend="fake"} 0
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.reads != 2 {
+		t.Errorf("store read %d times, want 2", fake.reads)
+	}
+}
+
+func TestInstrumentedStoreSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func(previous trace.Tracer) { tracer = previous }(tracer)
+	tracer = tp.Tracer("test")
+
+	fake := &fakeStore{}
+	s := NewInstrumentedStore(fake, "fake")
+	if err := s.AddTargetToGroup(context.Background(), "a", "a:80"); err != nil {
+		t.Fatal(err)
+	}
+	fake.err = errors.New("unavailable")
+	if err := s.AddTargetToGroup(context.Background(), "a", "a:80"); err == nil {
+		t.Fatal("got no error")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for i, span := range spans {
+		if span.Name != "store.add_target_to_group" {
+			t.Errorf("span %d is named %s", i, span.Name)
+		}
+		if !hasAttribute(span.Attributes, attribute.String("store.backend", "fake")) {
+			t.Errorf("span %d has attributes %v", i, span.Attributes)
+		}
+	}
+	if spans[0].Status.Code != codes.Unset {
+		t.Errorf("got status %v for the successful operation", spans[0].Status)
+	}
+	if spans[1].Status.Code != codes.Error || spans[1].Status.Description != "unavailable" || len(spans[1].Events) != 1 {
+		t.Errorf("got status %v and events %v for the failed operation", spans[1].Status, spans[1].Events)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,61 @@
 package store
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrTargetGroupNotFound is returned when an operation requires a target group which doesn't exist
+var ErrTargetGroupNotFound = errors.New("Target group not found")
+
+// ErrTargetGroupExists is returned when an operation would replace a target group which already
+// exists
+var ErrTargetGroupExists = errors.New("Target group already exists")
+
+// ErrLabelConflict is returned when merging target groups having different values for the same
+// label with the LabelConflictFail policy
+var ErrLabelConflict = errors.New("Conflicting label values")
+
+// ErrConcurrentModification is returned when a target group was modified by another writer while
+// it was being changed, in which case the change isn't applied
+var ErrConcurrentModification = errors.New("Target group modified concurrently")
+
+// DataStore is implemented by every backend which can store target groups.  The context is used
+// for cancellation and to propagate the trace of the request which triggered the operation.  The
+// methods changing target groups only apply their change when the conditions set on the context
+// with WithConditions hold.
 type DataStore interface {
-	AddTargetToGroup(targetGroup, target string) error
-	RemoveTargetFromGroup(targetGroup, target string) error
-	RemoveTargetGroup(targetGroup string) error
-	GetTargetGroupLabels(targetGroup string) (*map[string]string, error)
-	AddLabelsToGroup(targetGroup string, labels map[string]string) error
-	RemoveLabelFromGroup(targetGroup, label string) error
-	Serialize(debug bool) (string, error)
+	AddTargetToGroup(ctx context.Context, targetGroup, target string) error
+	RemoveTargetFromGroup(ctx context.Context, targetGroup, target string) error
+	RemoveTargetGroup(ctx context.Context, targetGroup string) error
+	GetTargetGroupLabels(ctx context.Context, targetGroup string) (*map[string]string, error)
+	GetTargetGroups(ctx context.Context) (map[string]*TargetGroup, error)
+	// GetTargetGroup returns the target group along with its metadata, or ErrTargetGroupNotFound
+	GetTargetGroup(ctx context.Context, targetGroup string) (*TargetGroup, error)
+	// ListTargetGroups returns the target groups matching the query, sorted by name, along with
+	// their metadata
+	ListTargetGroups(ctx context.Context, query *GroupQuery) ([]*TargetGroup, error)
+	AddLabelsToGroup(ctx context.Context, targetGroup string, labels map[string]string) error
+	RemoveLabelFromGroup(ctx context.Context, targetGroup, label string) error
+	// RenameTargetGroup atomically moves the targets and labels of the target group to newName,
+	// which must not exist
+	RenameTargetGroup(ctx context.Context, targetGroup, newName string) error
+	// CopyTargetGroup creates newName, which must not exist, with the targets, labels and templates
+	// of the target group
+	CopyTargetGroup(ctx context.Context, targetGroup, newName string) error
+	// MergeTargetGroup atomically adds the targets, labels and templates of the target group to the
+	// existing group into, resolving the labels having different values with the policy, one of the
+	// LabelConflict constants.  With removeSource, the target group is removed in the same change.
+	MergeTargetGroup(ctx context.Context, targetGroup, into, policy string, removeSource bool) error
+	// ApplyTxn applies every operation of the transaction in a single change when its conditions
+	// hold, and none otherwise.  It returns the target groups changed by the transaction along
+	// with their metadata, nil for the deleted groups.
+	ApplyTxn(ctx context.Context, txn *Txn) (map[string]*TargetGroup, error)
+	Serialize(ctx context.Context, debug bool) (string, error)
+	// WatchTargetGroups blocks until the revision of the store differs from revision, or the
+	// context is done, then returns every target group along with the current revision.
+	// A revision of 0 returns immediately.
+	WatchTargetGroups(ctx context.Context, revision uint64) (map[string]*TargetGroup, uint64, error)
 	Shutdown()
 }
 
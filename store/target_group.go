@@ -1,11 +1,246 @@
 package store
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+	"gopkg.in/yaml.v2"
+)
+
 type TargetGroup struct {
-	Name    string            `json:"-"`
-	Targets []string          `json:"targets"`
-	Labels  map[string]string `json:"labels"`
+	Name    string            `json:"-" yaml:"-"`
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+	// Templates are the names of the target groups the group inherits labels from, see
+	// EffectiveLabels
+	Templates []string `json:"templates,omitempty" yaml:"templates,omitempty"`
+	// Metadata is only set by the data store methods which document it
+	Metadata *GroupMetadata `json:"-" yaml:"-"`
+}
+
+// GroupMetadata records when a target group was created and last modified, and its version,
+// which increases with every change of the group and is never reused by a group of the same
+// name, even once the group was deleted.  The times and the version are zero when they aren't
+// known, for groups created before they were recorded.
+type GroupMetadata struct {
+	CreatedAt  time.Time `json:"created_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Version    uint64    `json:"version"`
+}
+
+// touch records a modification of the target group at now, which created the group when
+// created is set.  revision is greater than the revision of every earlier change of the data
+// store, so that the version of a group which is deleted and created again keeps increasing
+// and a stale version can't match the new group.
+func (m *GroupMetadata) touch(now time.Time, created bool, revision uint64) {
+	if created {
+		m.CreatedAt = now
+	}
+	m.ModifiedAt = now
+	m.Version++
+	if m.Version < revision {
+		m.Version = revision
+	}
+}
+
+// Policies of MergeTargetGroup for the labels set in both target groups with different values
+const (
+	// LabelConflictKeep keeps the value of the group merged into
+	LabelConflictKeep = "keep"
+	// LabelConflictOverwrite replaces it with the value of the merged group
+	LabelConflictOverwrite = "overwrite"
+	// LabelConflictFail fails the merge with ErrLabelConflict
+	LabelConflictFail = "fail"
+)
+
+// LabelConflictPolicies lists the policies of MergeTargetGroup
+var LabelConflictPolicies = []string{LabelConflictKeep, LabelConflictOverwrite, LabelConflictFail}
+
+// mergeTargetGroup adds the targets and labels of src to dst, resolving the labels having
+// different values with the policy.  dst is left unchanged when the merge fails.
+func mergeTargetGroup(dst, src *TargetGroup, policy string) error {
+	if !lib.Contains(LabelConflictPolicies, policy) {
+		return fmt.Errorf("Unknown label conflict policy '%s'", policy)
+	}
+	conflicts := []string{}
+	for k, v := range src.Labels {
+		if dv, ok := dst.Labels[k]; ok && dv != v {
+			conflicts = append(conflicts, k)
+		}
+	}
+	if policy == LabelConflictFail && len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("%w: %s", ErrLabelConflict, strings.Join(conflicts, ", "))
+	}
+
+	if dst.Labels == nil {
+		dst.Labels = map[string]string{}
+	}
+	for k, v := range src.Labels {
+		if _, ok := dst.Labels[k]; ok && policy == LabelConflictKeep {
+			continue
+		}
+		dst.Labels[k] = v
+	}
+	for _, t := range src.Targets {
+		if !lib.Contains(dst.Targets, t) {
+			dst.Targets = append(dst.Targets, t)
+		}
+	}
+	for _, t := range src.Templates {
+		if !lib.Contains(dst.Templates, t) {
+			dst.Templates = append(dst.Templates, t)
+		}
+	}
+	return nil
+}
+
+// GroupQuery selects the target groups returned by DataStore.ListTargetGroups.  The empty
+// query selects every target group.
+type GroupQuery struct {
+	// Prefix selects the groups whose name starts with it
+	Prefix string
+	// Labels selects the groups having every one of these labels
+	Labels map[string]string
+	// After selects the groups sorted after it, to resume from the last group of a previous page
+	After string
+	// Limit is the maximum number of groups returned, unlimited when 0
+	Limit int
+}
+
+// matchName returns true when a target group with this name may match the query
+func (q *GroupQuery) matchName(name string) bool {
+	return strings.HasPrefix(name, q.Prefix) && name > q.After
+}
+
+func (q *GroupQuery) matchLabels(labels map[string]string) bool {
+	for k, v := range q.Labels {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the target groups matching the query sorted by name, for the data stores
+// which read every target group anyway
+func (q *GroupQuery) filter(groups map[string]*TargetGroup) []*TargetGroup {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		if q.matchName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	res := []*TargetGroup{}
+	for _, name := range names {
+		if !q.matchLabels(groups[name].Labels) {
+			continue
+		}
+		res = append(res, groups[name])
+		if q.Limit > 0 && len(res) == q.Limit {
+			break
+		}
+	}
+	return res
 }
 
 func (ts *TargetGroup) SetLabels(labels map[string]string) {
 	ts.Labels = labels
 }
+
+// normalizeTargetGroups replaces the nil lists of targets and labels with empty ones, so that
+// they are rendered as empty lists rather than null
+func normalizeTargetGroups(groups map[string]*TargetGroup) {
+	for _, tg := range groups {
+		if tg.Targets == nil {
+			tg.Targets = []string{}
+		}
+		if tg.Labels == nil {
+			tg.Labels = map[string]string{}
+		}
+	}
+}
+
+// SortedTargetGroups returns the target groups sorted by name
+func SortedTargetGroups(groups map[string]*TargetGroup) []TargetGroup {
+	normalizeTargetGroups(groups)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := []TargetGroup{}
+	for _, name := range names {
+		data = append(data, *groups[name])
+	}
+	return data
+}
+
+// MarshalTargetGroups renders the target groups in the format expected by Prometheus, either
+// as JSON, like the HTTP SD endpoint, or as YAML, which file_sd also accepts.  The groups without
+// targets, such as templates, are left out since Prometheus has nothing to scrape in them.
+func MarshalTargetGroups(groups map[string]*TargetGroup, format string) ([]byte, error) {
+	data := []TargetGroup{}
+	for _, tg := range SortedTargetGroups(groups) {
+		if len(tg.Targets) > 0 {
+			data = append(data, tg)
+		}
+	}
+	switch format {
+	case "json":
+		return json.MarshalIndent(data, "", "    ")
+	case "yaml":
+		return yaml.Marshal(data)
+	default:
+		return nil, fmt.Errorf("Unsupported format '%s'", format)
+	}
+}
+
+// debugTargetGroup is a target group in the debug view.  The effective labels of the groups
+// having templates are listed along with the group each of them comes from.
+type debugTargetGroup struct {
+	Targets         []string          `json:"targets"`
+	Labels          map[string]string `json:"labels"`
+	Templates       []string          `json:"templates,omitempty"`
+	EffectiveLabels map[string]string `json:"effective_labels,omitempty"`
+	LabelSources    map[string]string `json:"label_sources,omitempty"`
+}
+
+// serializeTargetGroups renders the target groups either in the HTTP SD format expected by
+// Prometheus, with their effective labels, or, in debug mode, as a map keyed by target group
+// name.
+func serializeTargetGroups(groups map[string]*TargetGroup, debug bool) (string, error) {
+	if !debug {
+		res, err := MarshalTargetGroups(ResolveTemplates(groups), "json")
+		if err != nil {
+			return "", err
+		}
+		return string(res), nil
+	}
+
+	// in this case, return a debug view of the data which shows the target group names
+	normalizeTargetGroups(groups)
+	debugGroups := map[string]*debugTargetGroup{}
+	for name, tg := range groups {
+		g := &debugTargetGroup{Targets: tg.Targets, Labels: tg.Labels, Templates: tg.Templates}
+		if len(tg.Templates) > 0 {
+			g.EffectiveLabels, g.LabelSources = EffectiveLabels(groups, name)
+		}
+		debugGroups[name] = g
+	}
+	dataDebug := map[string]map[string]*debugTargetGroup{
+		"targets": debugGroups,
+	}
+	res, err := json.MarshalIndent(dataDebug, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
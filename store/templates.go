@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+)
+
+// ErrTemplateInUse is returned when deleting or renaming a target group which is a template of
+// other groups, which would silently change their effective labels
+var ErrTemplateInUse = errors.New("Target group is used as a template")
+
+// EffectiveLabels returns the labels of the target group layered over those of its templates,
+// along with the name of the group each label comes from.  The templates are resolved
+// recursively, each one overriding the labels of the previous ones, and the own labels of the
+// group override them all.  Templates which don't exist, or which are already being resolved
+// because of a cycle, are ignored.
+func EffectiveLabels(groups map[string]*TargetGroup, name string) (map[string]string, map[string]string) {
+	labels, sources := map[string]string{}, map[string]string{}
+	layerLabels(groups, name, labels, sources, map[string]bool{})
+	return labels, sources
+}
+
+func layerLabels(groups map[string]*TargetGroup, name string, labels, sources map[string]string, visiting map[string]bool) {
+	tg := groups[name]
+	if tg == nil || visiting[name] {
+		return
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	for _, t := range tg.Templates {
+		layerLabels(groups, t, labels, sources, visiting)
+	}
+	for k, v := range tg.Labels {
+		labels[k] = v
+		sources[k] = name
+	}
+}
+
+// ResolveTemplates returns copies of the target groups whose labels are their effective
+// labels, without templates, as they're exposed to Prometheus
+func ResolveTemplates(groups map[string]*TargetGroup) map[string]*TargetGroup {
+	res := make(map[string]*TargetGroup, len(groups))
+	for name, tg := range groups {
+		labels := tg.Labels
+		if len(tg.Templates) > 0 {
+			labels, _ = EffectiveLabels(groups, name)
+		}
+		res[name] = &TargetGroup{Name: name, Targets: tg.Targets, Labels: labels, Metadata: tg.Metadata}
+	}
+	return res
+}
+
+// LoadTemplates returns the target groups along with the templates they inherit from, read
+// recursively from the data store, keyed by name, so that their EffectiveLabels can be computed.
+// Templates which don't exist are left out.
+func LoadTemplates(ctx context.Context, ds DataStore, groups ...*TargetGroup) (map[string]*TargetGroup, error) {
+	res := make(map[string]*TargetGroup, len(groups))
+	pending := []string{}
+	for _, tg := range groups {
+		res[tg.Name] = tg
+		pending = append(pending, tg.Templates...)
+	}
+	missing := map[string]bool{}
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if _, ok := res[name]; ok || missing[name] {
+			continue
+		}
+		tg, err := ds.GetTargetGroup(ctx, name)
+		if errors.Is(err, ErrTargetGroupNotFound) {
+			missing[name] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tg.Name = name
+		res[name] = tg
+		pending = append(pending, tg.Templates...)
+	}
+	return res, nil
+}
+
+// checkTemplatesInUse returns ErrTemplateInUse when a target group deleted by a change is still
+// a template of another group after it.  templates are the templates of the groups before the
+// change, which is modified, and changed the groups changed by it, nil for the deleted ones.
+func checkTemplatesInUse(templates map[string][]string, changed map[string]*TargetGroup) error {
+	deleted := []string{}
+	for name, tg := range changed {
+		if tg == nil {
+			deleted = append(deleted, name)
+			delete(templates, name)
+		} else {
+			templates[name] = tg.Templates
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		dependents := []string{}
+		for g, ts := range templates {
+			if lib.Contains(ts, name) {
+				dependents = append(dependents, g)
+			}
+		}
+		if len(dependents) > 0 {
+			sort.Strings(dependents)
+			return fmt.Errorf("%w: %s is a template of %s", ErrTemplateInUse, name, strings.Join(dependents, ", "))
+		}
+	}
+	return nil
+}
+
+// CheckTemplates returns an error when the target group can't use the templates, because one
+// of them doesn't exist or would make the group inherit from itself.  groups are the current
+// target groups, with the templates they would have after the change.
+func CheckTemplates(groups map[string]*TargetGroup, name string, templates []string) error {
+	for _, t := range templates {
+		if t == name {
+			return fmt.Errorf("Target group %s can't be its own template", name)
+		}
+		if groups[t] == nil {
+			return fmt.Errorf("Template %s of target group %s doesn't exist", t, name)
+		}
+		if path := templatePath(groups, t, name, map[string]bool{}); path != nil {
+			return fmt.Errorf("Template %s of target group %s inherits from it: %s", t, name, strings.Join(append([]string{name}, path...), " -> "))
+		}
+	}
+	return nil
+}
+
+// templatePath returns the chain of templates leading from the group to target, or nil when
+// the group doesn't inherit from target
+func templatePath(groups map[string]*TargetGroup, from, target string, seen map[string]bool) []string {
+	if from == target {
+		return []string{from}
+	}
+	tg := groups[from]
+	if tg == nil || seen[from] {
+		return nil
+	}
+	seen[from] = true
+	for _, t := range tg.Templates {
+		if path := templatePath(groups, t, target, seen); path != nil {
+			return append([]string{from}, path...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBoltDBStoreRejectsRemovingTemplates(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltDBStore(t)
+	_, err := s.ApplyTxn(ctx, &Txn{Operations: []*TxnOp{
+		{Op: TxnSetLabel, Group: "base", Label: "env", Value: "prod"},
+		{Op: TxnAddTarget, Group: "web", Target: "a:80"},
+		{Op: TxnSetTemplates, Group: "web", Templates: []string{"base"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveTargetGroup(ctx, "base"); !errors.Is(err, ErrTemplateInUse) {
+		t.Errorf("removing: got error %v, want %v", err, ErrTemplateInUse)
+	}
+	if err := s.RenameTargetGroup(ctx, "base", "base-2"); !errors.Is(err, ErrTemplateInUse) {
+		t.Errorf("renaming: got error %v, want %v", err, ErrTemplateInUse)
+	}
+	if _, err := s.GetTargetGroup(ctx, "base"); err != nil {
+		t.Fatalf("base was removed: %v", err)
+	}
+
+	// The template can be deleted along with the groups using it
+	_, err = s.ApplyTxn(ctx, &Txn{Operations: []*TxnOp{
+		{Op: TxnSetTemplates, Group: "web", Templates: []string{}},
+		{Op: TxnDeleteGroup, Group: "base"},
+	}})
+	if err != nil {
+		t.Errorf("deleting base unused: %v", err)
+	}
+}
+
+func TestGroupEventsNotifyTemplateDependents(t *testing.T) {
+	previous := map[string]*TargetGroup{
+		"base": {Name: "base", Labels: map[string]string{"env": "prod"}},
+		"web":  {Name: "web", Targets: []string{"a:80"}, Labels: map[string]string{"job": "web"}, Templates: []string{"base"}},
+	}
+	current := map[string]*TargetGroup{
+		"base": {Name: "base", Labels: map[string]string{"env": "staging"}},
+		"web":  previous["web"],
+	}
+
+	events := groupEvents(previous, current, 7)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	e := events[1]
+	if e.Type != EventLabelsChanged || e.Group != "web" {
+		t.Fatalf("got %s event for %s", e.Type, e.Group)
+	}
+	if e.Labels["env"] != "" || e.EffectiveLabels["env"] != "staging" || e.EffectiveLabels["job"] != "web" {
+		t.Errorf("got labels %v, effective labels %v", e.Labels, e.EffectiveLabels)
+	}
+	if e.prevLabels["env"] != "prod" {
+		t.Errorf("got previous labels %v", e.prevLabels)
+	}
+}
+
+func TestSerializeLeavesOutGroupsWithoutTargets(t *testing.T) {
+	ctx := context.Background()
+	s := newTestBoltDBStore(t)
+	_, err := s.ApplyTxn(ctx, &Txn{Operations: []*TxnOp{
+		{Op: TxnSetLabel, Group: "base", Label: "env", Value: "prod"},
+		{Op: TxnAddTarget, Group: "web", Target: "a:80"},
+		{Op: TxnSetTemplates, Group: "web", Templates: []string{"base"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Serialize(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []TargetGroup{}
+	if err := json.Unmarshal([]byte(res), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []TargetGroup{{Targets: []string{"a:80"}, Labels: map[string]string{"env": "prod"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// The debug view still shows the templates
+	res, err = s.Serialize(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug := map[string]map[string]*debugTargetGroup{}
+	if err := json.Unmarshal([]byte(res), &debug); err != nil {
+		t.Fatal(err)
+	}
+	if debug["targets"]["base"] == nil {
+		t.Errorf("base is missing from the debug view: %s", res)
+	}
+}
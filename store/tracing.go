@@ -0,0 +1,21 @@
+package store
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of data store operations.  Until a tracer provider is configured,
+// the spans are discarded.
+var tracer = otel.Tracer("github.com/hartfordfive/prom-http-sd-server/store")
+
+// endSpan records the error, if any, on the span before ending it
+func endSpan(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/hartfordfive/prom-http-sd-server/lib"
+)
+
+// ErrTxnConditionFailed is returned when a condition of a transaction doesn't hold, in which case
+// none of its operations are applied
+var ErrTxnConditionFailed = errors.New("Transaction condition failed")
+
+// Operations of a transaction
+const (
+	TxnAddTarget    = "add_target"
+	TxnRemoveTarget = "remove_target"
+	TxnSetLabel     = "set_label"
+	TxnDeleteLabel  = "delete_label"
+	TxnDeleteGroup  = "delete_group"
+	TxnSetTemplates = "set_templates"
+)
+
+// Txn is an ordered list of operations on several target groups, applied by DataStore.ApplyTxn
+// in a single change if every condition holds
+type Txn struct {
+	Conditions []*TxnCondition `json:"conditions,omitempty"`
+	Operations []*TxnOp        `json:"operations"`
+}
+
+// TxnCondition is a condition on the state of a target group before the transaction.  Every
+// field which is set must hold.
+type TxnCondition struct {
+	Group string `json:"group"`
+	// Exists requires the group to exist, or not to exist
+	Exists *bool `json:"exists,omitempty"`
+	// Version requires the group to be at this version, see GroupMetadata
+	Version *uint64 `json:"version,omitempty"`
+	// Labels requires the group to have these labels
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// TxnOp is an operation of a transaction, which has the same effect as the DataStore method
+// of the same name: add_target and set_label create the group when it doesn't exist, while
+// the other operations fail with ErrTargetGroupNotFound.  set_templates replaces the templates
+// of the group, creating it when it doesn't exist.
+type TxnOp struct {
+	Op        string   `json:"op"`
+	Group     string   `json:"group"`
+	Target    string   `json:"target,omitempty"`
+	Label     string   `json:"label,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	Templates []string `json:"templates,omitempty"`
+}
+
+// Validate returns an error when an operation or a condition is incomplete
+func (t *Txn) Validate() error {
+	if len(t.Operations) == 0 {
+		return errors.New("A transaction needs at least one operation")
+	}
+	for i, c := range t.Conditions {
+		if c.Group == "" {
+			return fmt.Errorf("Condition %d has no group", i)
+		}
+	}
+	for i, op := range t.Operations {
+		if op.Group == "" {
+			return fmt.Errorf("Operation %d has no group", i)
+		}
+		switch op.Op {
+		case TxnAddTarget, TxnRemoveTarget:
+			if op.Target == "" {
+				return fmt.Errorf("Operation %d (%s) has no target", i, op.Op)
+			}
+		case TxnSetLabel, TxnDeleteLabel:
+			if op.Label == "" {
+				return fmt.Errorf("Operation %d (%s) has no label", i, op.Op)
+			}
+		case TxnSetTemplates:
+			if lib.Contains(op.Templates, op.Group) {
+				return fmt.Errorf("Operation %d (%s) makes group %s its own template", i, op.Op, op.Group)
+			}
+		case TxnDeleteGroup:
+		default:
+			return fmt.Errorf("Operation %d has an unknown op '%s'", i, op.Op)
+		}
+	}
+	return nil
+}
+
+// Groups returns the sorted names of the target groups of the conditions and operations
+func (t *Txn) Groups() []string {
+	found := map[string]bool{}
+	for _, c := range t.Conditions {
+		found[c.Group] = true
+	}
+	for _, op := range t.Operations {
+		found[op.Group] = true
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preview returns the target groups as they would be after the operations of the transaction,
+// without checking its conditions, the deleted groups being nil.  groups are left unchanged.
+func (t *Txn) Preview(groups map[string]*TargetGroup) (map[string]*TargetGroup, error) {
+	res := make(map[string]*TargetGroup, len(groups))
+	for name, tg := range groups {
+		res[name] = tg
+	}
+	for _, name := range t.Groups() {
+		if tg := groups[name]; tg != nil {
+			res[name] = &TargetGroup{Name: name, Targets: append([]string{}, tg.Targets...), Labels: maps.Clone(tg.Labels), Templates: tg.Templates, Metadata: tg.Metadata}
+		}
+	}
+	if _, err := (&Txn{Operations: t.Operations}).apply(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// apply checks the conditions, then applies the operations in order to the target groups
+// returned by Groups, which are nil when they don't exist.  It returns the names of the groups
+// which were changed, and set to nil when deleted.  The groups must be discarded on error.
+func (t *Txn) apply(groups map[string]*TargetGroup) (map[string]bool, error) {
+	for _, c := range t.Conditions {
+		if err := c.check(groups[c.Group]); err != nil {
+			return nil, err
+		}
+	}
+
+	changed := map[string]bool{}
+	for _, op := range t.Operations {
+		tg := groups[op.Group]
+		if tg == nil && (op.Op == TxnAddTarget || op.Op == TxnSetLabel || op.Op == TxnSetTemplates) {
+			tg = &TargetGroup{Name: op.Group, Targets: []string{}, Labels: map[string]string{}}
+			groups[op.Group] = tg
+			changed[op.Group] = true
+		}
+		if tg == nil {
+			return nil, fmt.Errorf("%w: %s", ErrTargetGroupNotFound, op.Group)
+		}
+		if tg.Labels == nil {
+			tg.Labels = map[string]string{}
+		}
+
+		switch op.Op {
+		case TxnAddTarget:
+			if !lib.Contains(tg.Targets, op.Target) {
+				tg.Targets = append(tg.Targets, op.Target)
+				changed[op.Group] = true
+			}
+		case TxnRemoveTarget:
+			if lib.Contains(tg.Targets, op.Target) {
+				tg.Targets = lib.RemoveFromList(tg.Targets, op.Target)
+				changed[op.Group] = true
+			}
+		case TxnSetLabel:
+			if v, ok := tg.Labels[op.Label]; !ok || v != op.Value {
+				tg.Labels[op.Label] = op.Value
+				changed[op.Group] = true
+			}
+		case TxnDeleteLabel:
+			if _, ok := tg.Labels[op.Label]; ok {
+				delete(tg.Labels, op.Label)
+				changed[op.Group] = true
+			}
+		case TxnSetTemplates:
+			if !slices.Equal(tg.Templates, op.Templates) {
+				tg.Templates = append([]string{}, op.Templates...)
+				changed[op.Group] = true
+			}
+		case TxnDeleteGroup:
+			groups[op.Group] = nil
+			changed[op.Group] = true
+		}
+	}
+	return changed, nil
+}
+
+// check returns ErrTxnConditionFailed when the condition doesn't hold for the target group,
+// nil when it doesn't exist
+func (c *TxnCondition) check(tg *TargetGroup) error {
+	if c.Exists != nil && *c.Exists != (tg != nil) {
+		if *c.Exists {
+			return fmt.Errorf("%w: target group %s doesn't exist", ErrTxnConditionFailed, c.Group)
+		}
+		return fmt.Errorf("%w: target group %s exists", ErrTxnConditionFailed, c.Group)
+	}
+	if c.Version == nil && len(c.Labels) == 0 {
+		return nil
+	}
+	if tg == nil {
+		return fmt.Errorf("%w: target group %s doesn't exist", ErrTxnConditionFailed, c.Group)
+	}
+	if c.Version != nil {
+		var version uint64
+		if tg.Metadata != nil {
+			version = tg.Metadata.Version
+		}
+		if version != *c.Version {
+			return fmt.Errorf("%w: target group %s is at version %d, not %d", ErrTxnConditionFailed, c.Group, version, *c.Version)
+		}
+	}
+	names := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if v, ok := tg.Labels[k]; !ok || v != c.Labels[k] {
+			return fmt.Errorf("%w: label %s of target group %s isn't '%s'", ErrTxnConditionFailed, k, c.Group, c.Labels[k])
+		}
+	}
+	return nil
+}
+
+// conditionsKey is the context key of the conditions set by WithConditions
+type conditionsKey struct{}
+
+// WithConditions returns a copy of ctx under which the methods of the data stores changing
+// target groups only apply their change when the conditions on these groups hold, and fail with
+// ErrTxnConditionFailed otherwise.  The conditions are checked in the same transaction as the
+// change, and are added to those of ApplyTxn.
+func WithConditions(ctx context.Context, conditions ...*TxnCondition) context.Context {
+	return context.WithValue(ctx, conditionsKey{}, append(conditionsFrom(ctx), conditions...))
+}
+
+// UnchangedCondition returns the condition that the target group is still in the state tg it was
+// read in: that it still doesn't exist when tg is nil, or that it's still at the version of its
+// metadata, or just exists when the version isn't known
+func UnchangedCondition(name string, tg *TargetGroup) *TxnCondition {
+	cond := &TxnCondition{Group: name}
+	switch {
+	case tg == nil:
+		exists := false
+		cond.Exists = &exists
+	case tg.Metadata != nil:
+		version := tg.Metadata.Version
+		cond.Version = &version
+	default:
+		exists := true
+		cond.Exists = &exists
+	}
+	return cond
+}
+
+func conditionsFrom(ctx context.Context) []*TxnCondition {
+	conditions, _ := ctx.Value(conditionsKey{}).([]*TxnCondition)
+	return conditions
+}
+
+// checkConditions returns ErrTxnConditionFailed when a condition of ctx on the target group
+// doesn't hold.  read returns the current group, nil when it doesn't exist, and is only called
+// when there are conditions on it.
+func checkConditions(ctx context.Context, name string, read func() (*TargetGroup, error)) error {
+	var tg *TargetGroup
+	loaded := false
+	for _, c := range conditionsFrom(ctx) {
+		if c.Group != name {
+			continue
+		}
+		if !loaded {
+			var err error
+			if tg, err = read(); err != nil {
+				return err
+			}
+			loaded = true
+		}
+		if err := c.check(tg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withContext returns the transaction with the conditions of ctx added to its own
+func (t *Txn) withContext(ctx context.Context) *Txn {
+	conditions := conditionsFrom(ctx)
+	if len(conditions) == 0 {
+		return t
+	}
+	return &Txn{
+		Conditions: append(append([]*TxnCondition{}, t.Conditions...), conditions...),
+		Operations: t.Operations,
+	}
+}
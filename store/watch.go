@@ -0,0 +1,287 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"go.uber.org/zap"
+)
+
+// Types of the events sent to the watchers
+const (
+	// EventReset tells the watcher to discard its state, the events which follow describe
+	// every target group of the store
+	EventReset         = "reset"
+	EventTargetAdded   = "target_added"
+	EventTargetRemoved = "target_removed"
+	// EventLabelsChanged carries the complete set of labels of the target group
+	EventLabelsChanged = "labels_changed"
+)
+
+// ErrWatcherNotReady is returned when subscribing before the first state of the store has been read
+var ErrWatcherNotReady = errors.New("Watcher is not ready")
+
+// Event describes a change to a target group.  Events resulting from the same change of
+// the store share the same revision.
+type Event struct {
+	Revision uint64            `json:"revision"`
+	Type     string            `json:"type"`
+	Group    string            `json:"group,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// EffectiveLabels are the labels of the group layered over those of its templates, as
+	// exposed to Prometheus
+	EffectiveLabels map[string]string `json:"effective_labels,omitempty"`
+
+	// effective labels of the target group before the change, used to filter the events of
+	// groups whose labels no longer match
+	prevLabels map[string]string
+}
+
+// EventFilter selects the events sent to a watcher.  Empty filters match every event.
+type EventFilter struct {
+	Groups []string
+	Labels map[string]string
+}
+
+// Match returns true when the event concerns one of the groups and the effective labels of the
+// group, before or after the change, contain every label of the filter
+func (f *EventFilter) Match(e *Event) bool {
+	if e.Type == EventReset {
+		return true
+	}
+	if len(f.Groups) > 0 {
+		found := false
+		for _, g := range f.Groups {
+			if g == e.Group {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.matchLabels(e.EffectiveLabels) || (e.prevLabels != nil && f.matchLabels(e.prevLabels))
+}
+
+func (f *EventFilter) matchLabels(labels map[string]string) bool {
+	for k, v := range f.Labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscription receives the events of the store as they happen.  The channel is closed when
+// the watcher stops, or when the subscriber doesn't keep up with the events.
+type Subscription struct {
+	C <-chan []*Event
+
+	c       chan []*Event
+	watcher *Watcher
+}
+
+// Close stops the delivery of events to the subscription
+func (s *Subscription) Close() {
+	s.watcher.unsubscribe(s)
+}
+
+// Watcher turns the changes of a data store into events, keeps the most recent events so
+// that subscribers can resume from a revision, and sends the new events to every subscriber.
+type Watcher struct {
+	ds          DataStore
+	historySize int
+
+	mu       sync.Mutex
+	ready    bool
+	groups   map[string]*TargetGroup
+	revision uint64
+	// history holds the most recent events, baseRevision being the revision before the first one
+	history      []*Event
+	baseRevision uint64
+	subscribers  map[*Subscription]bool
+}
+
+var WatcherInstance *Watcher
+
+// NewWatcher creates a watcher of the data store keeping the last historySize events
+func NewWatcher(ds DataStore, historySize int) *Watcher {
+	return &Watcher{
+		ds:          ds,
+		historySize: historySize,
+		subscribers: map[*Subscription]bool{},
+	}
+}
+
+// Run watches the data store until the context is done, then closes every subscription
+func (w *Watcher) Run(ctx context.Context) {
+	defer w.closeSubscriptions()
+
+	for {
+		groups, revision, err := w.ds.WatchTargetGroups(ctx, w.Revision())
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Logger.Error("Could not watch target groups", zap.Error(err))
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		w.update(groups, revision)
+	}
+}
+
+// Revision returns the last revision of the data store seen by the watcher, which may not have
+// resulted in events
+func (w *Watcher) Revision() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.revision
+}
+
+// update records the new state of the store and sends the resulting events to the subscribers
+func (w *Watcher) update(groups map[string]*TargetGroup, revision uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.ready {
+		w.ready = true
+		w.groups = groups
+		w.revision = revision
+		w.baseRevision = revision
+		return
+	}
+
+	events := groupEvents(w.groups, groups, revision)
+	w.groups = groups
+	w.revision = revision
+	if len(events) == 0 {
+		return
+	}
+
+	w.history = append(w.history, events...)
+	if excess := len(w.history) - w.historySize; excess > 0 {
+		// Only drop complete revisions, so that a subscriber never resumes in the middle of one
+		for excess < len(w.history) && w.history[excess].Revision == w.history[excess-1].Revision {
+			excess++
+		}
+		w.baseRevision = w.history[excess-1].Revision
+		w.history = append([]*Event{}, w.history[excess:]...)
+	}
+
+	for sub := range w.subscribers {
+		select {
+		case sub.c <- events:
+		default:
+			logger.Logger.Warn("Dropping watcher which doesn't keep up with the events")
+			delete(w.subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe returns the events the subscriber must process first, along with the subscription
+// receiving the following events.  When resume is set and the events following revision are
+// still known, only those events are returned.  Otherwise, a reset event is returned followed
+// by the events describing every target group of the store.
+func (w *Watcher) Subscribe(revision uint64, resume bool) ([]*Event, *Subscription, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.ready {
+		return nil, nil, ErrWatcherNotReady
+	}
+
+	var events []*Event
+	if resume && revision >= w.baseRevision && revision <= w.revision {
+		for _, e := range w.history {
+			if e.Revision > revision {
+				events = append(events, e)
+			}
+		}
+	} else {
+		events = append([]*Event{{Revision: w.revision, Type: EventReset}}, groupEvents(nil, w.groups, w.revision)...)
+	}
+
+	c := make(chan []*Event, 64)
+	sub := &Subscription{C: c, c: c, watcher: w}
+	w.subscribers[sub] = true
+	return events, sub, nil
+}
+
+func (w *Watcher) unsubscribe(sub *Subscription) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subscribers[sub] {
+		delete(w.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+func (w *Watcher) closeSubscriptions() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for sub := range w.subscribers {
+		delete(w.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// groupEvents returns the events describing the changes from the previous to the current
+// target groups, in the order of the target group names.  The labels of a group change when its
+// own labels change, or when the labels it inherits from its templates do.
+func groupEvents(previous, current map[string]*TargetGroup, revision uint64) []*Event {
+	prevResolved, curResolved := ResolveTemplates(previous), ResolveTemplates(current)
+	diffs := map[string]*GroupDiff{}
+	labelsChanged := map[string]bool{}
+	for _, d := range DiffTargetGroups(previous, current) {
+		diffs[d.Name] = d
+		labelsChanged[d.Name] = len(d.SetLabels) > 0 || len(d.RemovedLabels) > 0
+	}
+	for _, d := range DiffTargetGroups(prevResolved, curResolved) {
+		labelsChanged[d.Name] = labelsChanged[d.Name] || len(d.SetLabels) > 0 || len(d.RemovedLabels) > 0
+	}
+	names := make([]string, 0, len(labelsChanged))
+	for name := range labelsChanged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	events := []*Event{}
+	for _, name := range names {
+		var labels, effective, prevEffective map[string]string
+		if tg, ok := prevResolved[name]; ok {
+			labels, effective, prevEffective = previous[name].Labels, tg.Labels, tg.Labels
+		}
+		if tg, ok := curResolved[name]; ok {
+			labels, effective = current[name].Labels, tg.Labels
+		}
+
+		if d := diffs[name]; d != nil {
+			for _, t := range d.AddedTargets {
+				events = append(events, &Event{Revision: revision, Type: EventTargetAdded, Group: name, Target: t, Labels: labels, EffectiveLabels: effective})
+			}
+			for _, t := range d.RemovedTargets {
+				events = append(events, &Event{Revision: revision, Type: EventTargetRemoved, Group: name, Target: t, Labels: labels, EffectiveLabels: effective})
+			}
+		}
+		if labelsChanged[name] {
+			newLabels, newEffective := map[string]string{}, map[string]string{}
+			if _, ok := current[name]; ok {
+				newLabels, newEffective = labels, effective
+			}
+			events = append(events, &Event{Revision: revision, Type: EventLabelsChanged, Group: name, Labels: newLabels, EffectiveLabels: newEffective, prevLabels: prevEffective})
+		}
+	}
+	return events
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func(previous trace.Tracer) { tracer = previous }(tracer)
+	tracer = tp.Tracer("test")
+
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/api/v2/groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+		if !trace.SpanFromContext(r.Context()).SpanContext().IsValid() {
+			t.Error("the span isn't passed to the handler")
+		}
+		if mux.Vars(r)["group"] == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	for _, path := range []string{"/api/v2/groups/web", "/api/v2/groups/broken"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	tests := []struct {
+		path   string
+		status int
+		code   codes.Code
+	}{
+		{"/api/v2/groups/web", http.StatusOK, codes.Unset},
+		{"/api/v2/groups/broken", http.StatusInternalServerError, codes.Error},
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name != "GET /api/v2/groups/{group}" {
+			t.Errorf("span %d is named %s", i, span.Name)
+		}
+		if span.SpanKind != trace.SpanKindServer {
+			t.Errorf("span %d is of kind %s", i, span.SpanKind)
+		}
+		want := []attribute.KeyValue{
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("http.route", "/api/v2/groups/{group}"),
+			attribute.String("url.path", tt.path),
+			attribute.Int("http.response.status_code", tt.status),
+		}
+		for _, a := range want {
+			if !hasAttribute(span.Attributes, a) {
+				t.Errorf("span %d is missing attribute %s=%s: %v", i, a.Key, a.Value.Emit(), span.Attributes)
+			}
+		}
+		if span.Status.Code != tt.code {
+			t.Errorf("span %d has status %v, want %v", i, span.Status.Code, tt.code)
+		}
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
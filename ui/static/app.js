@@ -0,0 +1,445 @@
+// Admin UI of prom-http-sd-server.  Changes are staged locally, reviewed as a diff, then
+// applied through the REST API.
+(function () {
+  'use strict';
+
+  // Same rules as lib.IsValidTargetName and lib.IsValidLabelName
+  var targetRe = /^(([a-z0-9]|[a-z0-9][a-z0-9\-]*[a-z0-9])\.)*([a-z0-9]|[a-z0-9][a-z0-9\-]*[a-z0-9])(:[0-9]+)?$/;
+  var labelRe = /^[a-zA-Z_][a-zA-Z0-9_]*$/;
+
+  // live is the state of the server, working the state with the staged changes.  Deleted
+  // groups are absent from working.
+  var live = {};
+  var working = {};
+  var selected = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function el(tag, attrs, children) {
+    var e = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === 'text') {
+        e.textContent = attrs[k];
+      } else if (k === 'onclick' || k === 'onchange') {
+        e[k] = attrs[k];
+      } else {
+        e.setAttribute(k, attrs[k]);
+      }
+    });
+    (children || []).forEach(function (c) {
+      e.appendChild(c);
+    });
+    return e;
+  }
+
+  function clone(groups) {
+    return JSON.parse(JSON.stringify(groups));
+  }
+
+  function emptyGroup() {
+    return { targets: [], labels: {} };
+  }
+
+  function sortedKeys(obj) {
+    return Object.keys(obj).sort();
+  }
+
+  function union(a, b) {
+    var seen = {};
+    a.concat(b).forEach(function (x) {
+      seen[x] = true;
+    });
+    return sortedKeys(seen);
+  }
+
+  function showMessage(text, kind) {
+    var m = $('message');
+    m.textContent = text;
+    m.className = kind;
+    m.hidden = !text;
+  }
+
+  // api sends a request to the REST API with the token of the session
+  function api(method, path) {
+    var headers = {};
+    var token = $('token').value;
+    if (token) {
+      headers.Authorization = 'Bearer ' + token;
+    }
+    return fetch('..' + path, { method: method, headers: headers }).then(function (res) {
+      if (!res.ok) {
+        return res.text().then(function (text) {
+          throw new Error(method + ' ' + path + ': ' + text.trim());
+        });
+      }
+      return res;
+    });
+  }
+
+  // load reads the target groups of the server.  The staged changes are kept when keepChanges
+  // is set, so that they're reviewed against the new state of the server.
+  function load(keepChanges) {
+    return api('GET', '/debug_targets').then(function (res) {
+      return res.json();
+    }).then(function (data) {
+      live = {};
+      var groups = data.targets || {};
+      Object.keys(groups).forEach(function (name) {
+        live[name] = {
+          targets: (groups[name].targets || []).slice().sort(),
+          labels: groups[name].labels || {}
+        };
+      });
+      if (!keepChanges) {
+        working = clone(live);
+      }
+      render();
+    });
+  }
+
+  // diff returns the changes from the live to the working state, by target group
+  function diff() {
+    var diffs = [];
+    union(Object.keys(live), Object.keys(working)).forEach(function (name) {
+      var l = live[name];
+      var w = working[name];
+      if (!w) {
+        diffs.push({ name: name, deleted: true });
+        return;
+      }
+      l = l || emptyGroup();
+      var d = {
+        name: name,
+        addedTargets: w.targets.filter(function (t) { return l.targets.indexOf(t) === -1; }),
+        removedTargets: l.targets.filter(function (t) { return w.targets.indexOf(t) === -1; }),
+        setLabels: {},
+        removedLabels: sortedKeys(l.labels).filter(function (k) { return !(k in w.labels); })
+      };
+      sortedKeys(w.labels).forEach(function (k) {
+        if (l.labels[k] !== w.labels[k]) {
+          d.setLabels[k] = w.labels[k];
+        }
+      });
+      if (d.addedTargets.length || d.removedTargets.length || d.removedLabels.length || Object.keys(d.setLabels).length) {
+        diffs.push(d);
+      }
+    });
+    return diffs;
+  }
+
+  function diffText(diffs) {
+    return diffs.map(function (d) {
+      if (d.deleted) {
+        return 'target group ' + d.name + '\n  - delete group\n';
+      }
+      var lines = ['target group ' + d.name];
+      d.addedTargets.forEach(function (t) { lines.push('  + target ' + t); });
+      d.removedTargets.forEach(function (t) { lines.push('  - target ' + t); });
+      sortedKeys(d.setLabels).forEach(function (k) { lines.push('  + label ' + k + '=' + d.setLabels[k]); });
+      d.removedLabels.forEach(function (k) { lines.push('  - label ' + k); });
+      return lines.join('\n') + '\n';
+    }).join('');
+  }
+
+  // apply sends the requests of a diff, in the same order as sdctl import
+  function apply(d) {
+    var g = encodeURIComponent(d.name);
+    if (d.deleted) {
+      return api('DELETE', '/api/target/' + g);
+    }
+    var steps = [];
+    d.addedTargets.forEach(function (t) {
+      steps.push(['POST', '/api/target/' + g + '/' + encodeURIComponent(t)]);
+    });
+    var labels = sortedKeys(d.setLabels).map(function (k) {
+      return 'labels=' + encodeURIComponent(k + '=' + d.setLabels[k]);
+    });
+    if (labels.length) {
+      steps.push(['POST', '/api/labels/update/' + g + '?' + labels.join('&')]);
+    }
+    d.removedTargets.forEach(function (t) {
+      steps.push(['DELETE', '/api/target/' + g + '/' + encodeURIComponent(t)]);
+    });
+    d.removedLabels.forEach(function (k) {
+      steps.push(['DELETE', '/api/labels/update/' + g + '/' + encodeURIComponent(k)]);
+    });
+    return steps.reduce(function (p, s) {
+      return p.then(function () { return api(s[0], s[1]); });
+    }, Promise.resolve());
+  }
+
+  function save() {
+    var diffs = diff();
+    $('diff').close();
+    diffs.reduce(function (p, d) {
+      return p.then(function () { return apply(d); });
+    }, Promise.resolve()).then(function () {
+      showMessage('Saved ' + diffs.length + ' target group(s)', 'info');
+      return load(false);
+    }).catch(function (err) {
+      // Keep the changes which weren't applied so they can be fixed and saved again
+      showMessage(err.message, 'error');
+      return load(true);
+    }).then(loadAudit);
+  }
+
+  function matches(name, g, query) {
+    if (!query) {
+      return true;
+    }
+    query = query.toLowerCase();
+    if (name.toLowerCase().indexOf(query) !== -1) {
+      return true;
+    }
+    if (g.targets.some(function (t) { return t.indexOf(query) !== -1; })) {
+      return true;
+    }
+    return Object.keys(g.labels).some(function (k) {
+      return (k + '=' + g.labels[k]).toLowerCase().indexOf(query) !== -1;
+    });
+  }
+
+  function render() {
+    var diffs = diff();
+    var pending = {};
+    diffs.forEach(function (d) { pending[d.name] = true; });
+
+    $('review').disabled = diffs.length === 0;
+    $('discard').disabled = diffs.length === 0;
+    $('review').textContent = diffs.length ? 'Review changes (' + diffs.length + ')' : 'Review changes';
+
+    var list = $('groups');
+    list.textContent = '';
+    var query = $('search').value.trim();
+    union(Object.keys(live), Object.keys(working)).forEach(function (name) {
+      var g = working[name] || live[name];
+      if (!matches(name, g, query)) {
+        return;
+      }
+      var classes = [];
+      if (name === selected) { classes.push('selected'); }
+      if (!working[name]) { classes.push('removed'); }
+      if (pending[name]) { classes.push('pending'); }
+      list.appendChild(el('li', {
+        'class': classes.join(' '),
+        onclick: function () { selected = name; render(); }
+      }, [
+        document.createTextNode(name),
+        el('span', { 'class': 'count', text: String(g.targets.length) })
+      ]));
+    });
+
+    renderGroup();
+  }
+
+  function renderGroup() {
+    var show = selected !== null && (selected in live || selected in working);
+    $('group').hidden = !show;
+    $('empty').hidden = show;
+    if (!show) {
+      return;
+    }
+
+    var l = live[selected] || emptyGroup();
+    var w = working[selected];
+    $('group-name').textContent = selected;
+    $('delete-group').textContent = w ? 'Delete group' : 'Restore group';
+    $('add-target').hidden = !w;
+    $('add-label').hidden = !w;
+
+    var targets = $('targets');
+    targets.textContent = '';
+    union(l.targets, w ? w.targets : []).forEach(function (t) {
+      var inLive = l.targets.indexOf(t) !== -1;
+      var inWorking = w && w.targets.indexOf(t) !== -1;
+      var li = el('li', { 'class': !inWorking ? 'removed' : (!inLive ? 'added' : ''), text: t });
+      if (w) {
+        li.appendChild(el('button', {
+          text: inWorking ? 'Remove' : 'Undo',
+          onclick: function () {
+            if (inWorking) {
+              w.targets = w.targets.filter(function (x) { return x !== t; });
+            } else {
+              w.targets.push(t);
+              w.targets.sort();
+            }
+            render();
+          }
+        }));
+      }
+      targets.appendChild(li);
+    });
+
+    var labels = $('labels').tBodies[0];
+    labels.textContent = '';
+    union(Object.keys(l.labels), w ? Object.keys(w.labels) : []).forEach(function (k) {
+      var inWorking = w && k in w.labels;
+      var cls = '';
+      if (!inWorking) {
+        cls = 'removed';
+      } else if (!(k in l.labels)) {
+        cls = 'added';
+      } else if (l.labels[k] !== w.labels[k]) {
+        cls = 'changed';
+      }
+
+      var value;
+      if (inWorking) {
+        value = el('input', {
+          value: w.labels[k],
+          onchange: function (e) { w.labels[k] = e.target.value; render(); }
+        });
+      } else {
+        value = document.createTextNode(l.labels[k]);
+      }
+      var cells = [el('td', { text: k }), el('td', {}, [value])];
+      if (w) {
+        cells.push(el('td', {}, [el('button', {
+          text: inWorking ? 'Remove' : 'Undo',
+          onclick: function () {
+            if (inWorking) {
+              delete w.labels[k];
+            } else {
+              w.labels[k] = l.labels[k];
+            }
+            render();
+          }
+        })]));
+      }
+      labels.appendChild(el('tr', { 'class': cls }, cells));
+    });
+  }
+
+  // loadAudit shows the recent changes, which are only returned to admin tokens
+  function loadAudit() {
+    var body = $('audit').querySelector('tbody');
+    if (!$('token').value) {
+      body.textContent = '';
+      body.appendChild(el('tr', {}, [
+        el('td', { colspan: '4', text: 'Enter an admin token to show the recent changes.' })
+      ]));
+      return Promise.resolve();
+    }
+    return api('GET', '/api/audit?limit=50').then(function (res) {
+      return res.json();
+    }).then(function (entries) {
+      body.textContent = '';
+      (entries || []).forEach(function (e) {
+        body.appendChild(el('tr', {}, [
+          el('td', { text: new Date(e.time).toLocaleString() }),
+          el('td', { text: e.identity || '-' }),
+          el('td', { 'class': 'path', text: e.method + ' ' + decodeURIComponent(e.path) }),
+          el('td', { text: String(e.status) })
+        ]));
+      });
+    }).catch(function (err) {
+      showMessage(err.message, 'error');
+    });
+  }
+
+  // watch reloads the target groups when they change on the server, keeping the staged changes
+  function watch() {
+    if (!window.EventSource) {
+      return;
+    }
+    var timer = null;
+    var source = new EventSource('../api/watch');
+    ['target_added', 'target_removed', 'labels_changed'].forEach(function (type) {
+      source.addEventListener(type, function () {
+        clearTimeout(timer);
+        timer = setTimeout(function () {
+          load(diff().length > 0);
+          loadAudit();
+        }, 500);
+      });
+    });
+  }
+
+  $('token').value = sessionStorage.getItem('token') || '';
+  $('token').onchange = function () {
+    sessionStorage.setItem('token', $('token').value);
+    loadAudit();
+  };
+
+  $('search').oninput = render;
+
+  $('new-group').onsubmit = function (e) {
+    e.preventDefault();
+    var name = $('new-group-name').value.trim();
+    if (!name || name.indexOf('/') !== -1) {
+      showMessage('Target group name must not be empty or contain /', 'error');
+      return;
+    }
+    if (!working[name]) {
+      working[name] = live[name] ? clone(live[name]) : emptyGroup();
+    }
+    selected = name;
+    $('new-group-name').value = '';
+    showMessage('', '');
+    render();
+  };
+
+  $('add-target').onsubmit = function (e) {
+    e.preventDefault();
+    var t = $('add-target-name').value.trim();
+    if (!targetRe.test(t)) {
+      showMessage("Target '" + t + "' is invalid", 'error');
+      return;
+    }
+    var w = working[selected];
+    if (w.targets.indexOf(t) === -1) {
+      w.targets.push(t);
+      w.targets.sort();
+    }
+    $('add-target-name').value = '';
+    showMessage('', '');
+    render();
+  };
+
+  $('add-label').onsubmit = function (e) {
+    e.preventDefault();
+    var k = $('add-label-name').value.trim();
+    if (!labelRe.test(k)) {
+      showMessage("Label name '" + k + "' is invalid", 'error');
+      return;
+    }
+    working[selected].labels[k] = $('add-label-value').value;
+    $('add-label-name').value = '';
+    $('add-label-value').value = '';
+    showMessage('', '');
+    render();
+  };
+
+  $('delete-group').onclick = function () {
+    if (working[selected]) {
+      delete working[selected];
+    } else {
+      working[selected] = clone(live[selected] || emptyGroup());
+    }
+    render();
+  };
+
+  $('review').onclick = function () {
+    $('diff-text').textContent = diffText(diff());
+    $('diff').showModal();
+  };
+  $('cancel').onclick = function () {
+    $('diff').close();
+  };
+  $('save').onclick = save;
+
+  $('discard').onclick = function () {
+    working = clone(live);
+    render();
+  };
+
+  $('refresh-audit').onclick = loadAudit;
+
+  load(false).catch(function (err) {
+    showMessage(err.message, 'error');
+  });
+  loadAudit();
+  watch();
+})();
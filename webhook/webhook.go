@@ -0,0 +1,446 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"github.com/hartfordfive/prom-http-sd-server/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the body, computed with the secret of the endpoint
+	SignatureHeader = "X-Prom-Http-Sd-Signature"
+	// DeliveryHeader carries the ID of the notification, which is the same for every attempt
+	DeliveryHeader = "X-Prom-Http-Sd-Delivery"
+
+	defaultTimeout = 10 * time.Second
+	// pollInterval is the longest time between two checks of the outbox
+	pollInterval = time.Minute
+)
+
+var (
+	outboxBucket = []byte("outbox")
+	// stateBucket holds the last revision queued, under revisionKey
+	stateBucket = []byte("state")
+	revisionKey = []byte("revision")
+
+	metricDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpsdserver_webhook_deliveries_total",
+		Help: "Number of webhook delivery attempts, by endpoint and result (success, retry or dropped).",
+	}, []string{"endpoint", "result"})
+)
+
+// Notification is the JSON payload posted to the webhook endpoints
+type Notification struct {
+	ID        string         `json:"id"`
+	Revision  uint64         `json:"revision"`
+	Timestamp time.Time      `json:"timestamp"`
+	Events    []*store.Event `json:"events"`
+}
+
+// delivery is a notification waiting in the outbox to be delivered to an endpoint
+type delivery struct {
+	key         []byte
+	Endpoint    string          `json:"endpoint"`
+	ID          string          `json:"id"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+}
+
+// Dispatcher posts the changes of the target groups to the webhook endpoints.  Notifications
+// are written to the outbox before being delivered, so that they survive restarts, and are
+// retried with an exponential backoff until they are delivered.  The outbox also records the
+// last revision queued, from which the changes are resumed after a restart.
+//
+// Every dispatcher notifies the endpoints of every change, so a single instance must run one
+// when several instances share the consul store.
+type Dispatcher struct {
+	db     *bolt.DB
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewDispatcher opens the outbox stored at outboxPath
+func NewDispatcher(outboxPath string) (*Dispatcher, error) {
+	db, err := bolt.Open(outboxPath, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Could not open webhook outbox: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(outboxBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(stateBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not create webhook outbox: %s", err)
+	}
+	return &Dispatcher{
+		db:     db,
+		client: &http.Client{},
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Close closes the outbox.  It must only be called once Run has returned.
+func (d *Dispatcher) Close() error {
+	return d.db.Close()
+}
+
+// Run queues the events of the watcher for the endpoints of the current configuration and
+// delivers them, until the context is done.
+func (d *Dispatcher) Run(ctx context.Context, watcher *store.Watcher) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.deliverLoop(ctx)
+	}()
+	d.queueLoop(ctx, watcher)
+	<-done
+}
+
+// queueLoop writes a notification to the outbox for every change of the target groups, starting
+// after the last revision queued before a restart
+func (d *Dispatcher) queueLoop(ctx context.Context, watcher *store.Watcher) {
+	revision := d.lastRevision()
+	resume := revision > 0
+	for ctx.Err() == nil {
+		events, sub, err := watcher.Subscribe(revision, resume)
+		if err != nil {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		// The events of the initial state are only queued when the subscription was dropped
+		// and the missed events are known
+		if resume && (len(events) == 0 || events[0].Type != store.EventReset) {
+			revision = d.queue(events, revision)
+		} else {
+			if resume {
+				logger.Logger.Warn("Webhook notifications have been missed, only the following changes will be sent",
+					zap.Uint64("revision", revision))
+			}
+			if len(events) > 0 {
+				revision = events[0].Revision
+				d.saveRevision(revision)
+			}
+		}
+
+		for events := range sub.C {
+			revision = d.queue(events, revision)
+		}
+		sub.Close()
+		if ctx.Err() != nil {
+			// The watcher sent every event before closing the subscription, so the next start
+			// resumes from its last revision, even when the following changes had no events
+			d.saveRevision(watcher.Revision())
+		}
+		resume = true
+	}
+}
+
+// lastRevision returns the last revision queued, 0 when none was
+func (d *Dispatcher) lastRevision() uint64 {
+	var revision uint64
+	err := d.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(stateBucket).Get(revisionKey); len(v) == 8 {
+			revision = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Logger.Error("Could not read the last webhook revision", zap.Error(err))
+	}
+	return revision
+}
+
+func (d *Dispatcher) saveRevision(revision uint64) {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		return putRevision(tx, revision)
+	})
+	if err != nil {
+		logger.Logger.Error("Could not save the last webhook revision", zap.Uint64("revision", revision), zap.Error(err))
+	}
+}
+
+func putRevision(tx *bolt.Tx, revision uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, revision)
+	return tx.Bucket(stateBucket).Put(revisionKey, v)
+}
+
+// queue writes the notifications of the events to the outbox, one per revision and endpoint,
+// and returns the last revision queued
+func (d *Dispatcher) queue(events []*store.Event, revision uint64) uint64 {
+	byRevision := map[uint64][]*store.Event{}
+	revisions := []uint64{}
+	for _, e := range events {
+		if _, ok := byRevision[e.Revision]; !ok {
+			revisions = append(revisions, e.Revision)
+		}
+		byRevision[e.Revision] = append(byRevision[e.Revision], e)
+	}
+
+	endpoints := config.Current().Webhooks.Endpoints
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		for _, rev := range revisions {
+			for _, ep := range endpoints {
+				selected := []*store.Event{}
+				for _, e := range byRevision[rev] {
+					if ep.Wants(e.Type, e.Group) {
+						selected = append(selected, e)
+					}
+				}
+				if len(selected) == 0 {
+					continue
+				}
+
+				n := &Notification{ID: newID(), Revision: rev, Timestamp: time.Now().UTC(), Events: selected}
+				payload, err := json.Marshal(n)
+				if err != nil {
+					return err
+				}
+				seq, err := b.NextSequence()
+				if err != nil {
+					return err
+				}
+				dl := &delivery{Endpoint: ep.Name, ID: n.ID, Payload: payload, NextAttempt: time.Now()}
+				v, err := json.Marshal(dl)
+				if err != nil {
+					return err
+				}
+				key := make([]byte, 8)
+				binary.BigEndian.PutUint64(key, seq)
+				if err := b.Put(key, v); err != nil {
+					return err
+				}
+			}
+			revision = rev
+		}
+		// Recorded along with the notifications, so that none is queued twice after a restart
+		return putRevision(tx, revision)
+	})
+	if err != nil {
+		logger.Logger.Error("Could not queue webhook notifications", zap.Error(err))
+		return revision
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return revision
+}
+
+// deliverLoop delivers the notifications of the outbox as they become due
+func (d *Dispatcher) deliverLoop(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+
+		next := d.deliverDue(ctx)
+		timer.Stop()
+		select {
+		case <-timer.C:
+		default:
+		}
+		timer.Reset(time.Until(next))
+	}
+}
+
+// deliverDue attempts the delivery of every notification which is due, in the order they were
+// queued, and returns when the next attempt is due.  The notifications of an endpoint are
+// delivered in order, so none is attempted while an earlier one is waiting to be retried.
+func (d *Dispatcher) deliverDue(ctx context.Context) time.Time {
+	next := time.Now().Add(pollInterval)
+
+	deliveries := []*delivery{}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			dl := &delivery{key: append([]byte{}, k...)}
+			if err := json.Unmarshal(v, dl); err != nil {
+				logger.Logger.Error("Could not decode webhook notification", zap.Error(err))
+				return nil
+			}
+			deliveries = append(deliveries, dl)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Logger.Error("Could not read webhook outbox", zap.Error(err))
+		return next
+	}
+
+	webhooksConf := config.Current().Webhooks
+	waiting := map[string]bool{}
+	for _, dl := range deliveries {
+		if ctx.Err() != nil {
+			return next
+		}
+		if waiting[dl.Endpoint] {
+			continue
+		}
+		log := logger.Logger.With(zap.String("endpoint", dl.Endpoint), zap.String("delivery", dl.ID))
+
+		ep := webhooksConf.Endpoint(dl.Endpoint)
+		if ep == nil {
+			log.Warn("Dropping webhook notification of an endpoint which is no longer configured")
+			d.remove(dl)
+			continue
+		}
+		if dl.NextAttempt.After(time.Now()) {
+			waiting[dl.Endpoint] = true
+			if dl.NextAttempt.Before(next) {
+				next = dl.NextAttempt
+			}
+			continue
+		}
+
+		retry, err := d.post(ctx, ep, dl)
+		if err == nil {
+			metricDeliveries.WithLabelValues(dl.Endpoint, "success").Inc()
+			d.remove(dl)
+			continue
+		}
+		if ctx.Err() != nil {
+			// Interrupted by the shutdown, the attempt doesn't count
+			return next
+		}
+
+		dl.Attempts++
+		if !retry || dl.Attempts >= webhooksConf.MaxAttempts {
+			log.Error("Dropping webhook notification", zap.Int("attempts", dl.Attempts), zap.Error(err))
+			metricDeliveries.WithLabelValues(dl.Endpoint, "dropped").Inc()
+			d.remove(dl)
+			continue
+		}
+
+		dl.NextAttempt = time.Now().Add(backoff(webhooksConf, dl.Attempts))
+		log.Warn("Could not deliver webhook notification, will retry",
+			zap.Int("attempts", dl.Attempts),
+			zap.Time("next_attempt", dl.NextAttempt),
+			zap.Error(err),
+		)
+		metricDeliveries.WithLabelValues(dl.Endpoint, "retry").Inc()
+		d.save(dl)
+		waiting[dl.Endpoint] = true
+		if dl.NextAttempt.Before(next) {
+			next = dl.NextAttempt
+		}
+	}
+	return next
+}
+
+// post sends the notification to the endpoint.  When it fails, retry tells whether the
+// endpoint may accept the notification later.
+func (d *Dispatcher) post(ctx context.Context, ep *config.WebhookEndpoint, dl *delivery) (retry bool, err error) {
+	timeout := ep.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("prom-http-sd-server/%s", version.Version))
+	req.Header.Set(DeliveryHeader, dl.ID)
+	if ep.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(ep.Secret, dl.Payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+}
+
+func (d *Dispatcher) save(dl *delivery) {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		v, err := json.Marshal(dl)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(outboxBucket).Put(dl.key, v)
+	})
+	if err != nil {
+		logger.Logger.Error("Could not update webhook notification", zap.String("delivery", dl.ID), zap.Error(err))
+	}
+}
+
+func (d *Dispatcher) remove(dl *delivery) {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete(dl.key)
+	})
+	if err != nil {
+		logger.Logger.Error("Could not remove webhook notification", zap.String("delivery", dl.ID), zap.Error(err))
+	}
+}
+
+// Sign returns the value of the signature header of the payload: sha256= followed by the
+// hex encoded HMAC-SHA256 of the payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before the next attempt, doubling after each failed attempt
+func backoff(c *config.WebhooksConfig, attempts int) time.Duration {
+	delay := c.InitialBackoff
+	for i := 1; i < attempts && delay < c.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.MaxBackoff {
+		delay = c.MaxBackoff
+	}
+	return delay
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/hartfordfive/prom-http-sd-server/config"
+	"github.com/hartfordfive/prom-http-sd-server/logger"
+	"github.com/hartfordfive/prom-http-sd-server/store"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// receiver is a webhook endpoint recording the requests it receives, and failing the first ones
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, failures int) (*receiver, *httptest.Server) {
+	rcv := &receiver{failures: failures, received: make(chan struct{}, 100)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		fail := len(rcv.requests) <= rcv.failures
+		rcv.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rcv.received <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv
+}
+
+// wait waits for the receiver to accept a notification, and returns it
+func (rcv *receiver) wait(t *testing.T) *Notification {
+	t.Helper()
+	select {
+	case <-rcv.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	n := &Notification{}
+	if err := json.Unmarshal(rcv.bodies[len(rcv.bodies)-1], n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// waitDelivered waits for the outbox to be empty, once the endpoint's response was received
+func waitDelivered(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		pending := 0
+		d.db.View(func(tx *bolt.Tx) error {
+			pending = tx.Bucket(outboxBucket).Stats().KeyN
+			return nil
+		})
+		if pending == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("the outbox isn't empty")
+}
+
+func setupWebhookTest(t *testing.T, url string) string {
+	t.Helper()
+	logger.Logger = zap.NewNop()
+	outboxPath := filepath.Join(t.TempDir(), "outbox.db")
+	previous := config.Current()
+	config.SetCurrent(&config.Config{Webhooks: &config.WebhooksConfig{
+		OutboxPath:     outboxPath,
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		Endpoints:      []config.WebhookEndpoint{{Name: "hook", URL: url, Secret: "s3cret"}},
+	}})
+	t.Cleanup(func() { config.SetCurrent(previous) })
+	return outboxPath
+}
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	rcv, srv := newReceiver(t, 1)
+	d, err := NewDispatcher(setupWebhookTest(t, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.deliverLoop(ctx)
+
+	d.queue([]*store.Event{{Revision: 7, Type: store.EventTargetAdded, Group: "web", Target: "a:80"}}, 0)
+	n := rcv.wait(t)
+	if n.Revision != 7 || len(n.Events) != 1 || n.Events[0].Target != "a:80" {
+		t.Errorf("got notification %+v", n)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.requests) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(rcv.requests))
+	}
+	if first, second := rcv.requests[0].Header.Get(DeliveryHeader), rcv.requests[1].Header.Get(DeliveryHeader); first != n.ID || second != n.ID {
+		t.Errorf("got delivery IDs %s and %s, want %s", first, second, n.ID)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(rcv.bodies[1])
+	if got, want := rcv.requests[1].Header.Get(SignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+}
+
+func TestDispatcherResumesAfterRestart(t *testing.T) {
+	rcv, srv := newReceiver(t, 0)
+	outboxPath := setupWebhookTest(t, srv.URL)
+	ds, err := store.NewBoltDBDataStore(filepath.Join(t.TempDir(), "store.db"), make(chan bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Shutdown()
+	core, logs := observer.New(zap.WarnLevel)
+	logger.Logger = zap.New(core)
+	if err := ds.AddTargetToGroup(context.Background(), "db", "db:5432"); err != nil {
+		t.Fatal(err)
+	}
+
+	// run runs a watcher and a dispatcher until stop is called, like the server does
+	run := func() (*Dispatcher, func()) {
+		d, err := NewDispatcher(outboxPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		watcher := store.NewWatcher(ds, 100)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			watcher.Run(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			d.Run(ctx, watcher)
+		}()
+		return d, func() {
+			cancel()
+			wg.Wait()
+			d.Close()
+		}
+	}
+	// addTarget adds a target once the dispatcher subscribed to the watcher, which it retries
+	// every second until the watcher is ready
+	addTarget := func(target string) {
+		time.Sleep(1500 * time.Millisecond)
+		if err := ds.AddTargetToGroup(context.Background(), "web", target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, stop := run()
+	addTarget("a:80")
+	if n := rcv.wait(t); n.Events[0].Target != "a:80" {
+		t.Errorf("got notification %+v", n)
+	}
+	waitDelivered(t, d)
+	stop()
+
+	// A notification left in the outbox is delivered after a restart
+	d, err = NewDispatcher(outboxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.queue([]*store.Event{{Revision: d.lastRevision(), Type: store.EventTargetAdded, Group: "web", Target: "b:80"}}, 0)
+	d.Close()
+
+	d, stop = run()
+	if n := rcv.wait(t); n.Events[0].Target != "b:80" {
+		t.Errorf("got notification %+v", n)
+	}
+	// The changes already notified aren't notified again
+	addTarget("c:80")
+	if n := rcv.wait(t); len(n.Events) != 1 || n.Events[0].Target != "c:80" {
+		t.Errorf("got notification %+v", n)
+	}
+	waitDelivered(t, d)
+	stop()
+	if n := logs.FilterMessageSnippet("missed").Len(); n != 0 {
+		t.Errorf("got %d warnings of missed notifications", n)
+	}
+
+	// The changes made while the server is stopped are reported as missed
+	if err := ds.AddTargetToGroup(context.Background(), "web", "d:80"); err != nil {
+		t.Fatal(err)
+	}
+	_, stop = run()
+	defer stop()
+	addTarget("e:80")
+	if n := rcv.wait(t); len(n.Events) != 1 || n.Events[0].Target != "e:80" {
+		t.Errorf("got notification %+v", n)
+	}
+	if n := logs.FilterMessageSnippet("missed").Len(); n != 1 {
+		t.Errorf("got %d warnings of missed notifications, want 1", n)
+	}
+}